@@ -4,6 +4,11 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net/url"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	calapi "google.golang.org/api/calendar/v3"
 	"google.golang.org/api/option"
@@ -12,6 +17,8 @@ import (
 	calendarpkg "calendar-assistant-bot/pkg/calendar"
 	"calendar-assistant-bot/pkg/config"
 	"calendar-assistant-bot/pkg/database"
+	"calendar-assistant-bot/pkg/metrics"
+	"calendar-assistant-bot/pkg/server"
 	"calendar-assistant-bot/pkg/telegram"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -21,7 +28,17 @@ import (
 type Bot struct {
 	aiAgent     *ai.Agent
 	telegramBot *telegram.Bot
+	calendar    *calendarpkg.Service
+	database    database.Store
+	backups     *database.Backups
+	retention   *database.Retention
 	config      *config.Config
+	server      *server.Server
+	webhook     *telegram.Webhook
+	watcher     *calendarpkg.Watcher
+
+	// background tracks periodic jobs, which must stop before the database is closed
+	background sync.WaitGroup
 }
 
 // NewBot creates a new bot instance with all components
@@ -33,18 +50,33 @@ func NewBot(cfg *config.Config) (*Bot, error) {
 	openaiService := ai.NewOpenAIService(cfg.OpenAIKey)
 	log.Printf("OpenAI service created successfully")
 
-	// Create Google Calendar service
+	// Create the shared calendar tool, unless every user connects their own Google account
 	ctx := context.Background()
-	log.Printf("Creating Google Calendar service with credentials file: %s", cfg.GoogleCreds)
-	calendarService, err := calapi.NewService(ctx, option.WithCredentialsFile(cfg.GoogleCreds))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create calendar service: %v", err)
+	var calendarTool *calendarpkg.Service
+	switch {
+	case cfg.CalendarBackend == config.BackendMicrosoft:
+		log.Printf("Creating Microsoft 365 calendar backend for user %s", cfg.MicrosoftUser)
+		client := calendarpkg.NewGraphClient(ctx, cfg.MicrosoftTenantID, cfg.MicrosoftClientID, cfg.MicrosoftClientSecret)
+		calendarTool = calendarpkg.NewService(ctx, calendarpkg.NewGraphBackend(client, "", cfg.MicrosoftUser), cfg.CalendarID)
+		log.Printf("Microsoft 365 calendar tool created successfully")
+	case cfg.GoogleCreds != "":
+		log.Printf("Creating Google Calendar service with credentials file: %s", cfg.GoogleCreds)
+		calendarService, err := calapi.NewService(ctx, option.WithCredentialsFile(cfg.GoogleCreds))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create calendar service: %v", err)
+		}
+		log.Printf("Google Calendar service created successfully")
+
+		calendarTool = calendarpkg.NewService(ctx, calendarpkg.NewGoogleBackend(calendarService), cfg.CalendarID)
+		log.Printf("Google Calendar tool created successfully")
 	}
-	log.Printf("Google Calendar service created successfully")
 
-	// Create Google Calendar tool
-	calendarTool := calendarpkg.NewService(calendarService, cfg.CalendarID)
-	log.Printf("Google Calendar tool created successfully")
+	// Let users connect their own Google accounts with /login
+	var oauth *calendarpkg.OAuth
+	if cfg.OAuthEnabled() {
+		oauth = calendarpkg.NewOAuth(cfg.GoogleOAuthClientID, cfg.GoogleOAuthClientSecret, cfg.GoogleOAuthRedirectURL)
+		log.Printf("Google login enabled")
+	}
 
 	// Create Telegram bot
 	telegramBot, err := telegram.NewBot(cfg.TelegramToken)
@@ -54,54 +86,305 @@ func NewBot(cfg *config.Config) (*Bot, error) {
 	log.Printf("Telegram bot created successfully: %s", telegramBot.GetBotInfo().UserName)
 
 	// Create database
-	database, err := database.NewDatabase("./data")
+	keys, err := database.LoadKeyring(cfg.EncryptionKeys, cfg.EncryptionKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load encryption keys: %v", err)
+	}
+	store, err := database.Open(cfg.DatabaseDriver, cfg.DataDir, keys)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create database: %v", err)
 	}
 	log.Printf("Database created successfully")
 
 	// Create AI agent
-	aiAgent := ai.NewAgent(openaiService, calendarTool, telegramBot, database)
+	aiAgent := ai.NewAgent(openaiService, calendarTool, oauth, telegramBot, store, cfg.HistoryTokenBudget)
 	log.Printf("AI agent created successfully")
 
-	return &Bot{
+	// Let users subscribe to changes of their calendars with /notifications
+	var watcher *calendarpkg.Watcher
+	if cfg.WatchEnabled() {
+		watcher = calendarpkg.NewWatcher(cfg.CalendarWatchURL, cfg.CalendarWatchSecret, aiAgent.NotifyChanges)
+		aiAgent.EnableNotifications(watcher)
+		log.Printf("Change notifications enabled")
+	}
+
+	bot := &Bot{
 		aiAgent:     aiAgent,
 		telegramBot: telegramBot,
+		calendar:    calendarTool,
+		database:    store,
+		backups:     database.NewBackups(store, database.DataFile(cfg.DatabaseDriver, cfg.DataDir), cfg.BackupRetention),
+		retention:   database.NewRetention(store, cfg.HistoryMaxAge, cfg.HistoryMaxPerUser),
 		config:      cfg,
-	}, nil
+		server:      server.NewServer(cfg.Port),
+		watcher:     watcher,
+	}
+	if err := bot.registerRoutes(); err != nil {
+		return nil, err
+	}
+
+	return bot, nil
 }
 
-// handleMessage processes incoming Telegram messages
-func (b *Bot) handleMessage(update tgbotapi.Update) {
-	if update.Message == nil {
+// registerRoutes registers the health, readiness and metrics endpoints, the Google login callback
+// and the endpoint receiving calendar change notifications
+func (b *Bot) registerRoutes() error {
+	checks := map[string]server.Check{
+		"telegram": func(ctx context.Context) error {
+			return b.telegramBot.Ping()
+		},
+		"database": func(ctx context.Context) error {
+			return b.database.CheckWritable()
+		},
+	}
+	if b.calendar != nil {
+		checks["calendar"] = b.calendar.Ping
+	}
+
+	b.server.Handle("/healthz", server.HealthHandler())
+	b.server.Handle("/readyz", server.ReadinessHandler(checks))
+	b.server.Handle("/metrics", metrics.Handler())
+
+	if b.config.OAuthEnabled() {
+		redirectURL, err := url.Parse(b.config.GoogleOAuthRedirectURL)
+		if err != nil {
+			return fmt.Errorf("invalid Google login redirect URL: %v", err)
+		}
+		b.server.Handle(redirectURL.Path, b.aiAgent.OAuthCallbackHandler())
+		log.Printf("Receiving Google login callbacks on %s", redirectURL.Path)
+	}
+
+	if b.watcher != nil {
+		watchURL, err := url.Parse(b.config.CalendarWatchURL)
+		if err != nil {
+			return fmt.Errorf("invalid calendar watch URL: %v", err)
+		}
+		b.server.Handle(watchURL.Path, b.watcher.Handler())
+		log.Printf("Receiving calendar change notifications on %s", watchURL.Path)
+	}
+	return nil
+}
+
+// handleUpdate routes an incoming Telegram update to its handler
+func (b *Bot) handleUpdate(ctx context.Context, update tgbotapi.Update) {
+	switch {
+	case update.Message != nil:
+		b.handleMessage(ctx, update)
+	case update.CallbackQuery != nil:
+		b.handleCallback(ctx, update)
+	}
+}
+
+// handleCallback processes inline keyboard button presses
+func (b *Bot) handleCallback(ctx context.Context, update tgbotapi.Update) {
+	query := update.CallbackQuery
+	if query.Message == nil {
 		return
 	}
 
+	userID := query.From.ID
+	chatID := query.Message.Chat.ID
+	log.Printf("Received callback from user %d (chatID %d): %s", userID, chatID, query.Data)
+
+	metrics.HandlersInFlight.Inc()
+	defer metrics.HandlersInFlight.Dec()
+
+	// Stop the button's loading indicator right away; the answer follows as a message
+	if err := b.telegramBot.AnswerCallbackQuery(query.ID, ""); err != nil {
+		log.Printf("Failed to answer callback query: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, b.config.RequestTimeout)
+	defer cancel()
+
+	if err := b.aiAgent.HandleCalendarCallback(ctx, userID, chatID, query.Data); err != nil {
+		log.Printf("Error handling callback for user %d: %v", userID, err)
+	}
+}
+
+// handleMessage processes incoming Telegram messages
+func (b *Bot) handleMessage(ctx context.Context, update tgbotapi.Update) {
 	userID := update.Message.From.ID
 	message := update.Message.Text
 	chatID := update.Message.Chat.ID
 
 	log.Printf("Received message from user %d (chatID %d): %s", userID, chatID, message)
 
+	metrics.MessagesReceived.Inc()
+	metrics.HandlersInFlight.Inc()
+	defer metrics.HandlersInFlight.Dec()
+
+	ctx, cancel := context.WithTimeout(ctx, b.config.RequestTimeout)
+	defer cancel()
+
+	if update.Message.IsCommand() {
+		handled, err := b.aiAgent.HandleCommand(ctx, userID, chatID, update.Message.Command(), update.Message.CommandArguments())
+		if err != nil {
+			log.Printf("Error handling command for user %d: %v", userID, err)
+		}
+		if handled {
+			return
+		}
+	}
+
 	// Process message through AI agent
-	if err := b.aiAgent.ProcessUserMessage(userID, chatID, message); err != nil {
+	if err := b.aiAgent.ProcessUserMessage(ctx, userID, chatID, message); err != nil {
 		log.Printf("Error processing message for user %d: %v", userID, err)
 	}
 }
 
-// startBot starts the Telegram bot
-func (b *Bot) startBot() error {
-	updates := b.telegramBot.GetUpdatesChan()
+// receiveUpdates starts receiving updates in the configured mode and returns the updates channel
+func (b *Bot) receiveUpdates() (<-chan tgbotapi.Update, error) {
+	if b.config.BotMode != config.ModeWebhook {
+		log.Printf("Receiving updates via long polling")
+		return b.telegramBot.GetUpdatesChan(), nil
+	}
+
+	webhookURL, err := url.Parse(b.config.WebhookURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook URL: %v", err)
+	}
+	b.webhook = b.telegramBot.NewWebhook(b.config.WebhookSecret)
+	b.server.Handle(webhookURL.Path, b.webhook)
+
+	if err := b.telegramBot.SetWebhook(b.config.WebhookURL, b.config.WebhookSecret); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Receiving updates via webhook on %s", webhookURL.Path)
+	return b.webhook.Updates(), nil
+}
+
+// startBot starts the Telegram bot and handles updates until ctx is canceled, then shuts down gracefully
+func (b *Bot) startBot(ctx context.Context) error {
+	if err := b.server.Start(); err != nil {
+		return err
+	}
+
+	updates, err := b.receiveUpdates()
+	if err != nil {
+		return err
+	}
+
+	// Handlers keep running after the shutdown signal so they can finish; they are only
+	// canceled once the shutdown timeout expires
+	handlerCtx, cancelHandlers := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelHandlers()
+
+	pool := newWorkerPool(b.config.WorkerPoolSize, b.config.WorkerQueueSize)
+	pool.start(handlerCtx, b.handleUpdate)
+
+	b.runBackground(func() { b.retention.Run(ctx, b.config.RetentionInterval) })
+	if b.config.BackupInterval > 0 {
+		b.runBackground(func() { b.backups.Run(ctx, b.config.BackupInterval) })
+	}
+	if b.watcher != nil {
+		b.runBackground(func() {
+			b.aiAgent.ResumeNotifications(ctx)
+			b.watcher.Run(ctx, b.config.CalendarWatchInterval)
+		})
+	}
 
 	log.Printf("Bot started. Listening for messages...")
 
-	for update := range updates {
-		go b.handleMessage(update)
+	// An update that could not be queued before the shutdown signal is handled during the drain
+	var pending []tgbotapi.Update
+
+receive:
+	for {
+		select {
+		case <-ctx.Done():
+			break receive
+		case update, ok := <-updates:
+			if !ok {
+				break receive
+			}
+			if !pool.submit(ctx, update) {
+				pending = append(pending, update)
+				break receive
+			}
+		}
 	}
 
+	b.stopBot(updates, pending, pool, cancelHandlers)
 	return nil
 }
 
+// runBackground runs a periodic job that stops when the context it was given is done
+func (b *Bot) runBackground(job func()) {
+	b.background.Add(1)
+	go func() {
+		defer b.background.Done()
+		job()
+	}()
+}
+
+// cancelGrace is how long handlers canceled at the shutdown deadline get to return before the
+// database is closed
+const cancelGrace = 5 * time.Second
+
+// stopBot stops receiving updates, drains in-flight handlers within the shutdown timeout
+// and flushes the database
+func (b *Bot) stopBot(updates <-chan tgbotapi.Update, pending []tgbotapi.Update, pool *workerPool, cancelHandlers context.CancelFunc) {
+	log.Printf("Stopping bot...")
+
+	if b.webhook != nil {
+		b.webhook.Close()
+		if err := b.telegramBot.DeleteWebhook(); err != nil {
+			log.Printf("Failed to deregister webhook: %v", err)
+		}
+	} else {
+		b.telegramBot.StopReceivingUpdates()
+	}
+
+	deadline := time.Now().Add(b.config.ShutdownTimeout)
+	drainCtx, cancelDrain := context.WithDeadline(context.Background(), deadline)
+	defer cancelDrain()
+
+	for _, update := range pending {
+		pool.submit(drainCtx, update)
+	}
+
+	// Updates already buffered were acknowledged to Telegram, so handle them too
+drain:
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok || !pool.submit(drainCtx, update) {
+				break drain
+			}
+		default:
+			break drain
+		}
+	}
+
+	log.Printf("Waiting for in-flight handlers to finish...")
+	if !pool.stop(time.Until(deadline)) {
+		log.Printf("Shutdown timeout of %s expired, canceling remaining handlers", b.config.ShutdownTimeout)
+		cancelHandlers()
+		if !pool.wait(cancelGrace) {
+			log.Printf("Handlers still running %s after being canceled, closing the database anyway", cancelGrace)
+		}
+	}
+
+	// Summaries get what is left of the shutdown timeout, but at least the grace period
+	stopCtx, cancelStop := context.WithTimeout(context.Background(), max(time.Until(deadline), cancelGrace))
+	defer cancelStop()
+	b.aiAgent.Stop(stopCtx)
+	b.background.Wait()
+	if err := b.database.Close(); err != nil {
+		log.Printf("Failed to flush database: %v", err)
+	}
+
+	serverCtx, cancelServer := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelServer()
+	if err := b.server.Shutdown(serverCtx); err != nil {
+		log.Printf("Error stopping HTTP server: %v", err)
+	}
+
+	log.Printf("Bot stopped")
+}
+
 func main() {
 	cfg, err := config.Load()
 	if err != nil {
@@ -115,7 +398,10 @@ func main() {
 
 	log.Printf("Starting calendar assistant bot...")
 
-	if err := bot.startBot(); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := bot.startBot(ctx); err != nil {
 		log.Fatalf("Bot error: %v", err)
 	}
 }
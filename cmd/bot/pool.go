@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// workerPool handles updates with a fixed number of workers fed by a bounded queue.
+// Updates from the same chat are handled one at a time and in the order they were
+// submitted, while different chats are handled in parallel.
+type workerPool struct {
+	size  int
+	queue chan tgbotapi.Update
+	slots chan struct{}
+	wg    sync.WaitGroup
+
+	// backlog holds the updates waiting behind the one currently being handled for each chat.
+	// A chat has an entry (possibly empty) for as long as one of its updates is being handled.
+	mutex   sync.Mutex
+	backlog map[int64][]tgbotapi.Update
+}
+
+// newWorkerPool creates a worker pool with the given number of workers and queue capacity
+func newWorkerPool(size, queueSize int) *workerPool {
+	return &workerPool{
+		size:    size,
+		queue:   make(chan tgbotapi.Update, queueSize),
+		slots:   make(chan struct{}, queueSize),
+		backlog: make(map[int64][]tgbotapi.Update),
+	}
+}
+
+// chatKey returns the key updates are serialized by
+func chatKey(update tgbotapi.Update) int64 {
+	if chat := update.FromChat(); chat != nil {
+		return chat.ID
+	}
+	if user := update.SentFrom(); user != nil {
+		return user.ID
+	}
+	return 0
+}
+
+// start launches the workers. Each update is handled with ctx, which should outlive
+// the shutdown signal so that in-flight handlers can finish.
+func (p *workerPool) start(ctx context.Context, handle func(context.Context, tgbotapi.Update)) {
+	for i := 0; i < p.size; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for update := range p.queue {
+				p.handleChat(ctx, update, handle)
+			}
+		}()
+	}
+
+	log.Printf("Worker pool started with %d workers (queue size %d)", p.size, cap(p.queue))
+}
+
+// handleChat handles update and then every update queued behind it for the same chat
+func (p *workerPool) handleChat(ctx context.Context, update tgbotapi.Update, handle func(context.Context, tgbotapi.Update)) {
+	key := chatKey(update)
+	for {
+		<-p.slots
+		handle(ctx, update)
+
+		p.mutex.Lock()
+		pending := p.backlog[key]
+		if len(pending) == 0 {
+			delete(p.backlog, key)
+			p.mutex.Unlock()
+			return
+		}
+		update = pending[0]
+		p.backlog[key] = pending[1:]
+		p.mutex.Unlock()
+	}
+}
+
+// submit queues an update, blocking while the queue is full.
+// It returns false if ctx is done before the update could be queued.
+// submit must not be called concurrently, since the call order defines the per-chat order.
+func (p *workerPool) submit(ctx context.Context, update tgbotapi.Update) bool {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return false
+	}
+
+	key := chatKey(update)
+
+	p.mutex.Lock()
+	if pending, busy := p.backlog[key]; busy {
+		// A worker is handling this chat and will pick the update up when it is done
+		p.backlog[key] = append(pending, update)
+		p.mutex.Unlock()
+		return true
+	}
+	p.backlog[key] = nil
+	p.mutex.Unlock()
+
+	// Holding a slot guarantees there is room in the queue
+	p.queue <- update
+	return true
+}
+
+// stop closes the queue and waits up to timeout for queued and in-flight updates to be handled.
+// It returns false if the timeout expired first.
+func (p *workerPool) stop(timeout time.Duration) bool {
+	close(p.queue)
+	return p.wait(timeout)
+}
+
+// wait waits up to timeout for the workers to exit after stop and reports whether they did
+func (p *workerPool) wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
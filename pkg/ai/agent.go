@@ -3,43 +3,92 @@ package ai
 import (
 	"calendar-assistant-bot/pkg/calendar"
 	"calendar-assistant-bot/pkg/database"
+	"calendar-assistant-bot/pkg/metrics"
+	"calendar-assistant-bot/pkg/resilience"
 	"calendar-assistant-bot/pkg/telegram"
 	"calendar-assistant-bot/pkg/types"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
 // Agent coordinates between all tools and handles the main logic
 type Agent struct {
 	openaiService   *OpenAIService
 	calendarService *calendar.Service
+	oauth           *calendar.OAuth
 	telegramBot     *telegram.Bot
-	database        *database.Database
+	database        database.Store
+	memory          *memory
+
+	// accounts holds the calendar services of connected Google accounts, logins the pending /login links
+	accountsMutex sync.Mutex
+	accounts      map[int64]*calendar.Service
+	logins        map[string]pendingLogin
+
+	// watcher reports calendar changes to the users subscribed with /notifications; nil if disabled
+	watcher          *calendar.Watcher
+	subscribersMutex sync.Mutex
 }
 
-// NewAgent creates a new AI agent instance
-func NewAgent(openaiService *OpenAIService, calendarService *calendar.Service, telegramBot *telegram.Bot, database *database.Database) *Agent {
+// NewAgent creates a new AI agent instance.
+// calendarService is the shared calendar and may be nil if every user connects their own Google
+// account through oauth; oauth may be nil to disable /login.
+// historyTokenBudget limits the conversation history sent to the model; older turns are summarized.
+func NewAgent(openaiService *OpenAIService, calendarService *calendar.Service, oauth *calendar.OAuth, telegramBot *telegram.Bot, database database.Store, historyTokenBudget int) *Agent {
 	return &Agent{
 		openaiService:   openaiService,
 		calendarService: calendarService,
+		oauth:           oauth,
 		telegramBot:     telegramBot,
 		database:        database,
+		memory:          newMemory(database, openaiService, historyTokenBudget),
+		accounts:        make(map[int64]*calendar.Service),
+		logins:          make(map[string]pendingLogin),
 	}
 }
 
 // ProcessUserMessage handles a complete user message flow
-func (a *Agent) ProcessUserMessage(userID int64, chatID int64, message string) error {
+func (a *Agent) ProcessUserMessage(ctx context.Context, userID int64, chatID int64, message string) error {
 	log.Printf("Processing message from user %d: %s", userID, message)
 
-	// Get user context from database
-	userContext := a.database.GetUserContext(userID, 10)
+	// Users without a calendar are asked to connect one before anything reaches the model
+	cal, err := a.calendarFor(userID)
+	if err != nil {
+		if err := a.telegramBot.SendMessage(replyContext(ctx), chatID, calendarError("Sorry, I couldn't open your calendar", err)); err != nil {
+			log.Printf("Failed to send error message: %v", err)
+		}
+		if errors.Is(err, errNotConnected) {
+			return nil
+		}
+		return err
+	}
+
+	// An answer to a question about a pending request completes it without the model
+	if handled, err := a.continueDialogue(ctx, userID, chatID, cal, message); handled {
+		return err
+	}
+
+	// Get conversation history from database, after what the model should know about the user's calendars
+	history := append(a.calendarsContext(userID), a.memory.history(userID)...)
 
-	// Send message to OpenAI for processing
-	aiResponse, err := a.openaiService.ProcessMessage(userContext, message)
+	// Send message to OpenAI for processing, and have it fix responses that break the action schema
+	aiResponse, problems, err := a.decide(ctx, userID, history, message)
 	if err != nil {
 		log.Printf("AI processing error for user %d: %v", userID, err)
 		errorMsg := "Sorry, I encountered an error processing your request. Please try again."
-		if err := a.telegramBot.SendMessage(chatID, errorMsg); err != nil {
+		if ctx.Err() != nil {
+			errorMsg = "Sorry, that took too long. Please try again."
+		} else if resilience.IsUnavailable(err) {
+			errorMsg = "Sorry, the assistant is temporarily unavailable. Please try again in a few minutes."
+		}
+		if err := a.telegramBot.SendMessage(replyContext(ctx), chatID, errorMsg); err != nil {
 			log.Printf("Failed to send error message: %v", err)
 		}
 		return err
@@ -47,39 +96,72 @@ func (a *Agent) ProcessUserMessage(userID int64, chatID int64, message string) e
 
 	log.Printf("AI response for user %d: Action=%s, Message=%s, EventDate='%s'", userID, aiResponse.Action, aiResponse.Message, aiResponse.EventDate)
 
-	// Store the interaction in database
-	log.Printf("About to save interaction to database for user %d", userID)
-	if err := a.database.AddInteraction(userID, message, aiResponse.Message, aiResponse.Action); err != nil {
-		log.Printf("Failed to store interaction for user %d: %v", userID, err)
-	}
-	log.Printf("Successfully saved interaction to database for user %d", userID)
-
 	log.Printf("About to execute AI action for user %d", userID)
 
-	// Execute the AI's decision
-	log.Printf("Calling executeAIAction for user %d", userID)
-	response, err := a.executeAIAction(userID, aiResponse)
-	log.Printf("executeAIAction returned for user %d: response='%s', err=%v", userID, response, err)
-	if err != nil {
-		log.Printf("Error executing AI action for user %d: %v", userID, err)
-		response = fmt.Sprintf("Error executing action: %v", err)
+	var response, results string
+	var keyboard *tgbotapi.InlineKeyboardMarkup
+	if len(problems) > 0 {
+		// Ask for what is missing rather than act on a response that breaks the schema; a request
+		// that only lacks what the user can give waits for the answer
+		response, results = a.clarify(userID, chatID, aiResponse, problems)
+		log.Printf("Asking user %d to clarify: %s", userID, response)
+		aiResponse = &types.AIResponse{Action: "None", Message: response}
+	} else {
+		// Execute the AI's decision
+		log.Printf("Calling executeAIAction for user %d", userID)
+		response, keyboard, results, err = a.executeAIAction(ctx, userID, chatID, cal, aiResponse)
+		log.Printf("executeAIAction returned for user %d: response='%s', err=%v", userID, response, err)
+		if err != nil {
+			log.Printf("Error executing AI action for user %d: %v", userID, err)
+			response = fmt.Sprintf("Error executing action: %v", err)
+		}
 	}
 
-	// Send response to user
-	log.Printf("About to send response to Telegram for user %d: %s", userID, response)
-	if err := a.telegramBot.SendMessage(chatID, response); err != nil {
-		log.Printf("Failed to send response to user %d: %v", userID, err)
-		return err
+	return a.finishTurn(ctx, chatID, types.Interaction{
+		UserID:      userID,
+		UserMessage: message,
+		AIResponse:  aiResponse.Message,
+		Action:      aiResponse.Action,
+		ToolResults: results,
+		Reply:       response,
+	}, keyboard)
+}
+
+// calendarUnavailableMessage is shown instead of the raw error while Google Calendar keeps failing
+const calendarUnavailableMessage = "The calendar is temporarily unavailable. Please try again in a few minutes."
+
+// calendarError formats a calendar error for the user
+func calendarError(prefix string, err error) string {
+	if resilience.IsUnavailable(err) {
+		return calendarUnavailableMessage
+	}
+	if errors.Is(err, errNotConnected) {
+		return "Please send /login to connect your Google Calendar first."
 	}
-	log.Printf("Successfully sent response to Telegram for user %d", userID)
+	if calendar.IsAuthError(err) {
+		return "Google no longer accepts your login. Please send /login to connect your account again."
+	}
+	return fmt.Sprintf("%s: %v", prefix, err)
+}
 
-	return nil
+// replyContext returns the context to send a reply with. If the request has already run out
+// of time, the reply is still sent so the user is not left without an answer.
+func replyContext(ctx context.Context) context.Context {
+	if ctx.Err() != nil {
+		return context.WithoutCancel(ctx)
+	}
+	return ctx
 }
 
-// executeAIAction executes the action decided by the AI
-func (a *Agent) executeAIAction(userID int64, aiResponse *types.AIResponse) (string, error) {
+// executeAIAction executes the action decided by the AI on the user's calendar cal.
+// It returns the reply for the user, the "Show more" buttons of long event lists, and the action
+// results, with event IDs, for the conversation history. Events that are listed are numbered and
+// stored for the chat, so later requests can refer to them.
+func (a *Agent) executeAIAction(ctx context.Context, userID int64, chatID int64, cal *calendar.Service, aiResponse *types.AIResponse) (string, *tgbotapi.InlineKeyboardMarkup, string, error) {
 	log.Printf("executeAIAction ENTRY for user %d", userID)
 	var response string
+	var results strings.Builder
+	var shown shownEvents
 	log.Printf("Executing action for user %d, Action=%s, Message=%s, EventDate=%s", userID, aiResponse.Action, aiResponse.Message, aiResponse.EventDate)
 
 	// Check if AI wants to perform multiple actions
@@ -91,232 +173,247 @@ func (a *Agent) executeAIAction(userID int64, aiResponse *types.AIResponse) (str
 			log.Printf("Executing action %d/%d: %s", i+1, len(aiResponse.Actions), action.Action)
 
 			switch action.Action {
-			case "getEvents":
-				events, err := a.calendarService.GetEvents(action.EventDate)
+			case "getEvents", "getEventsRange":
+				listing := eventListing{
+					Action:    action.Action,
+					EventDate: action.EventDate,
+					StartDate: action.StartDate,
+					EndDate:   action.EndDate,
+				}
+				if listing.Action == "getEventsRange" && listing.EndDate == "" {
+					listing.EndDate = listing.StartDate
+				}
+
+				reply, err := a.showEvents(ctx, cal, &shown, shown.add(listing), 1, &results)
+				recordAction(action.Action, err)
 				if err != nil {
 					log.Printf("Error getting events for user %d: %v", userID, err)
-					response += fmt.Sprintf("Error getting events for %s: %v\n", action.EventDate, err)
-				} else if len(events) == 0 {
-					response += fmt.Sprintf("No events found for %s.\n", action.EventDate)
+					response += calendarError(fmt.Sprintf("Error getting events %s", listing.subject()), err) + "\n"
+					fmt.Fprintf(&results, "%s failed: %v\n", listing.label(), err)
 				} else {
-					// Limit the number of events shown to prevent extremely long messages
-					maxEvents := 15 // Slightly lower for multiple actions
-					eventsToShow := events
-					if len(events) > maxEvents {
-						eventsToShow = events[:maxEvents]
-						response += fmt.Sprintf("Events for %s (showing first %d of %d):\n", action.EventDate, maxEvents, len(events))
-					} else {
-						response += fmt.Sprintf("Events for %s:\n", action.EventDate)
-					}
-
-					for _, event := range eventsToShow {
-						response += fmt.Sprintf("• %s (%s - %s)",
-							event.Summary,
-							event.Start.Format("15:04"),
-							event.End.Format("15:04"))
-						if event.Location != "" {
-							response += fmt.Sprintf(" - %s", event.Location)
-						}
-						response += "\n"
-					}
-
-					if len(events) > maxEvents {
-						response += fmt.Sprintf("... and %d more events.\n", len(events)-maxEvents)
-					}
-					response += "\n"
+					response += reply + "\n"
 				}
 			default:
+				recordAction("unknown", fmt.Errorf("unknown action"))
 				response += fmt.Sprintf("Unknown action: %s\n", action.Action)
+				fmt.Fprintf(&results, "%s: unknown action\n", action.Action)
 			}
 		}
 	} else {
 		// Single action (existing logic)
 		switch aiResponse.Action {
-		case "getEvents":
-			log.Printf("Getting events for user %d, date: '%s' (length: %d)", userID, aiResponse.EventDate, len(aiResponse.EventDate))
-			log.Printf("About to call Google Calendar API for user %d", userID)
-			events, err := a.calendarService.GetEvents(aiResponse.EventDate)
-			log.Printf("Google Calendar API call completed for user %d, err=%v, events count=%d", userID, err, len(events))
+		case "getEvents", "getEventsRange", "searchEvents":
+			listing := eventListing{
+				Action:    aiResponse.Action,
+				EventDate: aiResponse.EventDate,
+				StartDate: aiResponse.StartDate,
+				EndDate:   aiResponse.EndDate,
+			}
+			if listing.Action == "searchEvents" {
+				listing.Query = aiResponse.Query
+				listing.Attendee = aiResponse.Attendee
+				listing.Location = aiResponse.EventLoc
+			}
+			page := 1
+			if aiResponse.Page > 1 {
+				page = aiResponse.Page
+			}
+
+			log.Printf("Listing events for user %d: %s, page %d", userID, listing.label(), page)
+			var err error
+			response, err = a.showEvents(ctx, cal, &shown, shown.add(listing), page, &results)
+			recordAction(aiResponse.Action, err)
 			if err != nil {
 				log.Printf("Error getting events for user %d: %v", userID, err)
-				response = fmt.Sprintf("Error getting events: %v", err)
-			} else if len(events) == 0 {
-				log.Printf("No events found for user %d on %s", userID, aiResponse.EventDate)
-				response = fmt.Sprintf("No events found for %s.", aiResponse.EventDate)
-			} else {
-				log.Printf("Found %d events for user %d on %s", len(events), userID, aiResponse.EventDate)
-
-				// Limit the number of events shown to prevent extremely long messages
-				maxEvents := 20
-				eventsToShow := events
-				if len(events) > maxEvents {
-					eventsToShow = events[:maxEvents]
-					response = fmt.Sprintf("Events for %s (showing first %d of %d):\n", aiResponse.EventDate, maxEvents, len(events))
-				} else {
-					response = fmt.Sprintf("Events for %s:\n", aiResponse.EventDate)
-				}
-
-				for _, event := range eventsToShow {
-					response += fmt.Sprintf("• %s (%s - %s)",
-						event.Summary,
-						event.Start.Format("15:04"),
-						event.End.Format("15:04"))
-					if event.Location != "" {
-						response += fmt.Sprintf(" - %s", event.Location)
-					}
-					response += "\n"
-				}
-
-				if len(events) > maxEvents {
-					response += fmt.Sprintf("\n... and %d more events. Use a more specific date range to see fewer events.", len(events)-maxEvents)
-				}
+				response = calendarError("Error getting events", err)
+				fmt.Fprintf(&results, "%s failed: %v\n", listing.label(), err)
 			}
 
 		case "makeEvent":
 			log.Printf("Creating event for user %d: %s on %s at %s", userID, aiResponse.EventTitle, aiResponse.EventDate, aiResponse.EventTime)
-			err := a.calendarService.CreateEvent(aiResponse.EventTitle, aiResponse.EventDate, aiResponse.EventTime, aiResponse.EventDesc, aiResponse.EventLoc)
+			target, calendarName := cal, ""
+			var err error
+			if aiResponse.Calendar != "" {
+				var info types.CalendarInfo
+				target, info, err = a.calendarNamed(ctx, cal, aiResponse.Calendar)
+				calendarName = info.Name
+			}
+			if err == nil {
+				err = target.CreateEvent(ctx, aiResponse.EventTitle, aiResponse.EventDate, aiResponse.EventTime, aiResponse.EventDesc, aiResponse.EventLoc)
+			}
+			recordAction(aiResponse.Action, err)
 			if err != nil {
 				log.Printf("Error creating event for user %d: %v", userID, err)
-				response = fmt.Sprintf("Error creating event: %v", err)
+				response = calendarError("Error creating event", err)
+				fmt.Fprintf(&results, "makeEvent failed: %v\n", err)
 			} else {
 				log.Printf("Successfully created event for user %d", userID)
 				response = fmt.Sprintf("Event '%s' created successfully for %s at %s",
 					aiResponse.EventTitle, aiResponse.EventDate, aiResponse.EventTime)
+				if calendarName != "" {
+					response += fmt.Sprintf(" in the %s calendar", calendarName)
+				}
+				fmt.Fprintf(&results, "makeEvent: created %q on %s at %s in calendar %s\n", aiResponse.EventTitle, aiResponse.EventDate, aiResponse.EventTime, target.CalendarID())
 			}
 
 		case "delEvents":
-			if aiResponse.EventID == "" {
+			if aiResponse.EventID == "" && aiResponse.EventRef == "" {
 				log.Printf("User %d tried to delete event without specifying ID", userID)
-				response = "Please specify an event ID to delete. Use 'getEvents' first to see available events."
+				recordAction(aiResponse.Action, fmt.Errorf("missing event ID"))
+				response = "Please tell me which event to delete. Ask me to list your events first if needed."
+			} else if event, err := a.resolveEvent(chatID, aiResponse.EventID, aiResponse.EventRef); err != nil {
+				log.Printf("Could not resolve event for user %d: %v", userID, err)
+				recordAction(aiResponse.Action, err)
+				response = fmt.Sprintf("Sorry, I couldn't tell which event to delete: %v", err)
+				fmt.Fprintf(&results, "delEvents %q not resolved: %v\n", aiResponse.EventRef, err)
 			} else {
-				log.Printf("Deleting event %s for user %d", aiResponse.EventID, userID)
-				err := a.calendarService.DeleteEvent(aiResponse.EventID)
+				log.Printf("Deleting event %s for user %d", event.ID, userID)
+				err := cal.InCalendar(event.CalendarID).DeleteEvent(ctx, event.ID)
+				recordAction(aiResponse.Action, err)
 				if err != nil {
-					log.Printf("Error deleting event %s for user %d: %v", aiResponse.EventID, userID, err)
-					response = fmt.Sprintf("Error deleting event: %v", err)
+					log.Printf("Error deleting event %s for user %d: %v", event.ID, userID, err)
+					response = calendarError("Error deleting event", err)
+					fmt.Fprintf(&results, "delEvents %s failed: %v\n", event.ID, err)
 				} else {
-					log.Printf("Successfully deleted event %s for user %d", aiResponse.EventID, userID)
-					response = "Event deleted successfully."
+					log.Printf("Successfully deleted event %s for user %d", event.ID, userID)
+					response = eventDoneMessage(event, "deleted")
+					fmt.Fprintf(&results, "delEvents: deleted event id=%s\n", event.ID)
 				}
 			}
 
 		case "updtEvent":
-			if aiResponse.EventID == "" {
+			if aiResponse.EventID == "" && aiResponse.EventRef == "" {
 				log.Printf("User %d tried to update event without specifying ID", userID)
-				response = "Please specify an event ID to update. Use 'getEvents' first to see available events."
+				recordAction(aiResponse.Action, fmt.Errorf("missing event ID"))
+				response = "Please tell me which event to update. Ask me to list your events first if needed."
+			} else if event, err := a.resolveEvent(chatID, aiResponse.EventID, aiResponse.EventRef); err != nil {
+				log.Printf("Could not resolve event for user %d: %v", userID, err)
+				recordAction(aiResponse.Action, err)
+				response = fmt.Sprintf("Sorry, I couldn't tell which event to update: %v", err)
+				fmt.Fprintf(&results, "updtEvent %q not resolved: %v\n", aiResponse.EventRef, err)
 			} else {
-				log.Printf("Updating event %s for user %d", aiResponse.EventID, userID)
-				err := a.calendarService.UpdateEvent(aiResponse.EventID, aiResponse.EventTitle, aiResponse.EventDate, aiResponse.EventTime, aiResponse.EventDesc, aiResponse.EventLoc)
+				// Only what the user asked to change is sent: "move the first one to 5pm" keeps the
+				// event's date and duration, and everything but its times
+				date, startTime := aiResponse.EventDate, aiResponse.EventTime
+				if (date != "" || startTime != "") && !event.Start.IsZero() {
+					if date == "" {
+						date = event.Start.Format("2006-01-02")
+					}
+					if startTime == "" {
+						startTime = event.Start.Format("15:04")
+					}
+				}
+				var duration time.Duration
+				if !event.End.IsZero() {
+					duration = event.End.Sub(event.Start)
+				}
+
+				log.Printf("Updating event %s for user %d", event.ID, userID)
+				err := cal.InCalendar(event.CalendarID).UpdateEvent(ctx, event.ID, aiResponse.EventTitle, date, startTime, aiResponse.EventDesc, aiResponse.EventLoc, duration)
+				recordAction(aiResponse.Action, err)
 				if err != nil {
-					log.Printf("Error updating event %s for user %d: %v", aiResponse.EventID, userID, err)
-					response = fmt.Sprintf("Error updating event: %v", err)
+					log.Printf("Error updating event %s for user %d: %v", event.ID, userID, err)
+					response = calendarError("Error updating event", err)
+					fmt.Fprintf(&results, "updtEvent %s failed: %v\n", event.ID, err)
 				} else {
-					log.Printf("Successfully updated event %s for user %d", aiResponse.EventID, userID)
-					response = "Event updated successfully."
+					log.Printf("Successfully updated event %s for user %d", event.ID, userID)
+					response = eventDoneMessage(event, "updated")
+					fmt.Fprintf(&results, "updtEvent: updated event id=%s\n", event.ID)
 				}
 			}
 
 		case "None":
 			log.Printf("AI requested 'None' action for user %d", userID)
+			recordAction(aiResponse.Action, nil)
 			response = aiResponse.Message
 
 		case "message":
 			log.Printf("AI sent a simple message for user %d", userID)
+			recordAction(aiResponse.Action, nil)
 			response = aiResponse.Message
 
 		default:
 			log.Printf("No specific action for user %d, using AI message: %s", userID, aiResponse.Message)
+			recordAction("unknown", nil)
 			response = aiResponse.Message
 		}
 	}
 
+	// Remember the numbered list the user was shown, so "the second one" refers to it
+	if shown.listed {
+		a.saveShownEvents(chatID, &shown)
+	}
+
 	// Add logging to see what response we're about to return
 	log.Printf("Final response for user %d: %s", userID, response)
 
-	return response, nil
+	return response, shown.keyboard(), results.String(), nil
 }
 
-// HandleCalendarCallback handles calendar navigation callbacks
-func (a *Agent) HandleCalendarCallback(userID int64, chatID int64, callbackData string) error {
-	log.Printf("Handling calendar callback for user %d: %s", userID, callbackData)
-
-	var response string
-
-	switch callbackData {
-	case "calendar_today":
-		response = "Here are your events for today:"
-		events, err := a.calendarService.GetEvents("today")
-		if err != nil {
-			response = fmt.Sprintf("Error getting events: %v", err)
-		} else if len(events) == 0 {
-			response = "No events found for today."
-		} else {
-			// Limit events to prevent long messages
-			maxEvents := 15
-			eventsToShow := events
-			if len(events) > maxEvents {
-				eventsToShow = events[:maxEvents]
-				response += fmt.Sprintf("\n(showing first %d of %d):\n", maxEvents, len(events))
-			} else {
-				response += "\n"
-			}
-
-			for _, event := range eventsToShow {
-				response += fmt.Sprintf("• %s (%s - %s)",
-					event.Summary,
-					event.Start.Format("15:04"),
-					event.End.Format("15:04"))
-				if event.Location != "" {
-					response += fmt.Sprintf(" - %s", event.Location)
-				}
-				response += "\n"
-			}
-
-			if len(events) > maxEvents {
-				response += fmt.Sprintf("... and %d more events.", len(events)-maxEvents)
-			}
+// writeEventResults adds events to the action results, numbered from first in the order they were
+// shown, and returns the displayed events including them
+func writeEventResults(results *strings.Builder, label string, first int, events []types.CalendarEvent, displayed []types.DisplayedEvent) []types.DisplayedEvent {
+	fmt.Fprintf(results, "%s:\n", label)
+	for i, event := range events {
+		index := first + i
+		fmt.Fprintf(results, "%d. %s (%s - %s)", index, event.Summary,
+			event.Start.Format("2006-01-02 15:04"), event.End.Format("15:04"))
+		if event.Location != "" {
+			fmt.Fprintf(results, " at %s", event.Location)
+		}
+		if event.Calendar != "" {
+			fmt.Fprintf(results, " [%s]", event.Calendar)
 		}
+		fmt.Fprintf(results, " id=%s\n", event.ID)
+
+		displayed = append(displayed, types.DisplayedEvent{
+			Index:      index,
+			ID:         event.ID,
+			CalendarID: event.CalendarID,
+			Title:      event.Summary,
+			Start:      event.Start,
+			End:        event.End,
+		})
+	}
+	return displayed
+}
 
-	case "calendar_tomorrow":
-		response = "Here are your events for tomorrow:"
-		events, err := a.calendarService.GetEvents("tomorrow")
-		if err != nil {
-			response = fmt.Sprintf("Error getting events: %v", err)
-		} else if len(events) == 0 {
-			response = "No events found for tomorrow."
-		} else {
-			// Limit events to prevent long messages
-			maxEvents := 15
-			eventsToShow := events
-			if len(events) > maxEvents {
-				eventsToShow = events[:maxEvents]
-				response += fmt.Sprintf("\n(showing first %d of %d):\n", maxEvents, len(events))
-			} else {
-				response += "\n"
-			}
+// eventDoneMessage confirms an action on an event, naming it when its title is known
+func eventDoneMessage(event types.DisplayedEvent, done string) string {
+	if event.Title == "" {
+		return fmt.Sprintf("Event %s successfully.", done)
+	}
+	return fmt.Sprintf("Event '%s' %s successfully.", event.Title, done)
+}
 
-			for _, event := range eventsToShow {
-				response += fmt.Sprintf("• %s (%s - %s)",
-					event.Summary,
-					event.Start.Format("15:04"),
-					event.End.Format("15:04"))
-				if event.Location != "" {
-					response += fmt.Sprintf(" - %s", event.Location)
-				}
-				response += "\n"
-			}
+// recordAction counts an executed action in the metrics.
+// Action names come from the model, so anything unexpected is counted as "unknown" to bound label cardinality.
+func recordAction(action string, err error) {
+	switch action {
+	case "getEvents", "getEventsRange", "searchEvents", "makeEvent", "delEvents", "updtEvent", "None", "message":
+	default:
+		action = "unknown"
+	}
+	metrics.ActionsTotal.WithLabelValues(action, metrics.Status(err)).Inc()
+}
 
-			if len(events) > maxEvents {
-				response += fmt.Sprintf("... and %d more events.", len(events)-maxEvents)
-			}
-		}
+// HandleCalendarCallback handles calendar navigation and "Show more" callbacks
+func (a *Agent) HandleCalendarCallback(ctx context.Context, userID int64, chatID int64, callbackData string) error {
+	log.Printf("Handling calendar callback for user %d: %s", userID, callbackData)
 
+	var err error
+	switch {
+	case callbackData == "calendar_today":
+		err = a.showListing(ctx, userID, chatID, eventListing{Action: "getEvents", EventDate: "today"})
+	case callbackData == "calendar_tomorrow":
+		err = a.showListing(ctx, userID, chatID, eventListing{Action: "getEvents", EventDate: "tomorrow"})
+	case strings.HasPrefix(callbackData, moreEventsCallbackPrefix):
+		err = a.showMoreEvents(ctx, userID, chatID, callbackData)
 	default:
-		response = "Calendar navigation not implemented yet."
+		err = a.telegramBot.SendMessage(ctx, chatID, "Calendar navigation not implemented yet.")
 	}
 
-	// Send response to user
-	if err := a.telegramBot.SendMessage(chatID, response); err != nil {
+	if err != nil {
 		log.Printf("Failed to send calendar callback response: %v", err)
 		return err
 	}
@@ -329,7 +426,8 @@ func (a *Agent) GetUserStats(userID int64) map[string]interface{} {
 	return a.database.GetUserStats(userID)
 }
 
-// CleanupOldInteractions removes old interactions from the database
-func (a *Agent) CleanupOldInteractions(daysOld int) error {
-	return a.database.Cleanup(daysOld)
+// Stop waits until ctx is done for the conversation summaries still running, then cancels them.
+// Call it after the last message was handled and before the database is closed.
+func (a *Agent) Stop(ctx context.Context) {
+	a.memory.stop(ctx)
 }
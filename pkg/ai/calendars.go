@@ -0,0 +1,244 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"calendar-assistant-bot/pkg/calendar"
+	"calendar-assistant-bot/pkg/types"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// calendarSelectionStateKey is the state key of the calendars a user chose
+const calendarSelectionStateKey = "calendar_selection"
+
+// loadCalendarSelection returns the calendars a user chose, or false if they use the configured calendar
+func (a *Agent) loadCalendarSelection(userID int64) (types.CalendarSelection, bool) {
+	var selection types.CalendarSelection
+	found, err := a.database.GetState(userID, calendarSelectionStateKey, &selection)
+	if err != nil {
+		log.Printf("Failed to load calendar selection of user %d: %v", userID, err)
+		return selection, false
+	}
+	return selection, found && len(selection.Read) > 0
+}
+
+// calendarFor returns the calendar service reading from and writing to the calendars a user chose
+func (a *Agent) calendarFor(userID int64) (*calendar.Service, error) {
+	cal, err := a.userCalendar(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	selection, ok := a.loadCalendarSelection(userID)
+	if !ok {
+		return cal, nil
+	}
+	return cal.WithCalendars(selection.Read, selection.Write.ID), nil
+}
+
+// calendarsContext tells the model which calendars a user reads from, so it can name them
+func (a *Agent) calendarsContext(userID int64) []openai.ChatCompletionMessage {
+	selection, ok := a.loadCalendarSelection(userID)
+	if !ok {
+		return nil
+	}
+
+	names := make([]string, len(selection.Read))
+	for i, info := range selection.Read {
+		names[i] = info.Name
+	}
+	content := fmt.Sprintf("The user's calendars: %s. New events go to %s unless the user names another calendar.",
+		strings.Join(names, ", "), selection.Write.Name)
+	return []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleSystem, Content: content}}
+}
+
+// calendarNamed returns a view of cal writing to the writable calendar matching name
+func (a *Agent) calendarNamed(ctx context.Context, cal *calendar.Service, name string) (*calendar.Service, types.CalendarInfo, error) {
+	calendars, err := cal.ListCalendars(ctx)
+	if err != nil {
+		return nil, types.CalendarInfo{}, err
+	}
+
+	info, err := matchCalendar(calendars, name)
+	if err != nil {
+		return nil, types.CalendarInfo{}, err
+	}
+	if !info.Writable {
+		return nil, types.CalendarInfo{}, fmt.Errorf("the %s calendar is read-only", info.Name)
+	}
+	return cal.InCalendar(info.ID), info, nil
+}
+
+// matchCalendar finds the calendar called name, ignoring case and a trailing "calendar".
+// An exact name wins over a partial one; a partial name must be unambiguous.
+func matchCalendar(calendars []types.CalendarInfo, name string) (types.CalendarInfo, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	name = strings.TrimSpace(strings.TrimSuffix(name, "calendar"))
+	if name == "" {
+		return types.CalendarInfo{}, fmt.Errorf("no calendar name given")
+	}
+
+	var partial []types.CalendarInfo
+	for _, info := range calendars {
+		calendarName := strings.ToLower(info.Name)
+		if calendarName == name || strings.TrimSpace(strings.TrimSuffix(calendarName, "calendar")) == name {
+			return info, nil
+		}
+		if strings.Contains(calendarName, name) {
+			partial = append(partial, info)
+		}
+	}
+
+	switch len(partial) {
+	case 1:
+		return partial[0], nil
+	case 0:
+		return types.CalendarInfo{}, fmt.Errorf("no calendar named %q, send /calendars to see yours", name)
+	default:
+		names := make([]string, len(partial))
+		for i, info := range partial {
+			names[i] = info.Name
+		}
+		return types.CalendarInfo{}, fmt.Errorf("%q matches several calendars: %s", name, strings.Join(names, ", "))
+	}
+}
+
+// calendarsCommand lists the available calendars or changes which ones a user reads from and writes to:
+//
+//	/calendars              list the calendars
+//	/calendars 1 3          read from calendars 1 and 3
+//	/calendars default 2    add new events to calendar 2
+//	/calendars reset        go back to the default calendar
+func (a *Agent) calendarsCommand(ctx context.Context, userID int64, chatID int64, args string) error {
+	response, err := a.updateCalendars(ctx, userID, args)
+	if err != nil {
+		log.Printf("Failed to update calendars of user %d: %v", userID, err)
+		response = calendarError("Sorry, I couldn't update your calendars", err)
+	}
+
+	if err := a.telegramBot.SendMessage(replyContext(ctx), chatID, response); err != nil {
+		return fmt.Errorf("failed to send /calendars response: %w", err)
+	}
+	return nil
+}
+
+// updateCalendars applies the arguments of /calendars and returns the reply
+func (a *Agent) updateCalendars(ctx context.Context, userID int64, args string) (string, error) {
+	fields := strings.FieldsFunc(strings.ToLower(args), func(r rune) bool { return r == ' ' || r == ',' })
+
+	if len(fields) == 1 && fields[0] == "reset" {
+		if err := a.database.SetState(userID, calendarSelectionStateKey, nil); err != nil {
+			return "", err
+		}
+		return "Back to the default calendar.", nil
+	}
+
+	cal, err := a.userCalendar(userID)
+	if err != nil {
+		return "", err
+	}
+	calendars, err := cal.ListCalendars(ctx)
+	if err != nil {
+		return "", err
+	}
+	if len(calendars) == 0 {
+		return "No calendars are available to the bot's credentials.", nil
+	}
+
+	selection, ok := a.loadCalendarSelection(userID)
+	if !ok {
+		selection = defaultSelection(cal, calendars)
+	}
+
+	switch {
+	case len(fields) == 0:
+		return formatCalendars(calendars, selection), nil
+
+	case fields[0] == "default":
+		if len(fields) != 2 {
+			return "Usage: /calendars default <number>", nil
+		}
+		info, ok := calendarAt(calendars, fields[1])
+		if !ok {
+			return invalidCalendarNumber(fields[1], len(calendars)), nil
+		}
+		if !info.Writable {
+			return fmt.Sprintf("The %s calendar is read-only.", info.Name), nil
+		}
+		selection.Write = info
+
+	default:
+		var read []types.CalendarInfo
+		for _, field := range fields {
+			info, ok := calendarAt(calendars, field)
+			if !ok {
+				return invalidCalendarNumber(field, len(calendars)), nil
+			}
+			read = append(read, info)
+		}
+		selection.Read = read
+	}
+
+	if err := a.database.SetState(userID, calendarSelectionStateKey, selection); err != nil {
+		return "", err
+	}
+	return formatCalendars(calendars, selection), nil
+}
+
+// defaultSelection returns the selection matching the calendar cal writes to when the user chose none
+func defaultSelection(cal *calendar.Service, calendars []types.CalendarInfo) types.CalendarSelection {
+	configured := cal.CalendarID()
+	for _, info := range calendars {
+		if info.ID == configured || (configured == "primary" && info.Primary) {
+			return types.CalendarSelection{Read: []types.CalendarInfo{info}, Write: info}
+		}
+	}
+
+	info := types.CalendarInfo{ID: configured, Name: configured, Writable: true}
+	return types.CalendarSelection{Read: []types.CalendarInfo{info}, Write: info}
+}
+
+// calendarAt returns the calendar numbered field in the /calendars list
+func calendarAt(calendars []types.CalendarInfo, field string) (types.CalendarInfo, bool) {
+	number, err := strconv.Atoi(field)
+	if err != nil || number < 1 || number > len(calendars) {
+		return types.CalendarInfo{}, false
+	}
+	return calendars[number-1], true
+}
+
+// invalidCalendarNumber is the reply to a /calendars argument that isn't in the list
+func invalidCalendarNumber(field string, count int) string {
+	return fmt.Sprintf("%q is not a calendar number between 1 and %d.", field, count)
+}
+
+// formatCalendars lists the calendars with the user's selection marked
+func formatCalendars(calendars []types.CalendarInfo, selection types.CalendarSelection) string {
+	reading := make(map[string]bool)
+	for _, info := range selection.Read {
+		reading[info.ID] = true
+	}
+
+	var b strings.Builder
+	b.WriteString("Your calendars:\n")
+	for i, info := range calendars {
+		fmt.Fprintf(&b, "%d. %s", i+1, info.Name)
+		if reading[info.ID] {
+			b.WriteString(" ✓")
+		}
+		if info.ID == selection.Write.ID {
+			b.WriteString(" (new events)")
+		}
+		if !info.Writable {
+			b.WriteString(" (read-only)")
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("\n✓ marks the calendars I read from. Send /calendars 1 2 to choose them, /calendars default 2 to choose where new events go, or /calendars reset.")
+	return b.String()
+}
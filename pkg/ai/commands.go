@@ -0,0 +1,101 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// Bot commands handled without the AI
+const (
+	CommandForget    = "forget"
+	CommandMyData    = "mydata"
+	CommandCalendars = "calendars"
+	CommandLogin     = "login"
+	CommandLogout    = "logout"
+	CommandNotify    = "notifications"
+)
+
+// HandleCommand handles a bot command such as /forget; args is the text after the command.
+// It returns false if the command is not one of the agent's commands, in which case the message
+// should be processed as usual.
+func (a *Agent) HandleCommand(ctx context.Context, userID int64, chatID int64, command, args string) (bool, error) {
+	switch command {
+	case CommandForget:
+		return true, a.forgetUser(ctx, userID, chatID)
+	case CommandMyData:
+		return true, a.exportUserData(ctx, userID, chatID)
+	case CommandCalendars:
+		return true, a.calendarsCommand(ctx, userID, chatID, args)
+	case CommandLogin:
+		return true, a.loginCommand(ctx, userID, chatID)
+	case CommandLogout:
+		return true, a.logoutCommand(ctx, userID, chatID)
+	case CommandNotify:
+		return true, a.notificationsCommand(ctx, userID, chatID, args)
+	default:
+		return false, nil
+	}
+}
+
+// forgetUser deletes everything stored about a user
+func (a *Agent) forgetUser(ctx context.Context, userID int64, chatID int64) error {
+	log.Printf("Deleting all stored data of user %d", userID)
+
+	response := "All your stored data has been deleted. Backups containing it are removed as they rotate out."
+	if err := a.deleteUserData(ctx, userID); err != nil {
+		log.Printf("Failed to delete data of user %d: %v", userID, err)
+		response = "Sorry, I couldn't delete your data. Please try again."
+	}
+
+	if err := a.telegramBot.SendMessage(replyContext(ctx), chatID, response); err != nil {
+		return fmt.Errorf("failed to send /forget response: %w", err)
+	}
+	return nil
+}
+
+// deleteUserData unsubscribes a user from notifications and deletes their interactions and state,
+// which includes their pending requests in group chats
+func (a *Agent) deleteUserData(ctx context.Context, userID int64) error {
+	defer a.dropUserCalendar(userID)
+	if err := a.unsubscribe(ctx, userID); err != nil {
+		return err
+	}
+	a.memory.forget(userID)
+	return a.database.DeleteUserData(userID)
+}
+
+// exportUserData sends everything stored about a user as a JSON file.
+// It only answers in the private chat with the user, so the export never ends up in a group.
+func (a *Agent) exportUserData(ctx context.Context, userID int64, chatID int64) error {
+	if chatID != userID {
+		if err := a.telegramBot.SendMessage(replyContext(ctx), chatID, "Please send /mydata in a private chat with me."); err != nil {
+			return fmt.Errorf("failed to send /mydata response: %w", err)
+		}
+		return nil
+	}
+
+	log.Printf("Exporting stored data of user %d", userID)
+
+	data, err := a.database.ExportUserData(userID)
+	var content []byte
+	if err == nil {
+		redactGoogleAccount(data.State)
+		content, err = json.MarshalIndent(data, "", "  ")
+	}
+	if err != nil {
+		log.Printf("Failed to export data of user %d: %v", userID, err)
+		if err := a.telegramBot.SendMessage(replyContext(ctx), chatID, "Sorry, I couldn't export your data. Please try again."); err != nil {
+			return fmt.Errorf("failed to send /mydata response: %w", err)
+		}
+		return nil
+	}
+
+	fileName := fmt.Sprintf("mydata-%d.json", userID)
+	caption := fmt.Sprintf("Everything stored about you: %d interactions.", len(data.Interactions))
+	if err := a.telegramBot.SendDocument(replyContext(ctx), chatID, fileName, content, caption); err != nil {
+		return fmt.Errorf("failed to send /mydata export: %w", err)
+	}
+	return nil
+}
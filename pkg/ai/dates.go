@@ -0,0 +1,56 @@
+package ai
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"calendar-assistant-bot/pkg/dateparse"
+	"calendar-assistant-bot/pkg/types"
+)
+
+// normalizeDates rewrites the dates and times of a model response as YYYY-MM-DD and HH:MM,
+// resolving expressions such as "next Friday" or "3pm" against now. The model doesn't always
+// follow the format it is asked for, and its date arithmetic isn't reliable; the parser's is.
+// Fields that don't parse are left for the calendar to reject.
+func normalizeDates(aiResponse *types.AIResponse, now time.Time) {
+	aiResponse.EventDate, aiResponse.EventTime = normalizeDateTime(aiResponse.EventDate, aiResponse.EventTime, now)
+	aiResponse.StartDate = normalizeDate(aiResponse.StartDate, now)
+	aiResponse.EndDate = normalizeDate(aiResponse.EndDate, now)
+
+	for i := range aiResponse.Actions {
+		action := &aiResponse.Actions[i]
+		action.EventDate, action.EventTime = normalizeDateTime(action.EventDate, action.EventTime, now)
+		action.StartDate = normalizeDate(action.StartDate, now)
+		action.EndDate = normalizeDate(action.EndDate, now)
+	}
+}
+
+// normalizeDateTime normalizes the date and time of an event. A date holding a time as well, as
+// in "tomorrow 3pm", is split. Without a date, a time of day stays one, since an update then
+// keeps the event's date; only a relative time such as "in 2 hours" sets the date.
+func normalizeDateTime(date, clock string, now time.Time) (string, string) {
+	if date == "" {
+		if hour, minute, err := dateparse.Clock(clock); err == nil {
+			return "", fmt.Sprintf("%02d:%02d", hour, minute)
+		}
+	}
+
+	t, err := dateparse.DateTime(strings.TrimSpace(date+" "+clock), now)
+	if err != nil {
+		return normalizeDate(date, now), clock
+	}
+	return t.Format("2006-01-02"), t.Format("15:04")
+}
+
+// normalizeDate normalizes a date, leaving it as it is if it doesn't parse
+func normalizeDate(date string, now time.Time) string {
+	if date == "" {
+		return date
+	}
+	day, err := dateparse.Date(date, now)
+	if err != nil {
+		return date
+	}
+	return day.Format("2006-01-02")
+}
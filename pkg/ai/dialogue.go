@@ -0,0 +1,284 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"calendar-assistant-bot/pkg/calendar"
+	"calendar-assistant-bot/pkg/dateparse"
+	"calendar-assistant-bot/pkg/types"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// dialogueStateKey prefixes the state key, under the user, of the request they are being asked
+	// to complete in a chat
+	dialogueStateKey = "dialogue"
+	// dialogueTTL is how long a request waits for the user's answer
+	dialogueTTL = 15 * time.Minute
+	// maxAnswerWords bounds free text answers, such as a title; longer messages are new requests
+	maxAnswerWords = 8
+)
+
+// slotQuestions are the questions asked for the fields a request can wait for
+var slotQuestions = map[string]string{
+	"event_title": askTitle,
+	"event_date":  askDate,
+	"event_time":  askTime,
+	"event_ref":   askEvent,
+	"start_date":  askStartDate,
+	"end_date":    askEndDate,
+	"query":       askSearch,
+}
+
+// cancelWords drop a waiting request
+var cancelWords = map[string]bool{
+	"cancel": true, "never mind": true, "nevermind": true, "forget it": true, "stop": true, "no": true,
+}
+
+// The dialogue of a user in a chat is a small state machine. It is idle until a request lacks
+// fields the user has to give, such as the time of a new event; the request then waits in the
+// user's state under the chat with the fields it has, and the user is asked for the first missing
+// one. Members of a group each have their own, which /forget deletes with the rest of their data.
+// Each answer fills what it can: the request waits for the rest, runs once it is complete, or is
+// dropped if the user cancels, the answer turns out to be a new request, or it expires.
+
+// clarify returns the question for the first problem of a response and, if the problems are all
+// fields the user can give, stores the response as the user's pending request in the chat. The
+// returned results describe the pending request for the conversation history.
+func (a *Agent) clarify(userID int64, chatID int64, aiResponse *types.AIResponse, problems []responseProblem) (string, string) {
+	question := problems[0].question
+
+	var missing []string
+	for _, p := range problems {
+		if _, ok := slotQuestions[p.field]; !ok || len(aiResponse.Actions) > 0 {
+			// Only single requests with fields the user can give wait for an answer
+			a.clearDialogue(userID, chatID)
+			return question, ""
+		}
+		if !slices.Contains(missing, p.field) {
+			missing = append(missing, p.field)
+		}
+	}
+
+	// Values that didn't parse are asked for again
+	intent := *aiResponse
+	for _, field := range missing {
+		setSlot(&intent, field, "")
+	}
+	dialogue := types.Dialogue{UserID: userID, Intent: intent, Missing: missing, ExpiresAt: time.Now().Add(dialogueTTL)}
+	a.saveDialogue(userID, chatID, dialogue)
+	return slotQuestions[missing[0]], pendingResults(dialogue)
+}
+
+// continueDialogue completes the user's pending request in the chat with their message if it
+// answers the question asked. It returns false if the user has no pending request in the chat or
+// the message is something else; the pending request is then dropped and the message goes to the
+// model, which sees the request in the history.
+func (a *Agent) continueDialogue(ctx context.Context, userID int64, chatID int64, cal *calendar.Service, message string) (bool, error) {
+	dialogue, found := a.loadDialogue(userID, chatID)
+	if !found {
+		return false, nil
+	}
+	if time.Now().After(dialogue.ExpiresAt) {
+		a.clearDialogue(userID, chatID)
+		return false, nil
+	}
+
+	if isCancel(message) {
+		a.clearDialogue(userID, chatID)
+		reply := "OK, I've dropped that request."
+		return true, a.finishTurn(ctx, chatID, types.Interaction{UserID: userID, UserMessage: message, AIResponse: reply, Action: "None", Reply: reply}, nil)
+	}
+
+	now := time.Now().UTC()
+	intent := dialogue.Intent
+	missing, answered := fillSlots(&intent, dialogue.Missing, message, now)
+	if !answered {
+		a.clearDialogue(userID, chatID)
+		return false, nil
+	}
+	log.Printf("Filled pending %s request of chat %d, still missing %v", intent.Action, chatID, missing)
+
+	var problems []responseProblem
+	if len(missing) == 0 {
+		content, err := json.Marshal(intent)
+		if err != nil {
+			return true, err
+		}
+		problems = validateResponse(&intent, string(content), now)
+	} else {
+		for _, field := range missing {
+			problems = append(problems, responseProblem{field: field, question: slotQuestions[field], missing: true})
+		}
+	}
+
+	if len(problems) > 0 {
+		question, results := a.clarify(userID, chatID, &intent, problems)
+		return true, a.finishTurn(ctx, chatID, types.Interaction{UserID: userID, UserMessage: message, AIResponse: question, Action: "None", ToolResults: results, Reply: question}, nil)
+	}
+
+	// Complete: run it as if the model had answered with it
+	a.clearDialogue(userID, chatID)
+	response, keyboard, results, err := a.executeAIAction(ctx, userID, chatID, cal, &intent)
+	if err != nil {
+		log.Printf("Error executing pending action for user %d: %v", userID, err)
+		response = fmt.Sprintf("Error executing action: %v", err)
+	}
+	return true, a.finishTurn(ctx, chatID, types.Interaction{
+		UserID:      userID,
+		UserMessage: message,
+		AIResponse:  intent.Message,
+		Action:      intent.Action,
+		ToolResults: results,
+		Reply:       response,
+	}, keyboard)
+}
+
+// isCancel reports whether a message drops the pending request
+func isCancel(message string) bool {
+	return cancelWords[strings.Trim(strings.ToLower(strings.TrimSpace(message)), ".!")]
+}
+
+// fillSlots fills the missing fields of a request from the user's answer and returns the fields
+// still missing, and whether the answer filled any. Dates and times are parsed wherever they are
+// missing; free text, such as a title, only answers the field asked about.
+func fillSlots(intent *types.AIResponse, missing []string, answer string, now time.Time) ([]string, bool) {
+	answer = strings.TrimSpace(answer)
+
+	var date, clock string
+	if hour, minute, err := dateparse.Clock(answer); err == nil {
+		clock = fmt.Sprintf("%02d:%02d", hour, minute)
+	} else if t, err := dateparse.DateTime(answer, now); err == nil {
+		date, clock = t.Format("2006-01-02"), t.Format("15:04")
+	} else if day, err := dateparse.Date(answer, now); err == nil {
+		date = day.Format("2006-01-02")
+	}
+	freeText := date == "" && clock == "" && answer != "" &&
+		len(strings.Fields(answer)) <= maxAnswerWords && !strings.HasSuffix(answer, "?")
+
+	var remaining []string
+	answered := false
+	dateUsed := false
+	for i, field := range missing {
+		var value string
+		switch field {
+		case "event_date":
+			value = date
+		case "event_time":
+			value = clock
+		case "start_date", "end_date":
+			if !dateUsed {
+				value, dateUsed = date, date != ""
+			}
+		default:
+			if i == 0 && freeText {
+				value = answer
+			}
+		}
+
+		if value == "" {
+			remaining = append(remaining, field)
+			continue
+		}
+		setSlot(intent, field, value)
+		answered = true
+	}
+
+	// "Tomorrow at 3pm" for the time of an event moves its day too
+	if answered && date != "" && clock != "" && slices.Contains(missing, "event_time") {
+		intent.EventDate = date
+	}
+	applyDefaults(intent, now)
+	return remaining, answered
+}
+
+// setSlot sets a field of a request by its JSON name
+func setSlot(intent *types.AIResponse, field, value string) {
+	switch field {
+	case "event_title":
+		intent.EventTitle = value
+	case "event_date":
+		intent.EventDate = value
+	case "event_time":
+		intent.EventTime = value
+	case "event_ref":
+		intent.EventRef = value
+		intent.EventID = ""
+	case "start_date":
+		intent.StartDate = value
+	case "end_date":
+		intent.EndDate = value
+	case "query":
+		intent.Query = value
+	}
+}
+
+// pendingResults describes a pending request for the conversation history, so the model can
+// still complete it if the user answers in a way only it understands
+func pendingResults(dialogue types.Dialogue) string {
+	intent, _ := json.Marshal(dialogue.Intent)
+	return fmt.Sprintf("%s is waiting for %s: %s\n", dialogue.Intent.Action, strings.Join(dialogue.Missing, ", "), intent)
+}
+
+// finishTurn stores an interaction, sends its reply and, in the background, summarizes older turns
+// if the history grew too long
+func (a *Agent) finishTurn(ctx context.Context, chatID int64, interaction types.Interaction, keyboard *tgbotapi.InlineKeyboardMarkup) error {
+	userID := interaction.UserID
+
+	// Store the interaction with what was actually done and shown, so follow-ups can refer to it
+	log.Printf("About to save interaction to database for user %d", userID)
+	if err := a.database.AddInteraction(interaction); err != nil {
+		log.Printf("Failed to store interaction for user %d: %v", userID, err)
+	} else {
+		log.Printf("Successfully saved interaction to database for user %d", userID)
+	}
+
+	// Send response to user
+	log.Printf("About to send response to Telegram for user %d: %s", userID, interaction.Reply)
+	if err := a.sendReply(replyContext(ctx), chatID, interaction.Reply, keyboard); err != nil {
+		log.Printf("Failed to send response to user %d: %v", userID, err)
+		return err
+	}
+	log.Printf("Successfully sent response to Telegram for user %d", userID)
+
+	a.memory.compactLater(userID)
+	return nil
+}
+
+// dialogueKey is the state key, under the user, of their pending request in a chat
+func dialogueKey(chatID int64) string {
+	return dialogueStateKey + ":" + strconv.FormatInt(chatID, 10)
+}
+
+// loadDialogue returns the pending request of a user in a chat, if any
+func (a *Agent) loadDialogue(userID int64, chatID int64) (types.Dialogue, bool) {
+	var dialogue types.Dialogue
+	found, err := a.database.GetState(userID, dialogueKey(chatID), &dialogue)
+	if err != nil {
+		log.Printf("Failed to load pending request of user %d in chat %d: %v", userID, chatID, err)
+		return dialogue, false
+	}
+	return dialogue, found
+}
+
+// saveDialogue stores the pending request of a user in a chat
+func (a *Agent) saveDialogue(userID int64, chatID int64, dialogue types.Dialogue) {
+	if err := a.database.SetState(userID, dialogueKey(chatID), dialogue); err != nil {
+		log.Printf("Failed to store pending request of user %d in chat %d: %v", userID, chatID, err)
+	}
+}
+
+// clearDialogue drops the pending request of a user in a chat
+func (a *Agent) clearDialogue(userID int64, chatID int64) {
+	if err := a.database.SetState(userID, dialogueKey(chatID), nil); err != nil {
+		log.Printf("Failed to clear pending request of user %d in chat %d: %v", userID, chatID, err)
+	}
+}
@@ -0,0 +1,205 @@
+package ai
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"calendar-assistant-bot/pkg/database"
+	"calendar-assistant-bot/pkg/types"
+)
+
+func TestFillSlots(t *testing.T) {
+	now := time.Date(2025, 8, 4, 10, 0, 0, 0, time.UTC)
+	makeEvent := types.AIResponse{Action: "makeEvent", EventTitle: "Dentist", EventDate: "2025-08-06"}
+
+	tests := []struct {
+		name         string
+		intent       types.AIResponse
+		missing      []string
+		answer       string
+		want         types.AIResponse
+		wantMissing  []string
+		wantAnswered bool
+	}{
+		{
+			name:   "time",
+			intent: makeEvent, missing: []string{"event_time"}, answer: "3pm",
+			want:         types.AIResponse{Action: "makeEvent", EventTitle: "Dentist", EventDate: "2025-08-06", EventTime: "15:00"},
+			wantAnswered: true,
+		},
+		{
+			name:   "time with a day moves the event",
+			intent: makeEvent, missing: []string{"event_time"}, answer: "tomorrow at 3pm",
+			want:         types.AIResponse{Action: "makeEvent", EventTitle: "Dentist", EventDate: "2025-08-05", EventTime: "15:00"},
+			wantAnswered: true,
+		},
+		{
+			name:   "day leaves the time missing",
+			intent: types.AIResponse{Action: "makeEvent", EventTitle: "Dentist"}, missing: []string{"event_date", "event_time"}, answer: "tomorrow",
+			want:        types.AIResponse{Action: "makeEvent", EventTitle: "Dentist", EventDate: "2025-08-05"},
+			wantMissing: []string{"event_time"}, wantAnswered: true,
+		},
+		{
+			name:   "title",
+			intent: types.AIResponse{Action: "makeEvent"}, missing: []string{"event_title", "event_date"}, answer: "  Team lunch ",
+			want:        types.AIResponse{Action: "makeEvent", EventTitle: "Team lunch"},
+			wantMissing: []string{"event_date"}, wantAnswered: true,
+		},
+		{
+			name:   "title of maxAnswerWords",
+			intent: types.AIResponse{Action: "makeEvent"}, missing: []string{"event_title"}, answer: words(maxAnswerWords),
+			want:         types.AIResponse{Action: "makeEvent", EventTitle: words(maxAnswerWords)},
+			wantAnswered: true,
+		},
+		{
+			name:   "longer answer is a new request",
+			intent: types.AIResponse{Action: "makeEvent"}, missing: []string{"event_title"}, answer: words(maxAnswerWords + 1),
+			want:        types.AIResponse{Action: "makeEvent"},
+			wantMissing: []string{"event_title"},
+		},
+		{
+			name:   "question is a new request",
+			intent: types.AIResponse{Action: "makeEvent"}, missing: []string{"event_title"}, answer: "what do I have on Friday?",
+			want:        types.AIResponse{Action: "makeEvent"},
+			wantMissing: []string{"event_title"},
+		},
+		{
+			name:   "free text only answers the field asked about",
+			intent: types.AIResponse{Action: "makeEvent"}, missing: []string{"event_date", "event_title"}, answer: "Dentist",
+			want:        types.AIResponse{Action: "makeEvent"},
+			wantMissing: []string{"event_date", "event_title"},
+		},
+		{
+			name:   "one date is a range of one day",
+			intent: types.AIResponse{Action: "getEventsRange"}, missing: []string{"start_date"}, answer: "tomorrow",
+			want:         types.AIResponse{Action: "getEventsRange", StartDate: "2025-08-05", EndDate: "2025-08-05"},
+			wantAnswered: true,
+		},
+		{
+			name:   "one date only fills the start",
+			intent: types.AIResponse{Action: "searchEvents", Query: "gym"}, missing: []string{"start_date", "end_date"}, answer: "2025-08-10",
+			want:        types.AIResponse{Action: "searchEvents", Query: "gym", StartDate: "2025-08-10"},
+			wantMissing: []string{"end_date"}, wantAnswered: true,
+		},
+		{
+			name:   "event reference replaces the ID",
+			intent: types.AIResponse{Action: "delEvents", EventID: "abc"}, missing: []string{"event_ref"}, answer: "the second one",
+			want:         types.AIResponse{Action: "delEvents", EventRef: "the second one"},
+			wantAnswered: true,
+		},
+		{
+			name:   "empty answer",
+			intent: types.AIResponse{Action: "searchEvents"}, missing: []string{"query"}, answer: " ",
+			want:        types.AIResponse{Action: "searchEvents"},
+			wantMissing: []string{"query"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			intent := tt.intent
+			missing, answered := fillSlots(&intent, tt.missing, tt.answer, now)
+			if !reflect.DeepEqual(missing, tt.wantMissing) || answered != tt.wantAnswered {
+				t.Errorf("fillSlots(%v, %q) = %v, %v, want %v, %v", tt.missing, tt.answer, missing, answered, tt.wantMissing, tt.wantAnswered)
+			}
+			if !reflect.DeepEqual(intent, tt.want) {
+				t.Errorf("fillSlots(%v, %q) filled %+v, want %+v", tt.missing, tt.answer, intent, tt.want)
+			}
+		})
+	}
+}
+
+// words returns an answer of n words
+func words(n int) string {
+	return strings.TrimSpace(strings.Repeat("word ", n))
+}
+
+func TestIsCancel(t *testing.T) {
+	tests := []struct {
+		message string
+		want    bool
+	}{
+		{"cancel", true},
+		{"No", true},
+		{"no.", true},
+		{" Never mind! ", true},
+		{"forget it", true},
+		{"STOP", true},
+		{"no, at 4pm", false},
+		{"nope", false},
+		{"cancel the dentist", false},
+		{"tomorrow", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.message, func(t *testing.T) {
+			if got := isCancel(tt.message); got != tt.want {
+				t.Errorf("isCancel(%q) = %v, want %v", tt.message, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDialoguePerUser(t *testing.T) {
+	store, err := database.NewBoltStore(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("NewBoltStore() error = %v", err)
+	}
+	defer store.Close()
+	a := &Agent{database: store}
+
+	const chatID, alice, bob = -100, 1, 2
+	a.saveDialogue(alice, chatID, types.Dialogue{UserID: alice, Intent: types.AIResponse{Action: "makeEvent"}, Missing: []string{"event_title"}})
+	a.saveDialogue(bob, chatID, types.Dialogue{UserID: bob, Intent: types.AIResponse{Action: "delEvents"}, Missing: []string{"event_ref"}})
+
+	if dialogue, found := a.loadDialogue(alice, chatID); !found || dialogue.Intent.Action != "makeEvent" {
+		t.Errorf("loadDialogue(alice) = %+v, %v, want alice's makeEvent", dialogue, found)
+	}
+	a.clearDialogue(bob, chatID)
+	if _, found := a.loadDialogue(bob, chatID); found {
+		t.Error("loadDialogue(bob) found a request after clearDialogue()")
+	}
+	if _, found := a.loadDialogue(alice, chatID); !found {
+		t.Error("clearDialogue(bob) dropped alice's request")
+	}
+	if _, found := a.loadDialogue(alice, alice); found {
+		t.Error("loadDialogue() of another chat found the group's request")
+	}
+}
+
+func TestForgetDeletesGroupDialogue(t *testing.T) {
+	store, err := database.NewBoltStore(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("NewBoltStore() error = %v", err)
+	}
+	defer store.Close()
+	a := &Agent{database: store, memory: newMemory(store, nil, 1000)}
+
+	// Both ask for an event in the group without saying when
+	const chatID, alice, bob = -100, 1, 2
+	for _, userID := range []int64{alice, bob} {
+		intent := &types.AIResponse{Action: "makeEvent", EventTitle: "Dentist", EventDesc: "Bring the X-rays"}
+		a.clarify(userID, chatID, intent, []responseProblem{{field: "event_time", question: askTime, missing: true}})
+	}
+
+	data, err := store.ExportUserData(alice)
+	if err != nil {
+		t.Fatalf("ExportUserData() error = %v", err)
+	}
+	if _, ok := data.State[dialogueKey(chatID)]; !ok {
+		t.Errorf("export state = %v, want the pending request in the group", data.State)
+	}
+
+	if err := a.deleteUserData(context.Background(), alice); err != nil {
+		t.Fatalf("deleteUserData() error = %v", err)
+	}
+	if _, found := a.loadDialogue(alice, chatID); found {
+		t.Error("pending request in the group survived /forget")
+	}
+	if _, found := a.loadDialogue(bob, chatID); !found {
+		t.Error("/forget of alice dropped bob's pending request")
+	}
+}
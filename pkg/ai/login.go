@@ -0,0 +1,259 @@
+package ai
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"calendar-assistant-bot/pkg/calendar"
+	"calendar-assistant-bot/pkg/types"
+)
+
+const (
+	// googleAccountStateKey is the state key of the Google account a user connected with /login
+	googleAccountStateKey = "google_account"
+	// loginTimeout is how long a /login link stays valid
+	loginTimeout = 10 * time.Minute
+)
+
+// errNotConnected is returned for users without a Google account when the bot has no shared calendar
+var errNotConnected = errors.New("no Google account connected, send /login to connect yours")
+
+// pendingLogin is a /login link that hasn't come back through the OAuth callback yet
+type pendingLogin struct {
+	userID  int64
+	chatID  int64
+	expires time.Time
+}
+
+// userCalendar returns the calendar service of a user: their own Google account if they connected
+// one, the shared calendar otherwise. Services of connected accounts are kept, so access tokens
+// are reused across requests.
+func (a *Agent) userCalendar(userID int64) (*calendar.Service, error) {
+	if a.oauth == nil {
+		if a.calendarService == nil {
+			return nil, errNotConnected
+		}
+		return a.calendarService, nil
+	}
+
+	a.accountsMutex.Lock()
+	defer a.accountsMutex.Unlock()
+
+	if cal, ok := a.accounts[userID]; ok {
+		return cal, nil
+	}
+
+	var account types.GoogleAccount
+	found, err := a.database.GetState(userID, googleAccountStateKey, &account)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Google account: %w", err)
+	}
+	if !found {
+		if a.calendarService == nil {
+			return nil, errNotConnected
+		}
+		return a.calendarService, nil
+	}
+
+	cal, err := a.oauth.UserService(account.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+	a.accounts[userID] = cal
+	return cal, nil
+}
+
+// dropUserCalendar forgets the calendar service of a user's account, after it changed
+func (a *Agent) dropUserCalendar(userID int64) {
+	a.accountsMutex.Lock()
+	defer a.accountsMutex.Unlock()
+	delete(a.accounts, userID)
+}
+
+// loginCommand sends a user the link to connect their Google account.
+// It only answers in the private chat with the user, so nobody else can use the link.
+func (a *Agent) loginCommand(ctx context.Context, userID int64, chatID int64) error {
+	var response string
+	switch {
+	case a.oauth == nil:
+		response = "Connecting your own Google account is not enabled on this bot."
+	case chatID != userID:
+		response = "Please send /login in a private chat with me."
+	default:
+		state, err := a.newLogin(userID, chatID)
+		if err != nil {
+			log.Printf("Failed to start login of user %d: %v", userID, err)
+			response = "Sorry, I couldn't start the login. Please try again."
+			break
+		}
+		response = fmt.Sprintf("Open this link to connect your Google Calendar. It is valid for %d minutes.\n\n%s",
+			int(loginTimeout.Minutes()), a.oauth.AuthCodeURL(state))
+	}
+
+	if err := a.telegramBot.SendMessage(replyContext(ctx), chatID, response); err != nil {
+		return fmt.Errorf("failed to send /login response: %w", err)
+	}
+	return nil
+}
+
+// newLogin registers a pending login and returns the random state identifying it in the callback
+func (a *Agent) newLogin(userID int64, chatID int64) (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	state := base64.RawURLEncoding.EncodeToString(b)
+
+	a.accountsMutex.Lock()
+	defer a.accountsMutex.Unlock()
+
+	now := time.Now()
+	for key, login := range a.logins {
+		if now.After(login.expires) {
+			delete(a.logins, key)
+		}
+	}
+	a.logins[state] = pendingLogin{userID: userID, chatID: chatID, expires: now.Add(loginTimeout)}
+	return state, nil
+}
+
+// takeLogin removes and returns the pending login of a callback state
+func (a *Agent) takeLogin(state string) (pendingLogin, bool) {
+	a.accountsMutex.Lock()
+	defer a.accountsMutex.Unlock()
+
+	login, ok := a.logins[state]
+	delete(a.logins, state)
+	if !ok || time.Now().After(login.expires) {
+		return pendingLogin{}, false
+	}
+	return login, true
+}
+
+// logoutCommand disconnects a user's Google account; they go back to the shared calendar, if any
+func (a *Agent) logoutCommand(ctx context.Context, userID int64, chatID int64) error {
+	response := "Your Google account is disconnected. You can also remove the bot's access at https://myaccount.google.com/permissions."
+	if err := a.disconnectAccount(ctx, userID); err != nil {
+		log.Printf("Failed to disconnect Google account of user %d: %v", userID, err)
+		response = "Sorry, I couldn't disconnect your Google account. Please try again."
+	}
+
+	if err := a.telegramBot.SendMessage(replyContext(ctx), chatID, response); err != nil {
+		return fmt.Errorf("failed to send /logout response: %w", err)
+	}
+	return nil
+}
+
+// disconnectAccount deletes a user's Google account, and their calendar selection and change
+// notifications, which referred to the account's calendars
+func (a *Agent) disconnectAccount(ctx context.Context, userID int64) error {
+	defer a.dropUserCalendar(userID)
+	if err := a.unsubscribe(ctx, userID); err != nil {
+		return err
+	}
+	if err := a.database.SetState(userID, googleAccountStateKey, nil); err != nil {
+		return err
+	}
+	return a.database.SetState(userID, calendarSelectionStateKey, nil)
+}
+
+// OAuthCallbackHandler handles the redirect back from Google's consent page. It stores the
+// refresh token of the user who sent /login and tells them in Telegram whether it worked.
+func (a *Agent) OAuthCallbackHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		login, ok := a.takeLogin(query.Get("state"))
+		if !ok {
+			writeLoginPage(w, http.StatusBadRequest, "This login link has expired. Send /login to the bot again.")
+			return
+		}
+
+		ctx := r.Context()
+		if reason := query.Get("error"); reason != "" {
+			log.Printf("Google login of user %d refused: %s", login.userID, reason)
+			a.sendLoginResult(ctx, login, "The Google login was cancelled. Send /login to try again.")
+			writeLoginPage(w, http.StatusOK, "The login was cancelled. You can close this page.")
+			return
+		}
+
+		if err := a.connectAccount(ctx, login.userID, query.Get("code")); err != nil {
+			log.Printf("Failed to connect Google account of user %d: %v", login.userID, err)
+			a.sendLoginResult(ctx, login, "Sorry, I couldn't connect your Google account. Please send /login to try again.")
+			writeLoginPage(w, http.StatusBadGateway, "The login failed. Please send /login to the bot again.")
+			return
+		}
+
+		log.Printf("Connected Google account of user %d", login.userID)
+		a.sendLoginResult(ctx, login, "Your Google Calendar is connected. Send /calendars to choose which calendars I use.")
+		writeLoginPage(w, http.StatusOK, "Your Google Calendar is connected. You can close this page and go back to Telegram.")
+	})
+}
+
+// connectAccount exchanges the code of a callback for a refresh token and stores it for the user.
+// The calendar selection of a previous account is dropped, since it referred to other calendars.
+func (a *Agent) connectAccount(ctx context.Context, userID int64, code string) error {
+	if code == "" {
+		return fmt.Errorf("callback without authorization code")
+	}
+
+	refreshToken, err := a.oauth.Exchange(ctx, code)
+	if err != nil {
+		return err
+	}
+
+	if err := a.disconnectAccount(ctx, userID); err != nil {
+		return err
+	}
+	account := types.GoogleAccount{RefreshToken: refreshToken, ConnectedAt: time.Now()}
+	if err := a.database.SetState(userID, googleAccountStateKey, account); err != nil {
+		return fmt.Errorf("failed to store Google account: %w", err)
+	}
+	return nil
+}
+
+// sendLoginResult tells the user in Telegram how their login went
+func (a *Agent) sendLoginResult(ctx context.Context, login pendingLogin, text string) {
+	if err := a.telegramBot.SendMessage(context.WithoutCancel(ctx), login.chatID, text); err != nil {
+		log.Printf("Failed to send login result to user %d: %v", login.userID, err)
+	}
+}
+
+// writeLoginPage answers the browser that followed the callback
+func writeLoginPage(w http.ResponseWriter, status int, text string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintln(w, text)
+}
+
+// redactGoogleAccount replaces the refresh token in a user data export, which is a credential
+// rather than data about the user
+func redactGoogleAccount(state map[string]json.RawMessage) {
+	raw, ok := state[googleAccountStateKey]
+	if !ok {
+		return
+	}
+
+	var account types.GoogleAccount
+	if err := json.Unmarshal(raw, &account); err != nil {
+		delete(state, googleAccountStateKey)
+		return
+	}
+	account.RefreshToken = "(redacted)"
+	if redacted, err := json.Marshal(account); err == nil {
+		state[googleAccountStateKey] = redacted
+	} else {
+		delete(state, googleAccountStateKey)
+	}
+}
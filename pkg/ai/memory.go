@@ -0,0 +1,251 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"calendar-assistant-bot/pkg/database"
+	"calendar-assistant-bot/pkg/types"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+const (
+	// summaryStateKey is the state key of a user's conversation summary
+	summaryStateKey = "conversation_summary"
+	// compactTimeout bounds a summary, which runs after the turn that triggered it
+	compactTimeout = 2 * time.Minute
+)
+
+// memory builds the role-tagged conversation history sent to the model. When the history
+// outgrows the token budget, older turns are summarized by the model and the summary is stored per user.
+type memory struct {
+	store  database.Store
+	openai *OpenAIService
+	budget int
+
+	// running holds the summaries in progress by user; stopped is set once no more may start
+	mutex   sync.Mutex
+	running map[int64]*compaction
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// compaction is a summary running in the background
+type compaction struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// newMemory creates a conversation memory limited to budget tokens of history
+func newMemory(store database.Store, openaiService *OpenAIService, budget int) *memory {
+	return &memory{
+		store:   store,
+		openai:  openaiService,
+		budget:  budget,
+		running: make(map[int64]*compaction),
+	}
+}
+
+// history returns the summary and the most recent turns of a user that fit in the token budget
+func (m *memory) history(userID int64) []openai.ChatCompletionMessage {
+	summary, interactions := m.load(userID)
+
+	// Keep the newest turns that fit; older ones are covered by the summary once compact has run
+	var turns [][]openai.ChatCompletionMessage
+	tokens := 0
+	for i := len(interactions) - 1; i >= 0; i-- {
+		turn := interactionMessages(interactions[i])
+		tokens += messageTokens(turn)
+		if tokens > m.budget && len(turns) > 0 {
+			break
+		}
+		turns = append(turns, turn)
+	}
+
+	var messages []openai.ChatCompletionMessage
+	if summary.Text != "" {
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: "Summary of the earlier conversation:\n" + summary.Text,
+		})
+	}
+	for i := len(turns) - 1; i >= 0; i-- {
+		messages = append(messages, turns[i]...)
+	}
+	return messages
+}
+
+// compact summarizes the oldest turns of a user into the stored summary once the turns not yet
+// summarized exceed the token budget. The newest turns, up to half the budget, are kept verbatim.
+func (m *memory) compact(ctx context.Context, userID int64) error {
+	summary, interactions := m.load(userID)
+
+	total := 0
+	for _, interaction := range interactions {
+		total += messageTokens(interactionMessages(interaction))
+	}
+	if total <= m.budget {
+		return nil
+	}
+
+	// Find the oldest turn that is kept verbatim
+	keep := len(interactions)
+	kept := 0
+	for keep > 0 {
+		tokens := messageTokens(interactionMessages(interactions[keep-1]))
+		if kept+tokens > m.budget/2 {
+			break
+		}
+		kept += tokens
+		keep--
+	}
+	if keep == 0 {
+		return nil
+	}
+
+	var older []openai.ChatCompletionMessage
+	for _, interaction := range interactions[:keep] {
+		older = append(older, interactionMessages(interaction)...)
+	}
+
+	text, err := m.openai.Summarize(ctx, summary.Text, older)
+	if err != nil {
+		return fmt.Errorf("failed to summarize conversation: %w", err)
+	}
+
+	summary = types.ConversationSummary{
+		Text:    strings.TrimSpace(text),
+		Through: interactions[keep-1].Timestamp,
+	}
+	if err := m.store.SetState(userID, summaryStateKey, summary); err != nil {
+		return err
+	}
+
+	log.Printf("Summarized %d turns of user %d", keep, userID)
+	return nil
+}
+
+// compactLater runs compact in the background, so that neither the user nor the next message of
+// the chat waits for the summary. A user's history is summarized once at a time.
+func (m *memory) compactLater(userID int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.stopped || m.running[userID] != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), compactTimeout)
+	c := &compaction{cancel: cancel, done: make(chan struct{})}
+	m.running[userID] = c
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer close(c.done)
+		defer cancel()
+
+		if err := m.compact(ctx, userID); err != nil {
+			log.Printf("Failed to compact conversation history for user %d: %v", userID, err)
+		}
+
+		m.mutex.Lock()
+		delete(m.running, userID)
+		m.mutex.Unlock()
+	}()
+}
+
+// forget cancels the summary of a user in progress and waits for it, so it can't store a summary
+// of history that is being deleted
+func (m *memory) forget(userID int64) {
+	m.mutex.Lock()
+	c := m.running[userID]
+	m.mutex.Unlock()
+
+	if c != nil {
+		c.cancel()
+		<-c.done
+	}
+}
+
+// stop waits for the summaries in progress until ctx is done, then cancels them
+func (m *memory) stop(ctx context.Context) {
+	m.mutex.Lock()
+	m.stopped = true
+	m.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+
+	m.mutex.Lock()
+	for _, c := range m.running {
+		c.cancel()
+	}
+	m.mutex.Unlock()
+	<-done
+}
+
+// load returns the summary of a user and the interactions it doesn't cover yet, oldest first
+func (m *memory) load(userID int64) (types.ConversationSummary, []types.Interaction) {
+	var summary types.ConversationSummary
+	if _, err := m.store.GetState(userID, summaryStateKey, &summary); err != nil {
+		log.Printf("Failed to load conversation summary of user %d: %v", userID, err)
+	}
+
+	interactions := m.store.GetUserInteractions(userID, 0)
+	first := 0
+	for first < len(interactions) && !interactions[first].Timestamp.After(summary.Through) {
+		first++
+	}
+	return summary, interactions[first:]
+}
+
+// interactionMessages renders one interaction as role-tagged messages: the user's message, the
+// model's decision as it would have answered it, and the results of the executed actions
+func interactionMessages(interaction types.Interaction) []openai.ChatCompletionMessage {
+	decision, _ := json.Marshal(map[string]string{
+		"action":  interaction.Action,
+		"message": interaction.AIResponse,
+	})
+
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleUser, Content: interaction.UserMessage},
+		{Role: openai.ChatMessageRoleAssistant, Content: string(decision)},
+	}
+	if interaction.ToolResults != "" {
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: "Action results:\n" + interaction.ToolResults,
+		})
+	}
+	return messages
+}
+
+// messageTokens estimates the number of tokens of messages
+func messageTokens(messages []openai.ChatCompletionMessage) int {
+	tokens := 0
+	for _, message := range messages {
+		tokens += estimateTokens(message.Content) + 4 // role and message framing
+	}
+	return tokens
+}
+
+// estimateTokens approximates the token count of text at about four characters per token,
+// which is close enough for English text to keep the prompt within budget
+func estimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
@@ -0,0 +1,297 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"calendar-assistant-bot/pkg/calendar"
+	"calendar-assistant-bot/pkg/types"
+)
+
+const (
+	// calendarWatchStateKey is the state key of the calendars a user gets change notifications for
+	calendarWatchStateKey = "calendar_watch"
+	// subscribersStateKey is the bot-wide state key listing the users with change notifications on
+	subscribersStateKey = "notification_subscribers"
+	// botStateID is the state ID of bot-wide state; user and chat IDs are never 0
+	botStateID = 0
+	// notificationTimeout bounds sending the notifications of one sync
+	notificationTimeout = 30 * time.Second
+)
+
+// EnableNotifications lets users subscribe to changes of their calendars with /notifications.
+// The watcher must pass the changes it finds to NotifyChanges.
+func (a *Agent) EnableNotifications(watcher *calendar.Watcher) {
+	a.watcher = watcher
+}
+
+// ResumeNotifications watches the calendars of all subscribed users again after a restart
+func (a *Agent) ResumeNotifications(ctx context.Context) {
+	if a.watcher == nil {
+		return
+	}
+
+	for _, userID := range a.subscribers() {
+		watch, found := a.loadCalendarWatch(userID)
+		if !found {
+			continue
+		}
+		if err := a.watchCalendars(ctx, userID, watch.Calendars); err != nil {
+			log.Printf("Failed to resume change notifications of user %d: %v", userID, err)
+		}
+	}
+}
+
+// notificationsCommand turns change notifications on or off:
+//
+//	/notifications      show whether they are on
+//	/notifications on   notify this chat of changes to the calendars I read from
+//	/notifications off  stop notifying
+func (a *Agent) notificationsCommand(ctx context.Context, userID int64, chatID int64, args string) error {
+	response, err := a.updateNotifications(ctx, userID, chatID, strings.ToLower(strings.TrimSpace(args)))
+	if err != nil {
+		log.Printf("Failed to update change notifications of user %d: %v", userID, err)
+		if errors.Is(err, calendar.ErrSyncUnsupported) {
+			response = "Change notifications are only available for Google calendars."
+		} else {
+			response = calendarError("Sorry, I couldn't update your notifications", err)
+		}
+	}
+
+	if err := a.telegramBot.SendMessage(replyContext(ctx), chatID, response); err != nil {
+		return fmt.Errorf("failed to send /notifications response: %w", err)
+	}
+	return nil
+}
+
+// updateNotifications applies the argument of /notifications and returns the reply
+func (a *Agent) updateNotifications(ctx context.Context, userID int64, chatID int64, arg string) (string, error) {
+	if a.watcher == nil {
+		return "Change notifications are not enabled on this bot.", nil
+	}
+
+	switch arg {
+	case "":
+		watch, found := a.loadCalendarWatch(userID)
+		if !found {
+			return "Change notifications are off. Send /notifications on to be told when someone else adds, moves or cancels an event in your calendars.", nil
+		}
+		return fmt.Sprintf("Change notifications are on for %s. Send /notifications off to stop them.", calendarNames(watch.Calendars)), nil
+
+	case "on":
+		cal, err := a.calendarFor(userID)
+		if err != nil {
+			return "", err
+		}
+		calendars := []types.CalendarInfo{{ID: cal.CalendarID(), Name: "your calendar"}}
+		if selection, ok := a.loadCalendarSelection(userID); ok {
+			calendars = selection.Read
+		}
+
+		// Calendars no longer selected stop being watched
+		if err := a.unsubscribe(ctx, userID); err != nil {
+			return "", err
+		}
+		if err := a.watchCalendars(ctx, userID, calendars); err != nil {
+			a.releaseCalendars(ctx, userID, calendars)
+			return "", err
+		}
+		if err := a.subscribe(userID, types.CalendarWatch{ChatID: chatID, Calendars: calendars}); err != nil {
+			a.releaseCalendars(ctx, userID, calendars)
+			return "", err
+		}
+		return fmt.Sprintf("Change notifications are on. I'll tell this chat when someone else adds, moves or cancels an event in %s.", calendarNames(calendars)), nil
+
+	case "off":
+		if err := a.unsubscribe(ctx, userID); err != nil {
+			return "", err
+		}
+		return "Change notifications are off.", nil
+
+	default:
+		return "Usage: /notifications on or /notifications off", nil
+	}
+}
+
+// watchCalendars starts watching the calendars of a user
+func (a *Agent) watchCalendars(ctx context.Context, userID int64, calendars []types.CalendarInfo) error {
+	cal, err := a.userCalendar(userID)
+	if err != nil {
+		return err
+	}
+	for _, info := range calendars {
+		if err := a.watcher.Watch(ctx, a.watchKey(userID, info.ID), cal, info.ID); err != nil {
+			return fmt.Errorf("failed to watch %s: %w", info.Name, err)
+		}
+	}
+	return nil
+}
+
+// watchKey identifies a calendar of a user for the watcher. Users of the shared calendar share
+// its watches; a calendar ID of a connected account is only unique within that account.
+func (a *Agent) watchKey(userID int64, calendarID string) string {
+	if a.oauth != nil {
+		var account types.GoogleAccount
+		if found, err := a.database.GetState(userID, googleAccountStateKey, &account); err == nil && found {
+			return fmt.Sprintf("user:%d:%s", userID, calendarID)
+		}
+	}
+	return "shared:" + calendarID
+}
+
+// loadCalendarWatch returns the calendars a user gets change notifications for, if any
+func (a *Agent) loadCalendarWatch(userID int64) (types.CalendarWatch, bool) {
+	var watch types.CalendarWatch
+	found, err := a.database.GetState(userID, calendarWatchStateKey, &watch)
+	if err != nil {
+		log.Printf("Failed to load change notifications of user %d: %v", userID, err)
+		return watch, false
+	}
+	return watch, found
+}
+
+// subscribers returns the users with change notifications on
+func (a *Agent) subscribers() []int64 {
+	var userIDs []int64
+	if _, err := a.database.GetState(botStateID, subscribersStateKey, &userIDs); err != nil {
+		log.Printf("Failed to load notification subscribers: %v", err)
+	}
+	return userIDs
+}
+
+// subscribe stores the calendars a user gets change notifications for
+func (a *Agent) subscribe(userID int64, watch types.CalendarWatch) error {
+	a.subscribersMutex.Lock()
+	defer a.subscribersMutex.Unlock()
+
+	if err := a.database.SetState(userID, calendarWatchStateKey, watch); err != nil {
+		return err
+	}
+	userIDs := a.subscribers()
+	for _, id := range userIDs {
+		if id == userID {
+			return nil
+		}
+	}
+	return a.database.SetState(botStateID, subscribersStateKey, append(userIDs, userID))
+}
+
+// unsubscribe turns a user's change notifications off and stops watching the calendars nobody
+// else is subscribed to
+func (a *Agent) unsubscribe(ctx context.Context, userID int64) error {
+	watch, found := a.loadCalendarWatch(userID)
+	if !found {
+		return nil
+	}
+
+	a.subscribersMutex.Lock()
+	userIDs := a.subscribers()
+	remaining := userIDs[:0]
+	for _, id := range userIDs {
+		if id != userID {
+			remaining = append(remaining, id)
+		}
+	}
+	var err error
+	if len(remaining) == 0 {
+		err = a.database.SetState(botStateID, subscribersStateKey, nil)
+	} else {
+		err = a.database.SetState(botStateID, subscribersStateKey, remaining)
+	}
+	if err == nil {
+		err = a.database.SetState(userID, calendarWatchStateKey, nil)
+	}
+	a.subscribersMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	a.releaseCalendars(ctx, userID, watch.Calendars)
+	return nil
+}
+
+// releaseCalendars stops watching the calendars of a user that no subscriber needs
+func (a *Agent) releaseCalendars(ctx context.Context, userID int64, calendars []types.CalendarInfo) {
+	if a.watcher == nil {
+		return
+	}
+
+	needed := make(map[string]bool)
+	for _, id := range a.subscribers() {
+		if watch, found := a.loadCalendarWatch(id); found {
+			for _, info := range watch.Calendars {
+				needed[a.watchKey(id, info.ID)] = true
+			}
+		}
+	}
+	for _, info := range calendars {
+		if key := a.watchKey(userID, info.ID); !needed[key] {
+			a.watcher.Unwatch(ctx, key)
+		}
+	}
+}
+
+// NotifyChanges tells the subscribers of a watched calendar what changed in it. A chat several
+// subscribers get notifications in is told once.
+func (a *Agent) NotifyChanges(key string, changes []calendar.EventChange) {
+	ctx, cancel := context.WithTimeout(context.Background(), notificationTimeout)
+	defer cancel()
+
+	notified := make(map[int64]bool)
+	for _, userID := range a.subscribers() {
+		watch, found := a.loadCalendarWatch(userID)
+		if !found || notified[watch.ChatID] {
+			continue
+		}
+		for _, info := range watch.Calendars {
+			if a.watchKey(userID, info.ID) != key {
+				continue
+			}
+			notified[watch.ChatID] = true
+			if err := a.telegramBot.SendMessage(ctx, watch.ChatID, formatChanges(info.Name, changes)); err != nil {
+				log.Printf("Failed to send change notification to chat %d: %v", watch.ChatID, err)
+			}
+			break
+		}
+	}
+}
+
+// formatChanges describes the changes to the events of a calendar
+func formatChanges(calendarName string, changes []calendar.EventChange) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Changes in %s:\n", calendarName)
+	for _, change := range changes {
+		event := change.Event
+		switch change.Kind {
+		case calendar.ChangeNew:
+			fmt.Fprintf(&b, "• New: %s, %s", event.Summary, formatEventTime(event))
+			if event.Organizer != "" {
+				fmt.Fprintf(&b, " (from %s)", event.Organizer)
+			}
+		case calendar.ChangeMoved:
+			fmt.Fprintf(&b, "• Moved: %s, from %s to %s", event.Summary, formatEventTime(change.Previous), formatEventTime(event))
+		case calendar.ChangeCancelled:
+			fmt.Fprintf(&b, "• Cancelled: %s, %s", event.Summary, formatEventTime(event))
+		}
+		b.WriteString("\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// formatEventTime formats when an event takes place
+func formatEventTime(event types.CalendarEvent) string {
+	return fmt.Sprintf("%s %s - %s", event.Start.Format("Monday, Jan 2"), event.Start.Format("15:04"), event.End.Format("15:04"))
+}
+
+// calendarNames lists the names of calendars
+func calendarNames(calendars []types.CalendarInfo) string {
+	names := make([]string, len(calendars))
+	for i, info := range calendars {
+		names[i] = info.Name
+	}
+	return strings.Join(names, ", ")
+}
@@ -4,9 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"net/http"
+	"strings"
 	"time"
 
+	"calendar-assistant-bot/pkg/metrics"
+	"calendar-assistant-bot/pkg/resilience"
 	"calendar-assistant-bot/pkg/types"
 
 	openai "github.com/sashabaranov/go-openai"
@@ -17,19 +20,28 @@ type OpenAIService struct {
 	client *openai.Client
 }
 
-// NewOpenAIService creates a new OpenAI service instance
+// NewOpenAIService creates a new OpenAI service instance.
+// Requests are retried on transient failures and stop early while the OpenAI circuit breaker is open.
 func NewOpenAIService(apiKey string) *OpenAIService {
+	upstream := resilience.NewClient("openai", resilience.DefaultPolicy,
+		resilience.NewBreaker("openai", 5, 30*time.Second), nil)
+
+	config := openai.DefaultConfig(apiKey)
+	config.HTTPClient = &http.Client{Transport: resilience.NewTransport(upstream, nil)}
+
 	return &OpenAIService{
-		client: openai.NewClient(apiKey),
+		client: openai.NewClientWithConfig(config),
 	}
 }
 
-// ProcessMessage sends a user message to OpenAI and returns the AI response
-func (o *OpenAIService) ProcessMessage(userContext, message string) (*types.AIResponse, error) {
-	systemPrompt := `You are a calendar assistant. Your responsibilities include creating, getting, and deleting events in the user's calendar.
+// systemPrompt returns the instructions for the calendar assistant
+func systemPrompt() string {
+	return `You are a calendar assistant. Your responsibilities include creating, getting, searching, and deleting events in the user's calendar.
 
 Available actions:
 - getEvents: Get events for a specific date
+- getEventsRange: Get events for a date range, such as a week or a month
+- searchEvents: Find events by keyword, attendee or location across a date range
 - delEvents: Delete a specific event (requires event ID)
 - makeEvent: Create a new event
 - updtEvent: Update an event (requires event ID)
@@ -41,61 +53,60 @@ You are intelligent and flexible. You can:
 - Convert relative dates to actual dates (YYYY-MM-DD format)
 - Handle complex requests by making multiple API calls if needed
 - Use "today", "tomorrow", "yesterday" as keywords
+- Pass a single date or time as the user said it, such as "next Friday", "the 3rd", "in 2 hours", "3pm" or "15h": I resolve those myself, more reliably than date arithmetic
 
 IMPORTANT: When a user asks to "get events for today" or similar, you MUST respond with action="getEvents" and event_date="today". Do NOT respond with action="None".
 You can provide current date and time if asked but make sure it includes time zone which is UTC.
 
-For complex requests like "what did I do last week?", you can either:
-1. Make a single getEvents call with the calculated date range, OR
-2. Use the actions array to make multiple getEvents calls for different days
+The conversation so far is included before the user's message. Your earlier decisions appear as your JSON responses, followed by a system message with the results of the actions that were executed, including event IDs. Events are numbered in the order they were shown to the user. When the user refers to an event shown earlier ("the second one", "the dentist appointment"), set event_ref to what they said ("2", "last", "dentist") and I will look up the event from the last list shown; you can also use its event_id from those results if the event is in the last list shown. Never invent an event ID, and never put a number or title in event_id.
+
+For requests spanning several days like "what did I do last week?" or "what's on next month?", use a single getEventsRange action with start_date and end_date (YYYY-MM-DD, inclusive) instead of one getEvents per day.
 
 If no duration is specified for an event, assume it will be one hour.
+If the user didn't give something an action needs, such as the time of a new event, leave the field out rather than guessing: I will ask them and complete the request with their answer.
 
 Respond with a JSON object containing:
 - action: one of the available actions (for simple requests). If no actions are needed, action should be "None".
 - message: response to user
 - event_id: if deleting/updating (get this from getEvents first)
+- event_ref: the number or title of an event shown earlier, if deleting/updating it without an event_id
 - event_title, event_date, event_time, event_description, event_location: if creating/updating
+- calendar: if creating, the calendar the user wants the event in ("add to family calendar" means "family"); leave it out otherwise
+- start_date, end_date: for getEventsRange
+- query, attendee, event_location, start_date, end_date, page: if searching. Set at least one of query, attendee and event_location. start_date and end_date (YYYY-MM-DD) default to today and six months ahead; set start_date to search the past. Results come in pages of 10 with a "Show more" button; if the user asks for more in a message, repeat the search with the next page number.
 - actions: array of actions for complex requests (optional)
 
 Example responses:
 {"action": "getEvents", "message": "I'll get events for today", "event_date": "today"}
-{"action": "getEvents", "message": "I'll get events for last week (Aug 5-11)", "event_date": "2025-08-05"}
-{"actions": [{"action": "getEvents", "event_date": "2025-08-05"}, {"action": "getEvents", "event_date": "2026-08-06"}], "message": "I'll get events for Monday and Tuesday of last week"}`
-
-	userPrompt := message
-	if userContext != "" {
-		userPrompt = userContext + "\n\nUser: " + message
-	}
-
-	resp, err := o.client.CreateChatCompletion(
-		context.Background(),
-		openai.ChatCompletionRequest{
-			Model: "gpt-4o-mini",
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role:    openai.ChatMessageRoleSystem,
-					Content: systemPrompt,
-				},
-				{
-					Role:    openai.ChatMessageRoleUser,
-					Content: userPrompt,
-				},
-			},
-			Temperature: 0.7,
-		},
-	)
+{"action": "getEventsRange", "message": "I'll get events for last week (Aug 4-10)", "start_date": "2025-08-04", "end_date": "2025-08-10"}
+{"action": "searchEvents", "message": "I'll look for your next haircut", "query": "haircut"}
+{"action": "searchEvents", "message": "I'll find your meetings with Sam this month", "attendee": "Sam", "start_date": "2025-08-01", "end_date": "2025-08-31"}
+{"action": "makeEvent", "message": "I'll add the picnic to your family calendar", "event_title": "Picnic", "event_date": "2025-08-09", "event_time": "12:00", "calendar": "family"}
+{"action": "delEvents", "message": "I'll cancel the dentist appointment", "event_ref": "dentist"}
+{"action": "updtEvent", "message": "I'll move the first event to 5pm", "event_ref": "1", "event_time": "17:00"}
+{"actions": [{"action": "getEvents", "event_date": "2025-08-05"}, {"action": "getEvents", "event_date": "2025-08-07"}], "message": "I'll get events for Tuesday and Thursday of last week"}`
+}
 
+// summaryPrompt instructs the model to condense conversation history
+const summaryPrompt = `You maintain the memory of a calendar assistant. Summarize the conversation below, merging it into the previous summary if there is one.
+Keep what matters for future requests: the user's preferences, recurring plans, pending tasks, and the events discussed with their dates, times and event IDs.
+Drop small talk. Reply with the summary only, in at most 200 words.`
+
+// ProcessMessage sends a user message with the conversation history to OpenAI and returns the AI
+// response along with the reply it was parsed from. corrections follow the message: earlier
+// replies to it and the problems found in them, so the model can fix its answer.
+func (o *OpenAIService) ProcessMessage(ctx context.Context, history []openai.ChatCompletionMessage, message string, corrections []openai.ChatCompletionMessage) (*types.AIResponse, string, error) {
+	messages := make([]openai.ChatCompletionMessage, 0, len(history)+len(corrections)+2)
+	messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: systemPrompt()})
+	messages = append(messages, history...)
+	messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: message})
+	messages = append(messages, corrections...)
+
+	content, err := o.complete(ctx, messages, 0.7)
 	if err != nil {
-		return nil, fmt.Errorf("OpenAI API error: %v", err)
-	}
-
-	if len(resp.Choices) == 0 {
-		return nil, fmt.Errorf("no response from OpenAI")
+		return nil, "", err
 	}
 
-	content := resp.Choices[0].Message.Content
-
 	// Try to parse JSON response
 	var aiResp types.AIResponse
 	if err := json.Unmarshal([]byte(content), &aiResp); err != nil {
@@ -106,12 +117,49 @@ Example responses:
 		}
 	}
 
-	// Validate required fields for specific actions
-	if aiResp.Action == "getEvents" && aiResp.EventDate == "" {
-		// If getEvents action but no date specified, default to today
-		aiResp.EventDate = "today"
-		log.Printf("AI response missing event_date for getEvents action, defaulting to 'today'")
+	return &aiResp, content, nil
+}
+
+// Summarize condenses conversation history, together with the previous summary, into a new summary
+func (o *OpenAIService) Summarize(ctx context.Context, previousSummary string, history []openai.ChatCompletionMessage) (string, error) {
+	var transcript strings.Builder
+	if previousSummary != "" {
+		fmt.Fprintf(&transcript, "Previous summary:\n%s\n\n", previousSummary)
+	}
+	transcript.WriteString("Conversation:\n")
+	for _, message := range history {
+		fmt.Fprintf(&transcript, "%s: %s\n", message.Role, message.Content)
+	}
+
+	return o.complete(ctx, []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: summaryPrompt},
+		{Role: openai.ChatMessageRoleUser, Content: transcript.String()},
+	}, 0.2)
+}
+
+// complete sends a chat completion request and returns the content of the first choice
+func (o *OpenAIService) complete(ctx context.Context, messages []openai.ChatCompletionMessage, temperature float32) (string, error) {
+	requestStart := time.Now()
+	resp, err := o.client.CreateChatCompletion(
+		ctx,
+		openai.ChatCompletionRequest{
+			Model:       "gpt-4o-mini",
+			Messages:    messages,
+			Temperature: temperature,
+		},
+	)
+	metrics.LLMRequestDuration.WithLabelValues(metrics.Status(err)).Observe(time.Since(requestStart).Seconds())
+
+	if err != nil {
+		return "", fmt.Errorf("OpenAI API error: %w", err)
+	}
+
+	metrics.LLMTokens.WithLabelValues("prompt").Add(float64(resp.Usage.PromptTokens))
+	metrics.LLMTokens.WithLabelValues("completion").Add(float64(resp.Usage.CompletionTokens))
+
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("no response from OpenAI")
 	}
 
-	return &aiResp, nil
+	return resp.Choices[0].Message.Content, nil
 }
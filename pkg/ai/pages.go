@@ -0,0 +1,341 @@
+package ai
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"calendar-assistant-bot/pkg/calendar"
+	"calendar-assistant-bot/pkg/telegram"
+	"calendar-assistant-bot/pkg/types"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// eventsPageSize is the number of events shown per message; the rest are behind a "Show more" button
+	eventsPageSize = 10
+	// eventListingsStateKey is the state key of the event lists recently shown in a chat
+	eventListingsStateKey = "event_listings"
+	// maxEventListings is how many listings a chat keeps, so "Show more" on earlier replies still works
+	maxEventListings = 20
+	// moreEventsCallbackPrefix starts the callback data of "Show more" buttons: events_more:<listing ID>:<page>
+	moreEventsCallbackPrefix = "events_more:"
+)
+
+// eventListing describes an event list shown to the user, so further pages can be fetched later
+type eventListing struct {
+	// ID identifies the listing in the callback data of its "Show more" button
+	ID        string `json:"id"`
+	Action    string `json:"action"`
+	EventDate string `json:"event_date,omitempty"`
+	StartDate string `json:"start_date,omitempty"`
+	EndDate   string `json:"end_date,omitempty"`
+	Query     string `json:"query,omitempty"`
+	Attendee  string `json:"attendee,omitempty"`
+	Location  string `json:"location,omitempty"`
+}
+
+// subject describes what the listing contains, e.g. "for today" or "from 2025-08-04 to 2025-08-10"
+func (l eventListing) subject() string {
+	switch l.Action {
+	case "getEventsRange":
+		return fmt.Sprintf("from %s to %s", l.StartDate, l.EndDate)
+	case "searchEvents":
+		var terms []string
+		if l.Query != "" {
+			terms = append(terms, fmt.Sprintf("%q", l.Query))
+		}
+		if l.Attendee != "" {
+			terms = append(terms, "with "+l.Attendee)
+		}
+		if l.Location != "" {
+			terms = append(terms, "at "+l.Location)
+		}
+		return "matching " + strings.Join(terms, " ")
+	default:
+		return "for " + l.EventDate
+	}
+}
+
+// label identifies the listing in the action results
+func (l eventListing) label() string {
+	switch l.Action {
+	case "getEventsRange":
+		return fmt.Sprintf("getEventsRange %s to %s", l.StartDate, l.EndDate)
+	case "searchEvents":
+		return fmt.Sprintf("searchEvents query=%q attendee=%q location=%q", l.Query, l.Attendee, l.Location)
+	default:
+		return "getEvents " + l.EventDate
+	}
+}
+
+// multiDay reports whether the listing can span several days, in which case events are grouped by day
+func (l eventListing) multiDay() bool {
+	return l.Action != "getEvents"
+}
+
+// morePage is a "Show more" button for the next page of a listing
+type morePage struct {
+	listing int
+	page    int
+}
+
+// shownEvents tracks the events a reply shows: their numbering, the listings they came from,
+// and the listings with more pages to show
+type shownEvents struct {
+	displayed []types.DisplayedEvent
+	listings  []eventListing
+	more      []morePage
+	listed    bool
+}
+
+// add adds a listing to the reply and returns its index
+func (s *shownEvents) add(listing eventListing) int {
+	listing.ID = newListingID()
+	s.listings = append(s.listings, listing)
+	return len(s.listings) - 1
+}
+
+// newListingID returns a random listing ID, short enough for Telegram's 64 byte callback data
+func newListingID() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		// Unique enough within the listings of one chat
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// keyboard returns the "Show more" buttons of the reply, or nil if everything was shown
+func (s *shownEvents) keyboard() *tgbotapi.InlineKeyboardMarkup {
+	if len(s.more) == 0 {
+		return nil
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, more := range s.more {
+		text := "Show more"
+		if len(s.listings) > 1 {
+			text += " " + s.listings[more.listing].subject()
+		}
+		data := fmt.Sprintf("%s%s:%d", moreEventsCallbackPrefix, s.listings[more.listing].ID, more.page)
+		rows = append(rows, []tgbotapi.InlineKeyboardButton{telegram.CreateInlineKeyboardButton(text, data)})
+	}
+
+	keyboard := telegram.CreateInlineKeyboard(rows)
+	return &keyboard
+}
+
+// showEvents renders one page of a listing of the reply. The events are numbered by their position
+// in the listing, or after the ones already shown if those numbers are taken, and added to the
+// action results; a button is added if more pages follow.
+func (a *Agent) showEvents(ctx context.Context, cal *calendar.Service, shown *shownEvents, index, page int, results *strings.Builder) (string, error) {
+	listing := shown.listings[index]
+	events, total, err := fetchPage(ctx, cal, listing, page)
+	if err != nil {
+		return "", err
+	}
+	shown.listed = true
+
+	label := listing.label()
+	if page > 1 {
+		label += fmt.Sprintf(" page %d", page)
+	}
+	if len(events) == 0 {
+		fmt.Fprintf(results, "%s: no events\n", label)
+		if total > 0 {
+			return fmt.Sprintf("No more events %s.", listing.subject()), nil
+		}
+		return fmt.Sprintf("No events found %s.", listing.subject()), nil
+	}
+
+	first := (page-1)*eventsPageSize + 1
+	number := max(lastIndex(shown.displayed)+1, first)
+	response := fmt.Sprintf("Events %s", listing.subject())
+	if total > len(events) {
+		if number == first {
+			response += fmt.Sprintf(" (%d-%d of %d)", first, first+len(events)-1, total)
+		} else {
+			pages := (total + eventsPageSize - 1) / eventsPageSize
+			response += fmt.Sprintf(" (page %d of %d, %d events)", page, pages, total)
+		}
+	}
+	response += ":\n"
+
+	day := ""
+	for i, event := range events {
+		if listing.multiDay() {
+			if eventDay := event.Start.Format("Monday, Jan 2"); eventDay != day {
+				day = eventDay
+				response += "\n" + day + "\n"
+			}
+		}
+		response += fmt.Sprintf("%d. %s (%s - %s)",
+			number+i,
+			event.Summary,
+			event.Start.Format("15:04"),
+			event.End.Format("15:04"))
+		if event.Location != "" {
+			response += fmt.Sprintf(" - %s", event.Location)
+		}
+		if event.Calendar != "" {
+			response += fmt.Sprintf(" [%s]", event.Calendar)
+		}
+		response += "\n"
+	}
+
+	shown.displayed = writeEventResults(results, label, number, events, shown.displayed)
+	if first+len(events)-1 < total {
+		shown.more = append(shown.more, morePage{listing: index, page: page + 1})
+	}
+	return response, nil
+}
+
+// fetchPage returns the events of a 1-based page of a listing and the number of events in the listing
+func fetchPage(ctx context.Context, cal *calendar.Service, listing eventListing, page int) ([]types.CalendarEvent, int, error) {
+	if page < 1 {
+		page = 1
+	}
+
+	var events []types.CalendarEvent
+	var err error
+	switch listing.Action {
+	case "searchEvents":
+		result, err := cal.SearchEvents(ctx, calendar.SearchQuery{
+			Text:      listing.Query,
+			Attendee:  listing.Attendee,
+			Location:  listing.Location,
+			StartDate: listing.StartDate,
+			EndDate:   listing.EndDate,
+			Page:      page,
+			PageSize:  eventsPageSize,
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		return result.Events, result.Total, nil
+	case "getEventsRange":
+		events, err = cal.GetEventsInRange(ctx, listing.StartDate, listing.EndDate)
+	default:
+		events, err = cal.GetEvents(ctx, listing.EventDate)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	start := (page - 1) * eventsPageSize
+	if start >= len(events) {
+		return nil, len(events), nil
+	}
+	end := start + eventsPageSize
+	if end > len(events) {
+		end = len(events)
+	}
+	return events[start:end], len(events), nil
+}
+
+// saveShownEvents stores the events of a reply for the chat, replacing those of earlier replies, and
+// adds its listings to the chat's most recent ones
+func (a *Agent) saveShownEvents(chatID int64, shown *shownEvents) {
+	a.saveDisplayedEvents(chatID, shown.displayed)
+
+	listings := a.loadListings(chatID)
+	listings = slices.DeleteFunc(listings, func(stored eventListing) bool {
+		return slices.ContainsFunc(shown.listings, func(l eventListing) bool { return l.ID == stored.ID })
+	})
+	listings = append(listings, shown.listings...)
+	if len(listings) > maxEventListings {
+		listings = listings[len(listings)-maxEventListings:]
+	}
+	if err := a.database.SetState(chatID, eventListingsStateKey, listings); err != nil {
+		log.Printf("Failed to store event listings of chat %d: %v", chatID, err)
+	}
+}
+
+// showListing replies with the first page of a listing, as for the calendar keyboard buttons
+func (a *Agent) showListing(ctx context.Context, userID int64, chatID int64, listing eventListing) error {
+	var shown shownEvents
+	var results strings.Builder
+	cal, err := a.calendarFor(userID)
+	var response string
+	if err == nil {
+		response, err = a.showEvents(ctx, cal, &shown, shown.add(listing), 1, &results)
+	}
+	if err != nil {
+		response = calendarError("Error getting events", err)
+	} else {
+		a.saveShownEvents(chatID, &shown)
+	}
+	return a.sendReply(ctx, chatID, response, shown.keyboard())
+}
+
+// loadListings returns the event listings recently shown in a chat, oldest first
+func (a *Agent) loadListings(chatID int64) []eventListing {
+	listings := []eventListing{}
+	if _, err := a.database.GetState(chatID, eventListingsStateKey, &listings); err != nil {
+		log.Printf("Failed to load event listings of chat %d: %v", chatID, err)
+	}
+	return listings
+}
+
+// showMoreEvents replies with the page of a listing that a "Show more" button asks for, which may
+// be on an earlier reply. The events are numbered after those shown before, so references to
+// either keep working.
+func (a *Agent) showMoreEvents(ctx context.Context, userID int64, chatID int64, callbackData string) error {
+	id, page, err := parseMoreEvents(callbackData)
+	if err != nil {
+		return err
+	}
+
+	listings := a.loadListings(chatID)
+	i := slices.IndexFunc(listings, func(l eventListing) bool { return l.ID == id })
+	if i < 0 {
+		return a.sendReply(ctx, chatID, "These events are no longer available. Please ask for them again.", nil)
+	}
+
+	shown := shownEvents{listings: []eventListing{listings[i]}}
+	if _, err := a.database.GetState(chatID, displayedEventsStateKey, &shown.displayed); err != nil {
+		log.Printf("Failed to load displayed events of chat %d: %v", chatID, err)
+	}
+
+	var results strings.Builder
+	cal, err := a.calendarFor(userID)
+	var response string
+	if err == nil {
+		response, err = a.showEvents(ctx, cal, &shown, 0, page, &results)
+	}
+	if err != nil {
+		response = calendarError("Error getting events", err)
+	} else {
+		a.saveShownEvents(chatID, &shown)
+	}
+	return a.sendReply(ctx, chatID, response, shown.keyboard())
+}
+
+// parseMoreEvents parses the callback data of a "Show more" button into the listing ID and page
+func parseMoreEvents(callbackData string) (string, int, error) {
+	id, page, ok := strings.Cut(strings.TrimPrefix(callbackData, moreEventsCallbackPrefix), ":")
+	if !ok || id == "" {
+		return "", 0, fmt.Errorf("invalid show more callback %q", callbackData)
+	}
+	next, err := strconv.Atoi(page)
+	if err != nil || next < 1 {
+		return "", 0, fmt.Errorf("invalid show more callback %q", callbackData)
+	}
+	return id, next, nil
+}
+
+// sendReply sends a reply, with the inline keyboard if there is one
+func (a *Agent) sendReply(ctx context.Context, chatID int64, text string, keyboard *tgbotapi.InlineKeyboardMarkup) error {
+	if keyboard == nil {
+		return a.telegramBot.SendMessage(ctx, chatID, text)
+	}
+	return a.telegramBot.SendMessageWithKeyboard(ctx, chatID, text, *keyboard)
+}
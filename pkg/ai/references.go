@@ -0,0 +1,220 @@
+package ai
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"calendar-assistant-bot/pkg/types"
+)
+
+// displayedEventsStateKey is the state key of the last event list shown in a chat
+const displayedEventsStateKey = "displayed_events"
+
+// ordinalWords maps spoken ordinals to list positions
+var ordinalWords = map[string]int{
+	"first": 1, "second": 2, "third": 3, "fourth": 4, "fifth": 5,
+	"sixth": 6, "seventh": 7, "eighth": 8, "ninth": 9, "tenth": 10,
+	"one": 1, "two": 2, "three": 3, "four": 4, "five": 5,
+	"six": 6, "seven": 7, "eight": 8, "nine": 9, "ten": 10,
+}
+
+// referenceStopwords are ignored when matching a reference against event titles
+var referenceStopwords = map[string]bool{
+	"the": true, "a": true, "an": true, "my": true, "with": true, "at": true, "on": true,
+	"to": true, "for": true, "of": true, "in": true, "event": true, "meeting": true,
+	"appointment": true, "one": true,
+}
+
+// saveDisplayedEvents stores the events shown in a chat, replacing the previous list
+func (a *Agent) saveDisplayedEvents(chatID int64, events []types.DisplayedEvent) {
+	if events == nil {
+		events = []types.DisplayedEvent{}
+	}
+	if err := a.database.SetState(chatID, displayedEventsStateKey, events); err != nil {
+		log.Printf("Failed to store displayed events of chat %d: %v", chatID, err)
+	}
+}
+
+// resolveEvent returns the event an update or delete refers to: the event with eventID, or the one
+// ref points to, in the last list shown in the chat. An event ID that isn't in that list is not
+// found rather than read as a reference, since a real ID could otherwise match the wrong event.
+func (a *Agent) resolveEvent(chatID int64, eventID, ref string) (types.DisplayedEvent, error) {
+	var displayed []types.DisplayedEvent
+	found, err := a.database.GetState(chatID, displayedEventsStateKey, &displayed)
+	if err != nil {
+		log.Printf("Failed to load displayed events of chat %d: %v", chatID, err)
+	}
+
+	if eventID != "" {
+		for _, event := range displayed {
+			if event.ID == eventID {
+				return event, nil
+			}
+		}
+	}
+
+	if ref == "" {
+		if eventID == "" {
+			return types.DisplayedEvent{}, fmt.Errorf("no event specified")
+		}
+		return types.DisplayedEvent{}, fmt.Errorf("event %s not found in the last list, please list your events first", eventID)
+	}
+
+	if !found || len(displayed) == 0 {
+		return types.DisplayedEvent{}, fmt.Errorf("there is no event list to refer to, please list your events first")
+	}
+	return resolveReference(displayed, ref)
+}
+
+// resolveReference finds the event ref points to: a position ("2", "#2", "2nd", "second", "last")
+// or words from its title. A title match must be unambiguous.
+func resolveReference(displayed []types.DisplayedEvent, ref string) (types.DisplayedEvent, error) {
+	if index, ok := parseOrdinal(ref, lastIndex(displayed)); ok {
+		for _, event := range displayed {
+			if event.Index == index {
+				return event, nil
+			}
+		}
+		return types.DisplayedEvent{}, fmt.Errorf("there is no event number %d in the last list (it had %d events)", index, len(displayed))
+	}
+
+	words := referenceWords(ref)
+	if len(words) == 0 {
+		return types.DisplayedEvent{}, fmt.Errorf("could not tell which event %q refers to", ref)
+	}
+
+	best := 0.0
+	var matches []types.DisplayedEvent
+	for _, event := range displayed {
+		score := titleScore(words, referenceWords(event.Title))
+		switch {
+		case score > best:
+			best = score
+			matches = []types.DisplayedEvent{event}
+		case score == best && score > 0:
+			matches = append(matches, event)
+		}
+	}
+
+	if best < 0.5 || len(matches) == 0 {
+		return types.DisplayedEvent{}, fmt.Errorf("no event matching %q in the last list", ref)
+	}
+	if len(matches) > 1 {
+		candidates := make([]string, len(matches))
+		for i, event := range matches {
+			candidates[i] = fmt.Sprintf("%d. %s (%s)", event.Index, event.Title, event.Start.Format("2006-01-02 15:04"))
+		}
+		return types.DisplayedEvent{}, fmt.Errorf("%q matches several events, which one do you mean? %s", ref, strings.Join(candidates, "; "))
+	}
+	return matches[0], nil
+}
+
+// lastIndex returns the highest number of the displayed events, 0 if there are none
+func lastIndex(displayed []types.DisplayedEvent) int {
+	last := 0
+	for _, event := range displayed {
+		last = max(last, event.Index)
+	}
+	return last
+}
+
+// parseOrdinal parses a list position; "last" is the position count
+func parseOrdinal(ref string, count int) (int, bool) {
+	ref = strings.ToLower(strings.TrimSpace(ref))
+	ref = strings.TrimPrefix(ref, "#")
+	ref = strings.TrimPrefix(ref, "the ")
+	ref = strings.TrimSuffix(ref, " one")
+	ref = strings.TrimSpace(ref)
+
+	if ref == "last" {
+		return count, count > 0
+	}
+	if index, ok := ordinalWords[ref]; ok {
+		return index, true
+	}
+
+	for _, suffix := range []string{"st", "nd", "rd", "th"} {
+		if strings.HasSuffix(ref, suffix) {
+			ref = strings.TrimSuffix(ref, suffix)
+			break
+		}
+	}
+	index, err := strconv.Atoi(ref)
+	if err != nil || index < 1 {
+		return 0, false
+	}
+	return index, true
+}
+
+// referenceWords splits text into lowercase words, dropping punctuation and stopwords
+func referenceWords(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	words := fields[:0]
+	for _, field := range fields {
+		if !referenceStopwords[field] {
+			words = append(words, field)
+		}
+	}
+	return words
+}
+
+// titleScore returns the share of reference words found in the title. A word matches a title word
+// it is a prefix of ("dent" for "dentist") or that is at most one edit away from it (typos).
+func titleScore(ref, title []string) float64 {
+	if len(ref) == 0 {
+		return 0
+	}
+
+	matched := 0
+	for _, word := range ref {
+		for _, candidate := range title {
+			if strings.HasPrefix(candidate, word) && len(word) >= 3 || withinOneEdit(word, candidate) {
+				matched++
+				break
+			}
+		}
+	}
+	return float64(matched) / float64(len(ref))
+}
+
+// withinOneEdit reports whether a and b differ by at most one insertion, deletion or substitution.
+// Short words must match exactly, since a single edit changes them too much.
+func withinOneEdit(a, b string) bool {
+	if a == b {
+		return true
+	}
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) < 4 || len(rb) < 4 {
+		return false
+	}
+	if len(ra) > len(rb) {
+		ra, rb = rb, ra
+	}
+	if len(rb)-len(ra) > 1 {
+		return false
+	}
+
+	i, j, edits := 0, 0, 0
+	for i < len(ra) && j < len(rb) {
+		if ra[i] == rb[j] {
+			i++
+			j++
+			continue
+		}
+		edits++
+		if edits > 1 {
+			return false
+		}
+		if len(ra) == len(rb) {
+			i++
+		}
+		j++
+	}
+	return edits+(len(rb)-j)+(len(ra)-i) <= 1
+}
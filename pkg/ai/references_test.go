@@ -0,0 +1,102 @@
+package ai
+
+import (
+	"testing"
+)
+
+func TestParseOrdinal(t *testing.T) {
+	tests := []struct {
+		name   string
+		ref    string
+		count  int
+		want   int
+		wantOK bool
+	}{
+		{"number", "2", 5, 2, true},
+		{"hash", "#3", 5, 3, true},
+		{"suffix", "2nd", 5, 2, true},
+		{"suffix th", "11th", 20, 11, true},
+		{"word", "second", 5, 2, true},
+		{"cardinal word", "three", 5, 3, true},
+		{"the and one", "the first one", 5, 1, true},
+		{"spaces and case", "  Third ", 5, 3, true},
+		{"last", "last", 4, 4, true},
+		{"last of empty list", "the last one", 0, 0, false},
+		{"beyond the list is still a position", "7", 3, 7, true},
+		{"zero", "0", 5, 0, false},
+		{"negative", "-1", 5, 0, false},
+		{"title", "dentist", 5, 0, false},
+		{"event ID", "abc123def", 5, 0, false},
+		{"empty", "", 5, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseOrdinal(tt.ref, tt.count)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("parseOrdinal(%q, %d) = %d, %v, want %d, %v", tt.ref, tt.count, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestTitleScore(t *testing.T) {
+	tests := []struct {
+		name  string
+		ref   string
+		title string
+		want  float64
+	}{
+		{"exact", "dentist", "Dentist appointment", 1},
+		{"prefix", "dent", "Dentist", 1},
+		{"short prefix", "de", "Dentist", 0},
+		{"typo", "dentsit", "Dentist", 0},
+		{"one edit", "dentst", "Dentist", 1},
+		{"half", "team lunch", "Team standup", 0.5},
+		{"stopwords ignored", "the meeting with Anna", "Call Anna", 1},
+		{"no match", "gym", "Dentist", 0},
+		{"only stopwords", "the meeting", "Dentist", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := titleScore(referenceWords(tt.ref), referenceWords(tt.title))
+			if got != tt.want {
+				t.Errorf("titleScore(%q, %q) = %v, want %v", tt.ref, tt.title, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithinOneEdit(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"dentist", "dentist", true},
+		{"dentist", "dentst", true},
+		{"dentist", "dentists", true},
+		{"dentist", "dentisr", true},
+		{"dentist", "xentist", true},
+		{"dentist", "dentisx", true},
+		{"dentist", "dentsit", false},
+		{"dentist", "dent", false},
+		{"dentist", "dantust", false},
+		{"gym", "gum", false},
+		{"gym", "gym", true},
+		{"café", "cafe", true},
+		{"gyms", "gym", false},
+		{"cafés", "cafes", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+"/"+tt.b, func(t *testing.T) {
+			if got := withinOneEdit(tt.a, tt.b); got != tt.want {
+				t.Errorf("withinOneEdit(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+			if got := withinOneEdit(tt.b, tt.a); got != tt.want {
+				t.Errorf("withinOneEdit(%q, %q) = %v, want %v", tt.b, tt.a, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"calendar-assistant-bot/pkg/dateparse"
+	"calendar-assistant-bot/pkg/metrics"
+	"calendar-assistant-bot/pkg/types"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// maxRepairs is how many times a response that fails validation is sent back to the model
+const maxRepairs = 2
+
+// Questions asked when the model can't produce a valid response, by what is missing
+const (
+	askDate      = "Which day did you mean?"
+	askStartDate = "From which day should I look?"
+	askEndDate   = "Until which day should I look?"
+	askRange     = "Which dates did you mean? The end seems to come before the start."
+	askTime      = "What time should it start?"
+	askTitle     = "What should I call the event?"
+	askEvent     = "Which event do you mean? Ask me to list your events first if needed."
+	askSearch    = "What should I search for?"
+	askRephrase  = "Sorry, I didn't understand that. Could you rephrase your request?"
+)
+
+// responseProblem is a way a model response breaks the action schema
+type responseProblem struct {
+	// field is the JSON field at fault, empty for the response as a whole
+	field string
+	// problem is told to the model
+	problem string
+	// question is asked of the user if the model doesn't fix the problem; empty means the
+	// response is used anyway
+	question string
+	// missing is set when the field is empty, which the model can't fix if the user didn't say
+	missing bool
+}
+
+func (p responseProblem) String() string {
+	if p.field == "" {
+		return p.problem
+	}
+	return p.field + ": " + p.problem
+}
+
+// decide asks the model what to do about a message and validates its response against the action
+// schema. A response with problems is sent back to the model with them, up to maxRepairs times,
+// unless all it lacks are fields the user didn't give or it can be used as it is. The problems left
+// that the user has to be asked about are returned with the response, which must not be acted on
+// if there are any.
+func (a *Agent) decide(ctx context.Context, userID int64, history []openai.ChatCompletionMessage, message string) (*types.AIResponse, []responseProblem, error) {
+	now := time.Now().UTC()
+
+	var corrections []openai.ChatCompletionMessage
+	for attempt := 0; ; attempt++ {
+		aiResponse, content, err := a.openaiService.ProcessMessage(ctx, history, message, corrections)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		normalizeDates(aiResponse, now)
+		applyDefaults(aiResponse, now)
+		problems := validateResponse(aiResponse, content, now)
+		if len(problems) == 0 {
+			return aiResponse, nil, nil
+		}
+		log.Printf("AI response for user %d failed validation (attempt %d): %s", userID, attempt+1, formatProblems(problems))
+
+		if attempt == maxRepairs || !repairable(problems) {
+			// Problems without a question don't keep the response from being used, so a plain
+			// text reply runs as a message
+			return aiResponse, slices.DeleteFunc(problems, func(p responseProblem) bool { return p.question == "" }), nil
+		}
+		metrics.LLMRepairs.Inc()
+		corrections = append(corrections,
+			openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: content},
+			openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: repairPrompt(problems)},
+		)
+	}
+}
+
+// repairPrompt asks the model to correct a response
+func repairPrompt(problems []responseProblem) string {
+	return "Your response has these problems:\n" + formatProblems(problems) +
+		"\nReply again with the corrected JSON object only. Leave out what the user didn't say; I will ask them for it."
+}
+
+// repairable reports whether the model can fix any of the problems: a field that is wrong rather
+// than empty, and that keeps the response from being used. A plain text reply is shown as it is
+// rather than costing more completions.
+func repairable(problems []responseProblem) bool {
+	for _, p := range problems {
+		if !p.missing && p.question != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// applyDefaults fills the fields a response and its combined actions may leave out: getEvents
+// without a day is for today, and a range without an end is the one day it starts on
+func applyDefaults(aiResponse *types.AIResponse, now time.Time) {
+	aiResponse.EventDate, aiResponse.EndDate = actionDefaults(aiResponse.Action, aiResponse.EventDate, aiResponse.StartDate, aiResponse.EndDate, now)
+	for i := range aiResponse.Actions {
+		action := &aiResponse.Actions[i]
+		action.EventDate, action.EndDate = actionDefaults(action.Action, action.EventDate, action.StartDate, action.EndDate, now)
+	}
+}
+
+// actionDefaults returns the event date and end date of an action with the defaults filled in
+func actionDefaults(action, eventDate, startDate, endDate string, now time.Time) (string, string) {
+	if action == "getEvents" && eventDate == "" {
+		eventDate = now.Format("2006-01-02")
+	}
+	if action == "getEventsRange" && endDate == "" {
+		endDate = startDate
+	}
+	return eventDate, endDate
+}
+
+// formatProblems lists problems one per line
+func formatProblems(problems []responseProblem) string {
+	lines := make([]string, len(problems))
+	for i, p := range problems {
+		lines[i] = "- " + p.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// validateResponse checks a model response, and the reply it was parsed from, against the action
+// schema. Dates are expected to be normalized already, so anything but YYYY-MM-DD and HH:MM is
+// one the parser didn't understand either.
+func validateResponse(aiResponse *types.AIResponse, content string, now time.Time) []responseProblem {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(content), &fields); err != nil {
+		// A plain text reply is still shown to the user as a message
+		return []responseProblem{{problem: "the reply is not a JSON object"}}
+	}
+
+	var problems []responseProblem
+	if len(aiResponse.Actions) > 0 {
+		for i, action := range aiResponse.Actions {
+			prefix := fmt.Sprintf("actions[%d].", i)
+			switch action.Action {
+			case "getEvents":
+				problems = append(problems, checkDate(prefix+"event_date", action.EventDate, true, askDate, now)...)
+			case "getEventsRange":
+				problems = append(problems, checkRange(prefix, action.StartDate, action.EndDate, true, now)...)
+			default:
+				problems = append(problems, responseProblem{
+					field:    prefix + "action",
+					problem:  fmt.Sprintf("%q can't be combined with other actions; use getEvents or getEventsRange, or a single action", action.Action),
+					question: askRephrase,
+				})
+			}
+		}
+		return problems
+	}
+
+	switch aiResponse.Action {
+	case "getEvents":
+		problems = append(problems, checkDate("event_date", aiResponse.EventDate, true, askDate, now)...)
+
+	case "getEventsRange":
+		problems = append(problems, checkRange("", aiResponse.StartDate, aiResponse.EndDate, true, now)...)
+
+	case "searchEvents":
+		if aiResponse.Query == "" && aiResponse.Attendee == "" && aiResponse.EventLoc == "" {
+			problems = append(problems, responseProblem{field: "query", problem: "set at least one of query, attendee and event_location", question: askSearch, missing: true})
+		}
+		problems = append(problems, checkRange("", aiResponse.StartDate, aiResponse.EndDate, false, now)...)
+		if aiResponse.Page < 0 {
+			problems = append(problems, responseProblem{field: "page", problem: "must be 1 or more", question: askRephrase})
+		}
+
+	case "makeEvent":
+		if strings.TrimSpace(aiResponse.EventTitle) == "" {
+			problems = append(problems, responseProblem{field: "event_title", problem: "is required to create an event", question: askTitle, missing: true})
+		}
+		problems = append(problems, checkDate("event_date", aiResponse.EventDate, true, askDate, now)...)
+		problems = append(problems, checkClock(aiResponse.EventTime, true)...)
+
+	case "updtEvent":
+		problems = append(problems, checkEventRef(aiResponse, "update")...)
+		problems = append(problems, checkDate("event_date", aiResponse.EventDate, false, askDate, now)...)
+		problems = append(problems, checkClock(aiResponse.EventTime, false)...)
+
+	case "delEvents":
+		problems = append(problems, checkEventRef(aiResponse, "delete")...)
+
+	case "None", "message":
+		if strings.TrimSpace(aiResponse.Message) == "" {
+			problems = append(problems, responseProblem{field: "message", problem: "is required when there is no action", question: askRephrase})
+		}
+
+	case "":
+		problems = append(problems, responseProblem{field: "action", problem: `is required; use "None" if no action is needed`, question: askRephrase})
+
+	default:
+		problems = append(problems, responseProblem{
+			field:    "action",
+			problem:  fmt.Sprintf("unknown action %q; use getEvents, getEventsRange, searchEvents, makeEvent, updtEvent, delEvents or None", aiResponse.Action),
+			question: askRephrase,
+		})
+	}
+	return problems
+}
+
+// checkDate checks a date field
+func checkDate(field, value string, required bool, question string, now time.Time) []responseProblem {
+	if value == "" {
+		if required {
+			return []responseProblem{{field: field, problem: "is required", question: question, missing: true}}
+		}
+		return nil
+	}
+	if _, err := dateparse.Date(value, now); err != nil {
+		return []responseProblem{{field: field, problem: fmt.Sprintf("%q is not a date; use YYYY-MM-DD", value), question: question}}
+	}
+	return nil
+}
+
+// checkRange checks the start_date and end_date fields of a range, whose field names get prefix
+func checkRange(prefix, startDate, endDate string, required bool, now time.Time) []responseProblem {
+	problems := checkDate(prefix+"start_date", startDate, required, askStartDate, now)
+	problems = append(problems, checkDate(prefix+"end_date", endDate, false, askEndDate, now)...)
+	if len(problems) > 0 || startDate == "" || endDate == "" {
+		return problems
+	}
+
+	start, _ := dateparse.Date(startDate, now)
+	end, _ := dateparse.Date(endDate, now)
+	if end.Before(start) {
+		return []responseProblem{{field: prefix + "end_date", problem: fmt.Sprintf("%s is before start_date %s", endDate, startDate), question: askRange}}
+	}
+	return nil
+}
+
+// checkClock checks the event_time field
+func checkClock(value string, required bool) []responseProblem {
+	if value == "" {
+		if required {
+			return []responseProblem{{field: "event_time", problem: "is required to create an event", question: askTime, missing: true}}
+		}
+		return nil
+	}
+	if _, _, err := dateparse.Clock(value); err != nil {
+		return []responseProblem{{field: "event_time", problem: fmt.Sprintf("%q is not a time of day; use HH:MM", value), question: askTime}}
+	}
+	return nil
+}
+
+// checkEventRef checks that a response names the event to update or delete
+func checkEventRef(aiResponse *types.AIResponse, verb string) []responseProblem {
+	if aiResponse.EventID == "" && aiResponse.EventRef == "" {
+		return []responseProblem{{field: "event_ref", problem: "set event_ref or event_id to the event to " + verb, question: askEvent, missing: true}}
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"calendar-assistant-bot/pkg/types"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// problemFields lists the fields of problems, with "?" after those the user would be asked about
+// and "!" after those missing
+func problemFields(problems []responseProblem) []string {
+	fields := []string{}
+	for _, p := range problems {
+		field := p.field
+		if p.question != "" {
+			field += "?"
+		}
+		if p.missing {
+			field += "!"
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+func TestValidateResponse(t *testing.T) {
+	now := time.Date(2025, 8, 4, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		response types.AIResponse
+		// content is the reply; empty means the response as JSON
+		content string
+		want    []string
+	}{
+		{"plain text reply", types.AIResponse{Action: "message", Message: "Hello!"}, "Hello!", []string{""}},
+		{"message", types.AIResponse{Action: "None", Message: "Hi"}, "", []string{}},
+		{"empty message", types.AIResponse{Action: "None"}, "", []string{"message?"}},
+		{"no action", types.AIResponse{Message: "Hi"}, "", []string{"action?"}},
+		{"unknown action", types.AIResponse{Action: "listEvents"}, "", []string{"action?"}},
+		{"getEvents", types.AIResponse{Action: "getEvents", EventDate: "2025-08-05"}, "", []string{}},
+		{"getEvents without date", types.AIResponse{Action: "getEvents"}, "", []string{"event_date?!"}},
+		{"getEvents with bad date", types.AIResponse{Action: "getEvents", EventDate: "someday"}, "", []string{"event_date?"}},
+		{"range", types.AIResponse{Action: "getEventsRange", StartDate: "2025-08-04", EndDate: "2025-08-10"}, "", []string{}},
+		{"range without start", types.AIResponse{Action: "getEventsRange", EndDate: "2025-08-10"}, "", []string{"start_date?!"}},
+		{"search", types.AIResponse{Action: "searchEvents", Attendee: "Anna"}, "", []string{}},
+		{"search for nothing", types.AIResponse{Action: "searchEvents"}, "", []string{"query?!"}},
+		{"search page", types.AIResponse{Action: "searchEvents", Query: "gym", Page: -1}, "", []string{"page?"}},
+		{"make", types.AIResponse{Action: "makeEvent", EventTitle: "Gym", EventDate: "2025-08-05", EventTime: "18:00"}, "", []string{}},
+		{"make with nothing", types.AIResponse{Action: "makeEvent", EventTitle: " "}, "", []string{"event_title?!", "event_date?!", "event_time?!"}},
+		{"make with bad time", types.AIResponse{Action: "makeEvent", EventTitle: "Gym", EventDate: "2025-08-05", EventTime: "evening"}, "", []string{"event_time?"}},
+		{"update by ref", types.AIResponse{Action: "updtEvent", EventRef: "2", EventTime: "15:00"}, "", []string{}},
+		{"update without event", types.AIResponse{Action: "updtEvent", EventDate: "soon"}, "", []string{"event_ref?!", "event_date?"}},
+		{"delete by ID", types.AIResponse{Action: "delEvents", EventID: "abc"}, "", []string{}},
+		{"delete without event", types.AIResponse{Action: "delEvents"}, "", []string{"event_ref?!"}},
+		{"combined reads", types.AIResponse{Actions: []types.AIAction{
+			{Action: "getEvents", EventDate: "2025-08-05"},
+			{Action: "getEventsRange", StartDate: "2025-08-10"},
+		}}, "", []string{}},
+		{"combined with a write", types.AIResponse{Actions: []types.AIAction{
+			{Action: "getEvents"},
+			{Action: "makeEvent"},
+		}}, "", []string{"actions[0].event_date?!", "actions[1].action?"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := tt.content
+			if content == "" {
+				data, err := json.Marshal(tt.response)
+				if err != nil {
+					t.Fatalf("json.Marshal() error = %v", err)
+				}
+				content = string(data)
+			}
+			if got := problemFields(validateResponse(&tt.response, content, now)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validateResponse(%s) = %v, want %v", content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyDefaults(t *testing.T) {
+	now := time.Date(2025, 8, 4, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		response types.AIResponse
+		want     types.AIResponse
+	}{
+		{
+			name:     "getEvents is for today",
+			response: types.AIResponse{Action: "getEvents"},
+			want:     types.AIResponse{Action: "getEvents", EventDate: "2025-08-04"},
+		},
+		{
+			name:     "getEvents keeps its day",
+			response: types.AIResponse{Action: "getEvents", EventDate: "2025-08-06"},
+			want:     types.AIResponse{Action: "getEvents", EventDate: "2025-08-06"},
+		},
+		{
+			name:     "range of one day",
+			response: types.AIResponse{Action: "getEventsRange", StartDate: "2025-08-06"},
+			want:     types.AIResponse{Action: "getEventsRange", StartDate: "2025-08-06", EndDate: "2025-08-06"},
+		},
+		{
+			name:     "other actions are left",
+			response: types.AIResponse{Action: "makeEvent", StartDate: "2025-08-06"},
+			want:     types.AIResponse{Action: "makeEvent", StartDate: "2025-08-06"},
+		},
+		{
+			name: "combined actions",
+			response: types.AIResponse{Actions: []types.AIAction{
+				{Action: "getEvents"},
+				{Action: "getEventsRange", StartDate: "2025-08-10"},
+			}},
+			want: types.AIResponse{Actions: []types.AIAction{
+				{Action: "getEvents", EventDate: "2025-08-04"},
+				{Action: "getEventsRange", StartDate: "2025-08-10", EndDate: "2025-08-10"},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := tt.response
+			applyDefaults(&response, now)
+			if !reflect.DeepEqual(response, tt.want) {
+				t.Errorf("applyDefaults() = %+v, want %+v", response, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckRange(t *testing.T) {
+	now := time.Date(2025, 8, 4, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		startDate string
+		endDate   string
+		required  bool
+		want      []string
+	}{
+		{"range", "2025-08-04", "2025-08-10", true, []string{}},
+		{"one day", "2025-08-04", "2025-08-04", true, []string{}},
+		{"open end", "2025-08-04", "", true, []string{}},
+		{"end before start", "2025-08-10", "2025-08-04", true, []string{"end_date?"}},
+		{"missing start", "", "2025-08-10", true, []string{"start_date?!"}},
+		{"optional", "", "", false, []string{}},
+		{"optional end only", "", "2025-08-10", false, []string{}},
+		{"bad dates", "soon", "later", false, []string{"start_date?", "end_date?"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := problemFields(checkRange("", tt.startDate, tt.endDate, tt.required, now))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("checkRange(%q, %q, %v) = %v, want %v", tt.startDate, tt.endDate, tt.required, got, tt.want)
+			}
+		})
+	}
+
+	if got := problemFields(checkRange("actions[1].", "", "", true, now)); !reflect.DeepEqual(got, []string{"actions[1].start_date?!"}) {
+		t.Errorf("checkRange() with prefix = %v, want actions[1].start_date", got)
+	}
+}
+
+func TestCheckClock(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		required bool
+		want     []string
+	}{
+		{"time", "15:30", true, []string{}},
+		{"midnight", "00:00", true, []string{}},
+		{"missing", "", true, []string{"event_time?!"}},
+		{"optional", "", false, []string{}},
+		{"not a time", "evening", false, []string{"event_time?"}},
+		{"out of range", "25:00", true, []string{"event_time?"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := problemFields(checkClock(tt.value, tt.required)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("checkClock(%q, %v) = %v, want %v", tt.value, tt.required, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecide(t *testing.T) {
+	tests := []struct {
+		name string
+		// replies are the model's answers in order; the last one is repeated
+		replies         []string
+		wantAction      string
+		wantMessage     string
+		wantProblems    []string
+		wantCompletions int
+	}{
+		{
+			name:       "plain text reply runs as a message",
+			replies:    []string{"Sure, happy to help with your calendar."},
+			wantAction: "message", wantMessage: "Sure, happy to help with your calendar.",
+			wantProblems: []string{}, wantCompletions: 1,
+		},
+		{
+			name:       "missing field is asked for without repairs",
+			replies:    []string{`{"action": "makeEvent", "message": "OK", "event_title": "Gym", "event_date": "2025-08-05"}`},
+			wantAction: "makeEvent", wantMessage: "OK",
+			wantProblems: []string{"event_time?!"}, wantCompletions: 1,
+		},
+		{
+			name: "wrong field is repaired",
+			replies: []string{
+				`{"action": "listEvents", "message": "OK"}`,
+				`{"action": "getEvents", "message": "OK", "event_date": "2025-08-05"}`,
+			},
+			wantAction: "getEvents", wantMessage: "OK", wantCompletions: 2,
+		},
+		{
+			name:       "repairs are bounded",
+			replies:    []string{`{"action": "listEvents", "message": "OK"}`},
+			wantAction: "listEvents", wantMessage: "OK",
+			wantProblems: []string{"action?"}, wantCompletions: maxRepairs + 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			completions := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reply := tt.replies[min(completions, len(tt.replies)-1)]
+				completions++
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+					Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: reply}}},
+				})
+			}))
+			defer server.Close()
+
+			config := openai.DefaultConfig("test")
+			config.BaseURL = server.URL + "/v1"
+			a := &Agent{openaiService: &OpenAIService{client: openai.NewClientWithConfig(config)}}
+
+			aiResponse, problems, err := a.decide(context.Background(), 1, nil, "hello")
+			if err != nil {
+				t.Fatalf("decide() error = %v", err)
+			}
+			if aiResponse.Action != tt.wantAction || aiResponse.Message != tt.wantMessage {
+				t.Errorf("decide() = %q, %q, want %q, %q", aiResponse.Action, aiResponse.Message, tt.wantAction, tt.wantMessage)
+			}
+			if got := problemFields(problems); len(got)+len(tt.wantProblems) > 0 && !reflect.DeepEqual(got, tt.wantProblems) {
+				t.Errorf("decide() problems = %v, want %v", got, tt.wantProblems)
+			}
+			if completions != tt.wantCompletions {
+				t.Errorf("decide() made %d completions, want %d", completions, tt.wantCompletions)
+			}
+		})
+	}
+}
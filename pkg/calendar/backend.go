@@ -0,0 +1,93 @@
+package calendar
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"calendar-assistant-bot/pkg/types"
+)
+
+// ErrEventExists is returned by Backend.CreateEvent when an event with the same ID was already
+// created, which happens when a create that timed out did reach the provider and is retried
+var ErrEventExists = errors.New("event already exists")
+
+// Backend is a calendar provider such as Google Calendar or Microsoft 365. A backend translates
+// single requests; Service adds date parsing, retries, merged reads across calendars, paging and
+// search on top. Calendar IDs are the provider's own, with "primary" for the default calendar.
+type Backend interface {
+	// ListCalendars returns a page of the calendars available to the credentials and the token
+	// of the next page, which is empty on the last page
+	ListCalendars(ctx context.Context, pageToken string) ([]types.CalendarInfo, string, error)
+	// ListEvents returns a page of the events of a calendar overlapping the query's range, in
+	// start time order with recurring events expanded, and the token of the next page
+	ListEvents(ctx context.Context, calendarID string, query EventsQuery) ([]types.CalendarEvent, string, error)
+	// CreateEvent creates event. A non-empty event.ID makes retries idempotent: creating the same
+	// ID again returns an error wrapping ErrEventExists.
+	CreateEvent(ctx context.Context, calendarID string, event types.CalendarEvent) error
+	// UpdateEvent changes the fields of the event event.ID that are set in event: title, times,
+	// description, location and attendees. The rest, such as reminders and recurrence, is left as
+	// it is. It returns the event as updated.
+	UpdateEvent(ctx context.Context, calendarID string, event types.CalendarEvent) (types.CalendarEvent, error)
+	// DeleteEvent deletes an event
+	DeleteEvent(ctx context.Context, calendarID, eventID string) error
+}
+
+// EventsQuery selects a page of events
+type EventsQuery struct {
+	Start time.Time
+	End   time.Time
+	// Text is a free text search. Backends without one may ignore it, since search results are
+	// filtered again locally.
+	Text      string
+	PageToken string
+	PageSize  int
+}
+
+// ErrSyncExpired is returned by SyncBackend.SyncEvents when the provider no longer accepts a sync
+// token; the calendar has to be synced in full again
+var ErrSyncExpired = errors.New("sync token expired")
+
+// SyncBackend is a Backend that reports what changed in a calendar since an earlier sync and
+// can push a notification when something does
+type SyncBackend interface {
+	Backend
+	// SyncEvents returns a page of the events changed since query.SyncToken, with deleted events
+	// marked cancelled. Without a sync token it lists the events between query.Start and query.End. The last
+	// page carries the sync token for the next sync.
+	SyncEvents(ctx context.Context, calendarID string, query SyncQuery) (*SyncPage, error)
+	// WatchEvents opens a channel through which the provider posts to channel.Address whenever
+	// events of the calendar change, and returns it with the provider's resource ID and expiration
+	WatchEvents(ctx context.Context, calendarID string, channel Channel) (Channel, error)
+	// StopChannel closes a channel
+	StopChannel(ctx context.Context, channel Channel) error
+}
+
+// SyncQuery selects a page of changed events
+type SyncQuery struct {
+	// Start and End bound a full sync; they are ignored when SyncToken is set
+	Start     time.Time
+	End       time.Time
+	SyncToken string
+	PageToken string
+	PageSize  int
+}
+
+// SyncPage is a page of changed events
+type SyncPage struct {
+	Events        []types.CalendarEvent
+	NextPageToken string
+	// NextSyncToken is set on the last page
+	NextSyncToken string
+}
+
+// Channel is a push notification channel for the events of a calendar
+type Channel struct {
+	ID         string
+	ResourceID string
+	// Address is the HTTPS URL notifications are posted to
+	Address string
+	// Token is sent back with every notification, to tell them from forged ones
+	Token      string
+	Expiration time.Time
+}
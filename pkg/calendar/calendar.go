@@ -2,237 +2,282 @@ package calendar
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"strings"
 	"time"
 
+	"calendar-assistant-bot/pkg/dateparse"
+	"calendar-assistant-bot/pkg/metrics"
+	"calendar-assistant-bot/pkg/resilience"
 	"calendar-assistant-bot/pkg/types"
 
-	"google.golang.org/api/calendar/v3"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
 )
 
-// Service handles all Google Calendar interactions
+// requestTimeout bounds each Google Calendar API call
+const requestTimeout = 10 * time.Second
+
+// Service handles all calendar interactions through a Backend.
+// Events are read from the read calendars and written to calendarID, which is also the only
+// read calendar unless WithCalendars selects others.
 type Service struct {
-	service    *calendar.Service
+	backend    Backend
 	calendarID string
+	calendars  []types.CalendarInfo
+	upstream   *resilience.Client
+	// cache holds the synced events of the calendars read through backends that sync. Reads are
+	// served from it and events the bot writes go there too.
+	cache *eventCache
 }
 
-// NewService creates a new Google Calendar service instance
-func NewService(service *calendar.Service, calendarID string) *Service {
+// NewService creates a new calendar service instance for a backend
+func NewService(ctx context.Context, backend Backend, calendarID string) *Service {
 	tool := &Service{
-		service:    service,
+		backend:    backend,
 		calendarID: calendarID,
+		upstream: resilience.NewClient("calendar", resilience.DefaultPolicy,
+			resilience.NewBreaker("calendar", 5, 30*time.Second), classifyError),
+		cache: newEventCache(),
 	}
 
-	// Test the connection
-	log.Printf("Testing Google Calendar connection...")
-	events, err := tool.GetEvents("today")
-	if err != nil {
-		log.Printf("Warning: Google Calendar connection test failed: %v", err)
+	// Test the connection with a single event. Backends that sync fill the event cache of the
+	// calendar in the background, so the first requests are served locally once that is done.
+	log.Printf("Testing calendar connection...")
+	if err := tool.Ping(ctx); err != nil {
+		log.Printf("Warning: calendar connection test failed: %v", err)
 	} else {
-		log.Printf("Google Calendar connection test successful, found %d events for today", len(events))
+		log.Printf("Calendar connection test successful")
+		if _, ok := backend.(SyncBackend); ok {
+			tool.fill(calendarID)
+		}
 	}
 
 	return tool
 }
 
-// GetEvents retrieves events from Google Calendar for a specific date
-func (s *Service) GetEvents(dateStr string) ([]types.CalendarEvent, error) {
-	// Parse date and set time range
-	var startTime, endTime time.Time
-	var err error
+// Ping checks that the calendar is reachable with the configured credentials
+func (s *Service) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	requestStart := time.Now()
+	_, _, err := s.backend.ListEvents(ctx, s.calendarID, EventsQuery{
+		Start:    requestStart,
+		End:      requestStart.Add(24 * time.Hour),
+		PageSize: 1,
+	})
+	metrics.ObserveCalendarRequest("ping", requestStart, err)
 
-	// Handle empty date string by defaulting to today
-	if dateStr == "" {
-		dateStr = "today"
+	if err != nil {
+		return fmt.Errorf("calendar not reachable: %v", err)
 	}
+	return nil
+}
 
-	if dateStr == "today" {
-		startTime = time.Now().Truncate(24 * time.Hour)
-		endTime = startTime.Add(24 * time.Hour)
-	} else if dateStr == "tomorrow" {
-		startTime = time.Now().Add(24 * time.Hour).Truncate(24 * time.Hour)
-		endTime = startTime.Add(24 * time.Hour)
-	} else if dateStr == "yesterday" {
-		startTime = time.Now().Add(-24 * time.Hour).Truncate(24 * time.Hour)
-		endTime = startTime.Add(24 * time.Hour)
-	} else {
-		// Try to parse specific date
-		startTime, err = time.Parse("2006-01-02", dateStr)
-		if err != nil {
-			return nil, fmt.Errorf("invalid date format: %v", err)
-		}
-		endTime = startTime.Add(24 * time.Hour)
+// call runs a single Google Calendar API request with a timeout per attempt, retries and metrics
+func (s *Service) call(ctx context.Context, operation string, fn func(ctx context.Context) error) error {
+	return s.upstream.Do(ctx, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+		defer cancel()
+
+		requestStart := time.Now()
+		err := fn(ctx)
+		metrics.ObserveCalendarRequest(operation, requestStart, err)
+		return err
+	})
+}
+
+// classifyError decides whether a Google or Graph API error is worth retrying
+func classifyError(err error) (bool, time.Duration) {
+	var graphErr *GraphError
+	if errors.As(err, &graphErr) {
+		return resilience.RetryableStatus(graphErr.StatusCode), graphErr.RetryAfter
 	}
 
-	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	// A user's token refresh that Google refused only succeeds on retry if Google failed
+	var tokenErr *oauth2.RetrieveError
+	if errors.As(err, &tokenErr) {
+		return tokenErr.Response != nil && resilience.RetryableStatus(tokenErr.Response.StatusCode), 0
+	}
 
-	events, err := s.service.Events.List(s.calendarID).
-		Context(ctx).
-		TimeMin(startTime.Format(time.RFC3339)).
-		TimeMax(endTime.Format(time.RFC3339)).
-		OrderBy("startTime").
-		SingleEvents(true).
-		Do()
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return resilience.DefaultClassifier(err)
+	}
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to get events: %v", err)
+	retryAfter := resilience.ParseRetryAfter(apiErr.Header.Get("Retry-After"))
+	if resilience.RetryableStatus(apiErr.Code) {
+		return true, retryAfter
 	}
 
-	var calendarEvents []types.CalendarEvent
-	for _, event := range events.Items {
-		start, _ := time.Parse(time.RFC3339, event.Start.DateTime)
-		end, _ := time.Parse(time.RFC3339, event.End.DateTime)
+	// Google reports quota exhaustion as 403 with a rate limit reason
+	if apiErr.Code == http.StatusForbidden {
+		for _, item := range apiErr.Errors {
+			if item.Reason == "rateLimitExceeded" || item.Reason == "userRateLimitExceeded" {
+				return true, retryAfter
+			}
+		}
+	}
+
+	return false, 0
+}
 
-		calendarEvents = append(calendarEvents, types.CalendarEvent{
-			ID:          event.Id,
-			Summary:     event.Summary,
-			Description: event.Description,
-			Start:       start,
-			End:         end,
-			Location:    event.Location,
-		})
+// newEventID returns a random event ID in the base32hex alphabet Google Calendar accepts
+func newEventID() string {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		// Let Google pick the ID instead
+		return ""
 	}
+	return strings.ToLower(base32.HexEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+}
 
-	return calendarEvents, nil
+// GetEvents retrieves the events of a specific date, given as anything dateparse.Date accepts
+// such as "2006-01-02", "tomorrow" or "next Friday". An empty date is today.
+func (s *Service) GetEvents(ctx context.Context, dateStr string) ([]types.CalendarEvent, error) {
+	// Handle empty date string by defaulting to today
+	if dateStr == "" {
+		dateStr = "today"
+	}
+
+	startTime, err := dateparse.Date(dateStr, time.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("invalid date format: %v", err)
+	}
+	endTime := startTime.AddDate(0, 0, 1)
+
+	return s.listEvents(ctx, startTime, endTime, "")
 }
 
-// GetEventsInRange retrieves events from Google Calendar within a date range
-func (s *Service) GetEventsInRange(startDate, endDate string) ([]types.CalendarEvent, error) {
+// GetEventsInRange retrieves the events within a date range, both dates included
+func (s *Service) GetEventsInRange(ctx context.Context, startDate, endDate string) ([]types.CalendarEvent, error) {
 	// Parse start and end dates
-	startTime, err := time.Parse("2006-01-02", startDate)
+	now := time.Now().UTC()
+	startTime, err := dateparse.Date(startDate, now)
 	if err != nil {
 		return nil, fmt.Errorf("invalid start date format: %v", err)
 	}
 
-	endTime, err := time.Parse("2006-01-02", endDate)
+	endTime, err := dateparse.Date(endDate, now)
 	if err != nil {
 		return nil, fmt.Errorf("invalid end date format: %v", err)
 	}
 
 	// Add one day to end date to include the full end date
-	endTime = endTime.Add(24 * time.Hour)
-
-	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	events, err := s.service.Events.List(s.calendarID).
-		Context(ctx).
-		TimeMin(startTime.Format(time.RFC3339)).
-		TimeMax(endTime.Format(time.RFC3339)).
-		OrderBy("startTime").
-		SingleEvents(true).
-		Do()
+	endTime = endTime.AddDate(0, 0, 1)
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to get events: %v", err)
-	}
+	return s.listEvents(ctx, startTime, endTime, "")
+}
 
+// listEvents retrieves all events between startTime and endTime, ordered by start time.
+// query is passed to the backend as a free text search and may be empty.
+func (s *Service) listEvents(ctx context.Context, startTime, endTime time.Time, query string) ([]types.CalendarEvent, error) {
 	var calendarEvents []types.CalendarEvent
-	for _, event := range events.Items {
-		start, _ := time.Parse(time.RFC3339, event.Start.DateTime)
-		end, _ := time.Parse(time.RFC3339, event.End.DateTime)
-
-		calendarEvents = append(calendarEvents, types.CalendarEvent{
-			ID:          event.Id,
-			Summary:     event.Summary,
-			Description: event.Description,
-			Start:       start,
-			End:         end,
-			Location:    event.Location,
-		})
+	it := s.Events(ctx, startTime, endTime, query)
+	for it.Next() {
+		calendarEvents = append(calendarEvents, it.Event())
+	}
+	if err := it.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get events: %w", err)
 	}
-
 	return calendarEvents, nil
 }
 
-// CreateEvent creates a new calendar event
-func (s *Service) CreateEvent(title, dateStr, timeStr, description, location string) error {
-	// Parse date and time
-	dateTimeStr := dateStr + " " + timeStr
-	startTime, err := time.Parse("2006-01-02 15:04", dateTimeStr)
+// parseStart parses the start of an event from a date and a time, given as anything
+// dateparse.DateTime accepts once joined, such as "2006-01-02" and "15:04" or "next Friday" and "3pm"
+func parseStart(dateStr, timeStr string) (time.Time, error) {
+	startTime, err := dateparse.DateTime(strings.TrimSpace(dateStr+" "+timeStr), time.Now().UTC())
 	if err != nil {
-		return fmt.Errorf("invalid date/time format: %v", err)
+		return time.Time{}, fmt.Errorf("invalid date/time format: %v", err)
 	}
+	return startTime, nil
+}
 
-	endTime := startTime.Add(1 * time.Hour) // Default 1 hour duration
+// CreateEvent creates a new calendar event
+func (s *Service) CreateEvent(ctx context.Context, title, dateStr, timeStr, description, location string) error {
+	startTime, err := parseStart(dateStr, timeStr)
+	if err != nil {
+		return err
+	}
 
-	event := &calendar.Event{
+	event := types.CalendarEvent{
+		// A client-chosen ID makes retries idempotent: if an attempt that timed out did create
+		// the event, the retry finds it exists instead of creating a duplicate
+		ID:          newEventID(),
 		Summary:     title,
 		Description: description,
 		Location:    location,
-		Start: &calendar.EventDateTime{
-			DateTime: startTime.Format(time.RFC3339),
-			TimeZone: "UTC",
-		},
-		End: &calendar.EventDateTime{
-			DateTime: endTime.Format(time.RFC3339),
-			TimeZone: "UTC",
-		},
-	}
-
-	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+		Start:       startTime,
+		End:         startTime.Add(1 * time.Hour), // Default 1 hour duration
+	}
 
-	_, err = s.service.Events.Insert(s.calendarID, event).Context(ctx).Do()
+	attempt := 0
+	err = s.call(ctx, "insert", func(ctx context.Context) error {
+		attempt++
+		err := s.backend.CreateEvent(ctx, s.calendarID, event)
+		if attempt > 1 && errors.Is(err, ErrEventExists) {
+			return nil
+		}
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("failed to create event: %v", err)
+		return fmt.Errorf("failed to create event: %w", err)
 	}
 
+	s.cache.put(s.calendarID, event)
 	return nil
 }
 
-// UpdateEvent updates an existing calendar event
-func (s *Service) UpdateEvent(eventID, title, dateStr, timeStr, description, location string) error {
-	// Parse date and time
-	dateTimeStr := dateStr + " " + timeStr
-	startTime, err := time.Parse("2006-01-02 15:04", dateTimeStr)
-	if err != nil {
-		return fmt.Errorf("invalid date/time format: %v", err)
-	}
-
-	endTime := startTime.Add(1 * time.Hour) // Default 1 hour duration
-
-	event := &calendar.Event{
+// UpdateEvent changes the fields of an existing calendar event that are given and leaves the
+// rest, such as its attendees. Without a date and time the event keeps its times; moved, it keeps
+// duration, or lasts an hour if that is zero.
+func (s *Service) UpdateEvent(ctx context.Context, eventID, title, dateStr, timeStr, description, location string, duration time.Duration) error {
+	event := types.CalendarEvent{
+		ID:          eventID,
 		Summary:     title,
 		Description: description,
 		Location:    location,
-		Start: &calendar.EventDateTime{
-			DateTime: startTime.Format(time.RFC3339),
-			TimeZone: "UTC",
-		},
-		End: &calendar.EventDateTime{
-			DateTime: endTime.Format(time.RFC3339),
-			TimeZone: "UTC",
-		},
-	}
-
-	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	}
+	if dateStr != "" || timeStr != "" {
+		startTime, err := parseStart(dateStr, timeStr)
+		if err != nil {
+			return err
+		}
+		if duration <= 0 {
+			duration = time.Hour
+		}
+		event.Start, event.End = startTime, startTime.Add(duration)
+	}
 
-	_, err = s.service.Events.Update(s.calendarID, eventID, event).Context(ctx).Do()
+	var updated types.CalendarEvent
+	err := s.call(ctx, "update", func(ctx context.Context) error {
+		var err error
+		updated, err = s.backend.UpdateEvent(ctx, s.calendarID, event)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("failed to update event: %v", err)
+		return fmt.Errorf("failed to update event: %w", err)
 	}
 
+	s.cache.put(s.calendarID, updated)
 	return nil
 }
 
 // DeleteEvent deletes a calendar event
-func (s *Service) DeleteEvent(eventID string) error {
-	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	err := s.service.Events.Delete(s.calendarID, eventID).Context(ctx).Do()
+func (s *Service) DeleteEvent(ctx context.Context, eventID string) error {
+	err := s.call(ctx, "delete", func(ctx context.Context) error {
+		return s.backend.DeleteEvent(ctx, s.calendarID, eventID)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to delete event: %v", err)
+		return fmt.Errorf("failed to delete event: %w", err)
 	}
 
+	s.cache.remove(s.calendarID, eventID)
 	return nil
 }
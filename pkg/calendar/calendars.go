@@ -0,0 +1,75 @@
+package calendar
+
+import (
+	"context"
+	"fmt"
+
+	"calendar-assistant-bot/pkg/types"
+)
+
+// ListCalendars returns the calendars available to the credentials, primary calendar first
+func (s *Service) ListCalendars(ctx context.Context) ([]types.CalendarInfo, error) {
+	var calendars []types.CalendarInfo
+	pageToken := ""
+	for {
+		var page []types.CalendarInfo
+		var nextPageToken string
+		err := s.call(ctx, "calendar_list", func(ctx context.Context) error {
+			var err error
+			page, nextPageToken, err = s.backend.ListCalendars(ctx, pageToken)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list calendars: %w", err)
+		}
+
+		for _, info := range page {
+			if info.Primary {
+				calendars = append([]types.CalendarInfo{info}, calendars...)
+			} else {
+				calendars = append(calendars, info)
+			}
+		}
+
+		if nextPageToken == "" {
+			return calendars, nil
+		}
+		pageToken = nextPageToken
+	}
+}
+
+// WithCalendars returns a view of the service that reads from the read calendars and writes to
+// the write calendar. Events read from several calendars are labelled with their calendar's name.
+// The view shares the client, retries and circuit breaker of s.
+func (s *Service) WithCalendars(read []types.CalendarInfo, write string) *Service {
+	view := *s
+	view.calendars = read
+	if write != "" {
+		view.calendarID = write
+	}
+	return &view
+}
+
+// InCalendar returns a view of the service that writes to calendarID, for changing an event
+// in the calendar it was read from. An empty calendarID returns s.
+func (s *Service) InCalendar(calendarID string) *Service {
+	if calendarID == "" {
+		return s
+	}
+	view := *s
+	view.calendarID = calendarID
+	return &view
+}
+
+// CalendarID returns the ID of the calendar new events are added to
+func (s *Service) CalendarID() string {
+	return s.calendarID
+}
+
+// readCalendars returns the calendars events are read from
+func (s *Service) readCalendars() []types.CalendarInfo {
+	if len(s.calendars) == 0 {
+		return []types.CalendarInfo{{ID: s.calendarID}}
+	}
+	return s.calendars
+}
@@ -0,0 +1,254 @@
+package calendar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"calendar-assistant-bot/pkg/types"
+
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// GoogleBackend is the Google Calendar backend
+type GoogleBackend struct {
+	service *calendar.Service
+}
+
+// NewGoogleBackend creates a backend for a Google Calendar API client
+func NewGoogleBackend(service *calendar.Service) *GoogleBackend {
+	return &GoogleBackend{service: service}
+}
+
+// ListCalendars returns a page of the calendar list
+func (b *GoogleBackend) ListCalendars(ctx context.Context, pageToken string) ([]types.CalendarInfo, string, error) {
+	call := b.service.CalendarList.List().Context(ctx)
+	if pageToken != "" {
+		call = call.PageToken(pageToken)
+	}
+	list, err := call.Do()
+	if err != nil {
+		return nil, "", err
+	}
+
+	calendars := make([]types.CalendarInfo, 0, len(list.Items))
+	for _, entry := range list.Items {
+		info := types.CalendarInfo{
+			ID:       entry.Id,
+			Name:     entry.Summary,
+			Color:    entry.BackgroundColor,
+			Primary:  entry.Primary,
+			Writable: entry.AccessRole == "owner" || entry.AccessRole == "writer",
+		}
+		if entry.SummaryOverride != "" {
+			info.Name = entry.SummaryOverride
+		}
+		calendars = append(calendars, info)
+	}
+	return calendars, list.NextPageToken, nil
+}
+
+// ListEvents returns a page of events
+func (b *GoogleBackend) ListEvents(ctx context.Context, calendarID string, query EventsQuery) ([]types.CalendarEvent, string, error) {
+	call := b.service.Events.List(calendarID).
+		Context(ctx).
+		TimeMin(query.Start.Format(time.RFC3339)).
+		TimeMax(query.End.Format(time.RFC3339)).
+		OrderBy("startTime").
+		SingleEvents(true)
+	if query.PageSize > 0 {
+		call = call.MaxResults(int64(query.PageSize))
+	}
+	if query.Text != "" {
+		call = call.Q(query.Text)
+	}
+	if query.PageToken != "" {
+		call = call.PageToken(query.PageToken)
+	}
+
+	events, err := call.Do()
+	if err != nil {
+		return nil, "", err
+	}
+
+	calendarEvents := make([]types.CalendarEvent, 0, len(events.Items))
+	for _, event := range events.Items {
+		calendarEvents = append(calendarEvents, convertEvent(event))
+	}
+	return calendarEvents, events.NextPageToken, nil
+}
+
+// CreateEvent inserts an event. Google accepts client-chosen IDs and answers a second insert
+// of the same ID with a conflict.
+func (b *GoogleBackend) CreateEvent(ctx context.Context, calendarID string, event types.CalendarEvent) error {
+	_, err := b.service.Events.Insert(calendarID, googleEvent(event)).Context(ctx).Do()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusConflict {
+		return fmt.Errorf("%w: %w", ErrEventExists, err)
+	}
+	return err
+}
+
+// UpdateEvent patches the fields of an event that are set; Update would replace the whole event
+func (b *GoogleBackend) UpdateEvent(ctx context.Context, calendarID string, event types.CalendarEvent) (types.CalendarEvent, error) {
+	changes := googleEvent(event)
+	if event.Start.IsZero() {
+		changes.Start, changes.End = nil, nil
+	}
+	updated, err := b.service.Events.Patch(calendarID, event.ID, changes).Context(ctx).Do()
+	if err != nil {
+		return types.CalendarEvent{}, err
+	}
+	return convertEvent(updated), nil
+}
+
+// DeleteEvent deletes an event
+func (b *GoogleBackend) DeleteEvent(ctx context.Context, calendarID, eventID string) error {
+	return b.service.Events.Delete(calendarID, eventID).Context(ctx).Do()
+}
+
+// SyncEvents returns a page of changed events. Google answers an expired sync token with 410 Gone.
+func (b *GoogleBackend) SyncEvents(ctx context.Context, calendarID string, query SyncQuery) (*SyncPage, error) {
+	call := b.service.Events.List(calendarID).
+		Context(ctx).
+		SingleEvents(true)
+	if query.SyncToken != "" {
+		call = call.SyncToken(query.SyncToken)
+	} else {
+		call = call.TimeMin(query.Start.Format(time.RFC3339)).
+			TimeMax(query.End.Format(time.RFC3339))
+	}
+	if query.PageSize > 0 {
+		call = call.MaxResults(int64(query.PageSize))
+	}
+	if query.PageToken != "" {
+		call = call.PageToken(query.PageToken)
+	}
+
+	events, err := call.Do()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusGone {
+		return nil, fmt.Errorf("%w: %w", ErrSyncExpired, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	page := &SyncPage{NextPageToken: events.NextPageToken, NextSyncToken: events.NextSyncToken}
+	for _, event := range events.Items {
+		page.Events = append(page.Events, convertEvent(event))
+	}
+	return page, nil
+}
+
+// WatchEvents opens a web hook channel for the events of a calendar
+func (b *GoogleBackend) WatchEvents(ctx context.Context, calendarID string, channel Channel) (Channel, error) {
+	request := &calendar.Channel{
+		Id:      channel.ID,
+		Type:    "web_hook",
+		Address: channel.Address,
+		Token:   channel.Token,
+	}
+	if !channel.Expiration.IsZero() {
+		request.Expiration = channel.Expiration.UnixMilli()
+	}
+
+	opened, err := b.service.Events.Watch(calendarID, request).Context(ctx).Do()
+	if err != nil {
+		return Channel{}, err
+	}
+
+	channel.ResourceID = opened.ResourceId
+	if opened.Expiration > 0 {
+		channel.Expiration = time.UnixMilli(opened.Expiration)
+	}
+	return channel, nil
+}
+
+// StopChannel closes a channel
+func (b *GoogleBackend) StopChannel(ctx context.Context, channel Channel) error {
+	return b.service.Channels.Stop(&calendar.Channel{Id: channel.ID, ResourceId: channel.ResourceID}).Context(ctx).Do()
+}
+
+// googleEvent converts an event for Google. Times keep their time zone; attendees are invited
+// by email address, so names without one are left out.
+func googleEvent(event types.CalendarEvent) *calendar.Event {
+	googleEvent := &calendar.Event{
+		Id:          event.ID,
+		Summary:     event.Summary,
+		Description: event.Description,
+		Location:    event.Location,
+		Start: &calendar.EventDateTime{
+			DateTime: event.Start.Format(time.RFC3339),
+			TimeZone: timeZoneName(event.Start),
+		},
+		End: &calendar.EventDateTime{
+			DateTime: event.End.Format(time.RFC3339),
+			TimeZone: timeZoneName(event.End),
+		},
+	}
+	for _, attendee := range event.Attendees {
+		if strings.Contains(attendee, "@") {
+			googleEvent.Attendees = append(googleEvent.Attendees, &calendar.EventAttendee{Email: attendee})
+		}
+	}
+	return googleEvent
+}
+
+// timeZoneName returns the IANA name of the time zone of t, or UTC for times without one
+func timeZoneName(t time.Time) string {
+	name := t.Location().String()
+	if name == "" || name == "Local" {
+		return "UTC"
+	}
+	return name
+}
+
+// convertEvent converts a Google Calendar event. All-day events start and end at midnight UTC.
+func convertEvent(event *calendar.Event) types.CalendarEvent {
+	var attendees []string
+	for _, attendee := range event.Attendees {
+		if attendee.DisplayName != "" {
+			attendees = append(attendees, attendee.DisplayName)
+		} else if attendee.Email != "" {
+			attendees = append(attendees, attendee.Email)
+		}
+	}
+
+	var organizer string
+	if event.Organizer != nil {
+		organizer = event.Organizer.DisplayName
+		if organizer == "" {
+			organizer = event.Organizer.Email
+		}
+	}
+
+	return types.CalendarEvent{
+		ID:          event.Id,
+		Summary:     event.Summary,
+		Description: event.Description,
+		Start:       eventTime(event.Start),
+		End:         eventTime(event.End),
+		Location:    event.Location,
+		Attendees:   attendees,
+		Organizer:   organizer,
+		Cancelled:   event.Status == "cancelled",
+	}
+}
+
+// eventTime parses the start or end of an event, which is a date for all-day events
+func eventTime(t *calendar.EventDateTime) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	if t.DateTime != "" {
+		parsed, _ := time.Parse(time.RFC3339, t.DateTime)
+		return parsed
+	}
+	parsed, _ := time.Parse("2006-01-02", t.Date)
+	return parsed
+}
@@ -0,0 +1,95 @@
+package calendar
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	calendarapi "google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+)
+
+func TestGoogleUpdateEventKeepsTheRest(t *testing.T) {
+	var mutex sync.Mutex
+	stored := map[string]json.RawMessage{
+		"id":        json.RawMessage(`"ev1"`),
+		"summary":   json.RawMessage(`"Workshop"`),
+		"start":     json.RawMessage(`{"dateTime": "2025-08-05T09:00:00Z"}`),
+		"end":       json.RawMessage(`{"dateTime": "2025-08-05T11:00:00Z"}`),
+		"attendees": json.RawMessage(`[{"email": "ann@example.com"}, {"email": "bob@example.com"}]`),
+		"reminders": json.RawMessage(`{"useDefault": false, "overrides": [{"method": "popup", "minutes": 30}]}`),
+	}
+	var methods []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodGet {
+			writeJSON(w, http.StatusOK, `{"items": [], "nextSyncToken": "token-1"}`)
+			return
+		}
+
+		// Fields sent replace the stored ones; a PUT would replace the whole event
+		methods = append(methods, r.Method)
+		var changes map[string]json.RawMessage
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &changes); err != nil {
+			writeJSON(w, http.StatusBadRequest, `{"error": {"code": 400, "message": "bad request"}}`)
+			return
+		}
+		if r.Method == http.MethodPut {
+			stored = map[string]json.RawMessage{}
+		}
+		for field, value := range changes {
+			stored[field] = value
+		}
+		data, _ := json.Marshal(stored)
+		writeJSON(w, http.StatusOK, string(data))
+	}))
+	defer server.Close()
+
+	api, err := calendarapi.NewService(context.Background(),
+		option.WithEndpoint(server.URL+"/"), option.WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatalf("calendar.NewService() error = %v", err)
+	}
+	service := NewService(context.Background(), NewGoogleBackend(api), "primary")
+
+	// "Move the workshop to 5pm"
+	if err := service.UpdateEvent(context.Background(), "ev1", "", "2025-08-05", "17:00", "", "", 2*time.Hour); err != nil {
+		t.Fatalf("UpdateEvent() error = %v", err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(methods) != 1 || methods[0] != http.MethodPatch {
+		t.Fatalf("requests = %v, want one PATCH", methods)
+	}
+	var event calendarapi.Event
+	data, _ := json.Marshal(stored)
+	if err := json.Unmarshal(data, &event); err != nil {
+		t.Fatal(err)
+	}
+	start, _ := time.Parse(time.RFC3339, event.Start.DateTime)
+	end, _ := time.Parse(time.RFC3339, event.End.DateTime)
+	if want := time.Date(2025, 8, 5, 17, 0, 0, 0, time.UTC); !start.Equal(want) {
+		t.Errorf("start = %v, want %v", start, want)
+	}
+	if got := end.Sub(start); got != 2*time.Hour {
+		t.Errorf("duration = %v, want 2h", got)
+	}
+	if event.Summary != "Workshop" {
+		t.Errorf("summary = %q, want Workshop", event.Summary)
+	}
+	if len(event.Attendees) != 2 {
+		t.Errorf("attendees = %d, want 2", len(event.Attendees))
+	}
+	if event.Reminders == nil || len(event.Reminders.Overrides) != 1 {
+		t.Errorf("reminders = %+v, want the override kept", event.Reminders)
+	}
+}
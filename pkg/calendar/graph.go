@@ -0,0 +1,384 @@
+package calendar
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"calendar-assistant-bot/pkg/resilience"
+	"calendar-assistant-bot/pkg/types"
+
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+const (
+	// GraphEndpoint is the Microsoft Graph API the Graph backend talks to by default
+	GraphEndpoint = "https://graph.microsoft.com/v1.0"
+	// graphDateTimeFormat is how Graph writes the local date and time of an event
+	graphDateTimeFormat = "2006-01-02T15:04:05.9999999"
+	// graphPrefer asks Graph for times in UTC and plain text descriptions
+	graphPrefer = `outlook.timezone="UTC", outlook.body-content-type="text"`
+)
+
+// GraphBackend is the Microsoft 365 / Outlook calendar backend, using the Microsoft Graph API
+type GraphBackend struct {
+	client   *http.Client
+	endpoint string
+	user     string
+}
+
+// NewGraphBackend creates a backend for the calendars of user, a user ID or principal name.
+// An empty user means the signed-in user (/me), which needs a delegated token. client must add
+// the access token to requests, as NewGraphClient's does; an empty endpoint means GraphEndpoint.
+func NewGraphBackend(client *http.Client, endpoint, user string) *GraphBackend {
+	if endpoint == "" {
+		endpoint = GraphEndpoint
+	}
+	return &GraphBackend{
+		client:   client,
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		user:     user,
+	}
+}
+
+// NewGraphClient returns an HTTP client authenticating as an Azure AD application with the
+// client credentials flow. The application needs the Calendars.ReadWrite application permission.
+func NewGraphClient(ctx context.Context, tenantID, clientID, clientSecret string) *http.Client {
+	config := &clientcredentials.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		TokenURL:     "https://login.microsoftonline.com/" + url.PathEscape(tenantID) + "/oauth2/v2.0/token",
+		Scopes:       []string{"https://graph.microsoft.com/.default"},
+	}
+	return config.Client(ctx)
+}
+
+// GraphError is an error response of the Graph API
+type GraphError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *GraphError) Error() string {
+	return fmt.Sprintf("graph: HTTP %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// graphEvent is an event resource of the Graph API
+type graphEvent struct {
+	ID            string          `json:"id,omitempty"`
+	TransactionID string          `json:"transactionId,omitempty"`
+	Subject       string          `json:"subject"`
+	Body          graphBody       `json:"body"`
+	Start         graphDateTime   `json:"start"`
+	End           graphDateTime   `json:"end"`
+	Location      graphLocation   `json:"location"`
+	Attendees     []graphAttendee `json:"attendees,omitempty"`
+	Organizer     *graphAttendee  `json:"organizer,omitempty"`
+	IsAllDay      bool            `json:"isAllDay,omitempty"`
+	IsCancelled   bool            `json:"isCancelled,omitempty"`
+}
+
+type graphBody struct {
+	ContentType string `json:"contentType"`
+	Content     string `json:"content"`
+}
+
+// graphDateTime is a local date and time with the name of its time zone
+type graphDateTime struct {
+	DateTime string `json:"dateTime"`
+	TimeZone string `json:"timeZone"`
+}
+
+type graphLocation struct {
+	DisplayName string `json:"displayName"`
+}
+
+type graphAttendee struct {
+	EmailAddress graphEmailAddress `json:"emailAddress"`
+	Type         string            `json:"type,omitempty"`
+}
+
+type graphEmailAddress struct {
+	Name    string `json:"name,omitempty"`
+	Address string `json:"address"`
+}
+
+// graphCalendar is a calendar resource of the Graph API
+type graphCalendar struct {
+	ID                string `json:"id"`
+	Name              string `json:"name"`
+	HexColor          string `json:"hexColor"`
+	CanEdit           bool   `json:"canEdit"`
+	IsDefaultCalendar bool   `json:"isDefaultCalendar"`
+}
+
+// ListCalendars returns a page of the user's calendars. The page token is Graph's next link.
+func (b *GraphBackend) ListCalendars(ctx context.Context, pageToken string) ([]types.CalendarInfo, string, error) {
+	requestURL := pageToken
+	if requestURL == "" {
+		requestURL = b.userURL() + "/calendars"
+	}
+
+	var page struct {
+		Value    []graphCalendar `json:"value"`
+		NextLink string          `json:"@odata.nextLink"`
+	}
+	if err := b.do(ctx, http.MethodGet, requestURL, nil, &page); err != nil {
+		return nil, "", err
+	}
+
+	calendars := make([]types.CalendarInfo, 0, len(page.Value))
+	for _, entry := range page.Value {
+		calendars = append(calendars, types.CalendarInfo{
+			ID:       entry.ID,
+			Name:     entry.Name,
+			Color:    entry.HexColor,
+			Primary:  entry.IsDefaultCalendar,
+			Writable: entry.CanEdit,
+		})
+	}
+	return calendars, page.NextLink, nil
+}
+
+// ListEvents returns a page of the calendar view, which expands recurring events. Graph has no
+// free text search on calendar views, so query.Text is ignored. Cancelled events are left out.
+func (b *GraphBackend) ListEvents(ctx context.Context, calendarID string, query EventsQuery) ([]types.CalendarEvent, string, error) {
+	requestURL := query.PageToken
+	if requestURL == "" {
+		params := url.Values{}
+		params.Set("startDateTime", query.Start.UTC().Format(time.RFC3339))
+		params.Set("endDateTime", query.End.UTC().Format(time.RFC3339))
+		params.Set("$orderby", "start/dateTime")
+		if query.PageSize > 0 {
+			params.Set("$top", strconv.Itoa(query.PageSize))
+		}
+		requestURL = b.calendarURL(calendarID) + "/calendarView?" + params.Encode()
+	}
+
+	var page struct {
+		Value    []graphEvent `json:"value"`
+		NextLink string       `json:"@odata.nextLink"`
+	}
+	if err := b.do(ctx, http.MethodGet, requestURL, nil, &page); err != nil {
+		return nil, "", err
+	}
+
+	events := make([]types.CalendarEvent, 0, len(page.Value))
+	for _, event := range page.Value {
+		if !event.IsCancelled {
+			events = append(events, convertGraphEvent(event))
+		}
+	}
+	return events, page.NextLink, nil
+}
+
+// CreateEvent creates an event. Graph picks event IDs itself, so event.ID is sent as the
+// transaction ID, which Graph uses to recognize a repeated create.
+func (b *GraphBackend) CreateEvent(ctx context.Context, calendarID string, event types.CalendarEvent) error {
+	body := newGraphEvent(event)
+	body.TransactionID = event.ID
+	err := b.do(ctx, http.MethodPost, b.calendarURL(calendarID)+"/events", body, nil)
+	var graphErr *GraphError
+	if errors.As(err, &graphErr) && graphErr.StatusCode == http.StatusConflict {
+		return fmt.Errorf("%w: %w", ErrEventExists, err)
+	}
+	return err
+}
+
+// UpdateEvent patches the fields of an event that are set. Attendees are only changed if event
+// has any, since removing them from an Outlook event sends them cancellations.
+func (b *GraphBackend) UpdateEvent(ctx context.Context, calendarID string, event types.CalendarEvent) (types.CalendarEvent, error) {
+	var updated graphEvent
+	if err := b.do(ctx, http.MethodPatch, b.calendarURL(calendarID)+"/events/"+url.PathEscape(event.ID), graphEventChanges(event), &updated); err != nil {
+		return types.CalendarEvent{}, err
+	}
+	return convertGraphEvent(updated), nil
+}
+
+// DeleteEvent deletes an event
+func (b *GraphBackend) DeleteEvent(ctx context.Context, calendarID, eventID string) error {
+	return b.do(ctx, http.MethodDelete, b.calendarURL(calendarID)+"/events/"+url.PathEscape(eventID), nil, nil)
+}
+
+// userURL returns the URL of the user whose calendars the backend manages
+func (b *GraphBackend) userURL() string {
+	if b.user == "" {
+		return b.endpoint + "/me"
+	}
+	return b.endpoint + "/users/" + url.PathEscape(b.user)
+}
+
+// calendarURL returns the URL of a calendar; "primary" is the user's default calendar
+func (b *GraphBackend) calendarURL(calendarID string) string {
+	if calendarID == "" || calendarID == "primary" {
+		return b.userURL() + "/calendar"
+	}
+	return b.userURL() + "/calendars/" + url.PathEscape(calendarID)
+}
+
+// do sends a request with body encoded as JSON and decodes the response into result, if not nil
+func (b *GraphBackend) do(ctx context.Context, method, requestURL string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Prefer", graphPrefer)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return graphError(resp)
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+	return nil
+}
+
+// graphError reads the error of a failed response
+func graphError(resp *http.Response) *GraphError {
+	graphErr := &GraphError{
+		StatusCode: resp.StatusCode,
+		RetryAfter: resilience.ParseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+
+	var body struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(data, &body) == nil {
+		graphErr.Code = body.Error.Code
+		graphErr.Message = body.Error.Message
+	}
+	if graphErr.Code == "" {
+		graphErr.Code = http.StatusText(resp.StatusCode)
+	}
+	return graphErr
+}
+
+// newGraphEvent converts an event for Graph. Times keep their time zone; attendees are invited
+// by email address, so names without one are left out.
+func newGraphEvent(event types.CalendarEvent) graphEvent {
+	graph := graphEvent{
+		Subject:  event.Summary,
+		Body:     graphBody{ContentType: "text", Content: event.Description},
+		Start:    graphDateTime{DateTime: event.Start.Format("2006-01-02T15:04:05"), TimeZone: timeZoneName(event.Start)},
+		End:      graphDateTime{DateTime: event.End.Format("2006-01-02T15:04:05"), TimeZone: timeZoneName(event.End)},
+		Location: graphLocation{DisplayName: event.Location},
+	}
+	for _, attendee := range event.Attendees {
+		if strings.Contains(attendee, "@") {
+			graph.Attendees = append(graph.Attendees, graphAttendee{
+				EmailAddress: graphEmailAddress{Address: attendee},
+				Type:         "required",
+			})
+		}
+	}
+	return graph
+}
+
+// graphEventChanges returns the fields of event that are set, as the body of a PATCH
+func graphEventChanges(event types.CalendarEvent) map[string]interface{} {
+	graph := newGraphEvent(event)
+	changes := map[string]interface{}{}
+	if event.Summary != "" {
+		changes["subject"] = graph.Subject
+	}
+	if event.Description != "" {
+		changes["body"] = graph.Body
+	}
+	if event.Location != "" {
+		changes["location"] = graph.Location
+	}
+	if !event.Start.IsZero() {
+		changes["start"], changes["end"] = graph.Start, graph.End
+	}
+	if len(graph.Attendees) > 0 {
+		changes["attendees"] = graph.Attendees
+	}
+	return changes
+}
+
+// convertGraphEvent converts a Graph event. All-day events start and end at midnight UTC, as for Google.
+func convertGraphEvent(event graphEvent) types.CalendarEvent {
+	var attendees []string
+	for _, attendee := range event.Attendees {
+		if attendee.EmailAddress.Name != "" {
+			attendees = append(attendees, attendee.EmailAddress.Name)
+		} else if attendee.EmailAddress.Address != "" {
+			attendees = append(attendees, attendee.EmailAddress.Address)
+		}
+	}
+
+	var organizer string
+	if event.Organizer != nil {
+		organizer = event.Organizer.EmailAddress.Name
+		if organizer == "" {
+			organizer = event.Organizer.EmailAddress.Address
+		}
+	}
+
+	return types.CalendarEvent{
+		ID:          event.ID,
+		Summary:     event.Subject,
+		Description: strings.TrimSpace(event.Body.Content),
+		Start:       graphTime(event.Start, event.IsAllDay),
+		End:         graphTime(event.End, event.IsAllDay),
+		Location:    event.Location.DisplayName,
+		Attendees:   attendees,
+		Organizer:   organizer,
+	}
+}
+
+// graphTime parses a Graph date and time in its time zone. Zones Go doesn't know, such as
+// Windows zone names, are taken as UTC, which is what the backend asks Graph for.
+func graphTime(t graphDateTime, allDay bool) time.Time {
+	location := time.UTC
+	if t.TimeZone != "" {
+		if loaded, err := time.LoadLocation(t.TimeZone); err == nil {
+			location = loaded
+		}
+	}
+
+	parsed, err := time.ParseInLocation(graphDateTimeFormat, t.DateTime, location)
+	if err != nil {
+		return time.Time{}
+	}
+	if allDay {
+		year, month, day := parsed.Date()
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	return parsed
+}
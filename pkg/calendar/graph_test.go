@@ -0,0 +1,386 @@
+package calendar
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"calendar-assistant-bot/pkg/types"
+)
+
+// fakeGraph is a local stand-in for the Graph endpoints the backend uses. It records the
+// requests it receives and answers them with handle.
+type fakeGraph struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	requests []recordedRequest
+	handle   func(w http.ResponseWriter, r *http.Request, body []byte)
+}
+
+type recordedRequest struct {
+	method string
+	path   string
+	query  string
+	prefer string
+	body   []byte
+}
+
+func newFakeGraph(t *testing.T, handle func(w http.ResponseWriter, r *http.Request, body []byte)) *fakeGraph {
+	fake := &fakeGraph{handle: handle}
+	fake.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fake.mutex.Lock()
+		fake.requests = append(fake.requests, recordedRequest{
+			method: r.Method,
+			path:   r.URL.EscapedPath(),
+			query:  r.URL.RawQuery,
+			prefer: r.Header.Get("Prefer"),
+			body:   body,
+		})
+		fake.mutex.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		fake.handle(w, r, body)
+	}))
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+// last returns the last request with the given method
+func (f *fakeGraph) last(t *testing.T, method string) recordedRequest {
+	t.Helper()
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for i := len(f.requests) - 1; i >= 0; i-- {
+		if f.requests[i].method == method {
+			return f.requests[i]
+		}
+	}
+	t.Fatalf("no %s request received", method)
+	return recordedRequest{}
+}
+
+// count returns the number of requests with the given method
+func (f *fakeGraph) count(method string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	n := 0
+	for _, request := range f.requests {
+		if request.method == method {
+			n++
+		}
+	}
+	return n
+}
+
+func writeJSON(w http.ResponseWriter, status int, body string) {
+	w.WriteHeader(status)
+	io.WriteString(w, body)
+}
+
+// emptyCalendarView answers calendar view requests without events, as for the startup check
+func emptyCalendarView(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/calendarView") {
+		writeJSON(w, http.StatusOK, `{"value": []}`)
+		return true
+	}
+	return false
+}
+
+func newGraphService(t *testing.T, fake *fakeGraph, calendarID string) *Service {
+	t.Helper()
+	backend := NewGraphBackend(fake.Client(), fake.URL+"/v1.0/", "ann@example.com")
+	return NewService(context.Background(), backend, calendarID)
+}
+
+func TestGraphListEvents(t *testing.T) {
+	var fake *fakeGraph
+	fake = newFakeGraph(t, func(w http.ResponseWriter, r *http.Request, body []byte) {
+		switch r.URL.Query().Get("page") {
+		case "":
+			writeJSON(w, http.StatusOK, `{
+				"value": [
+					{
+						"id": "ev1",
+						"subject": "Standup",
+						"body": {"contentType": "text", "content": "Daily sync\r\n"},
+						"start": {"dateTime": "2025-08-04T09:00:00.0000000", "timeZone": "UTC"},
+						"end": {"dateTime": "2025-08-04T09:15:00.0000000", "timeZone": "UTC"},
+						"location": {"displayName": "Room 1"},
+						"attendees": [
+							{"emailAddress": {"name": "Bob Smith", "address": "bob@example.com"}},
+							{"emailAddress": {"address": "carol@example.com"}}
+						]
+					},
+					{
+						"id": "ev2",
+						"subject": "Cancelled review",
+						"isCancelled": true,
+						"start": {"dateTime": "2025-08-04T10:00:00.0000000", "timeZone": "UTC"},
+						"end": {"dateTime": "2025-08-04T11:00:00.0000000", "timeZone": "UTC"}
+					}
+				],
+				"@odata.nextLink": "`+fake.URL+`/v1.0/users/ann@example.com/calendar/calendarView?page=2"
+			}`)
+		case "2":
+			writeJSON(w, http.StatusOK, `{
+				"value": [
+					{
+						"id": "ev3",
+						"subject": "Lunch in Berlin",
+						"start": {"dateTime": "2025-08-04T13:30:00.0000000", "timeZone": "Europe/Berlin"},
+						"end": {"dateTime": "2025-08-04T14:30:00.0000000", "timeZone": "Europe/Berlin"}
+					},
+					{
+						"id": "ev4",
+						"subject": "Holiday",
+						"isAllDay": true,
+						"start": {"dateTime": "2025-08-05T00:00:00.0000000", "timeZone": "UTC"},
+						"end": {"dateTime": "2025-08-06T00:00:00.0000000", "timeZone": "UTC"}
+					}
+				]
+			}`)
+		}
+	})
+	service := newGraphService(t, fake, "primary")
+
+	events, err := service.GetEventsInRange(context.Background(), "2025-08-04", "2025-08-05")
+	if err != nil {
+		t.Fatalf("GetEventsInRange() error = %v", err)
+	}
+
+	var ids []string
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	if want := []string{"ev1", "ev3", "ev4"}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("event IDs = %v, want %v", ids, want)
+	}
+
+	standup := events[0]
+	if standup.Summary != "Standup" || standup.Description != "Daily sync" || standup.Location != "Room 1" {
+		t.Errorf("standup = %+v", standup)
+	}
+	if want := []string{"Bob Smith", "carol@example.com"}; !reflect.DeepEqual(standup.Attendees, want) {
+		t.Errorf("attendees = %v, want %v", standup.Attendees, want)
+	}
+	if standup.CalendarID != "primary" {
+		t.Errorf("CalendarID = %q, want primary", standup.CalendarID)
+	}
+
+	// 13:30 in Berlin is 11:30 UTC in summer
+	if want := time.Date(2025, 8, 4, 11, 30, 0, 0, time.UTC); !events[1].Start.Equal(want) {
+		t.Errorf("Berlin lunch starts at %v, want %v", events[1].Start, want)
+	}
+	if want := time.Date(2025, 8, 5, 0, 0, 0, 0, time.UTC); !events[2].Start.Equal(want) {
+		t.Errorf("all-day event starts at %v, want %v", events[2].Start, want)
+	}
+
+	first := fake.requests[len(fake.requests)-2]
+	if first.path != "/v1.0/users/ann@example.com/calendar/calendarView" {
+		t.Errorf("path = %q", first.path)
+	}
+	for _, param := range []string{"startDateTime=2025-08-04T00%3A00%3A00Z", "endDateTime=2025-08-06T00%3A00%3A00Z", "%24top=250"} {
+		if !strings.Contains(first.query, param) {
+			t.Errorf("query %q lacks %s", first.query, param)
+		}
+	}
+	if !strings.Contains(first.prefer, `outlook.timezone="UTC"`) {
+		t.Errorf("Prefer = %q, want UTC times", first.prefer)
+	}
+}
+
+func TestGraphCreateEvent(t *testing.T) {
+	fake := newFakeGraph(t, func(w http.ResponseWriter, r *http.Request, body []byte) {
+		if emptyCalendarView(w, r) {
+			return
+		}
+		writeJSON(w, http.StatusCreated, `{"id": "new"}`)
+	})
+	backend := NewGraphBackend(fake.Client(), fake.URL+"/v1.0", "")
+
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := types.CalendarEvent{
+		ID:          "tx1",
+		Summary:     "Planning",
+		Description: "Q3 roadmap",
+		Location:    "HQ",
+		Start:       time.Date(2025, 8, 4, 15, 0, 0, 0, newYork),
+		End:         time.Date(2025, 8, 4, 16, 0, 0, 0, newYork),
+		Attendees:   []string{"bob@example.com", "Carol"},
+	}
+	if err := backend.CreateEvent(context.Background(), "cal/1", event); err != nil {
+		t.Fatalf("CreateEvent() error = %v", err)
+	}
+
+	request := fake.last(t, http.MethodPost)
+	if request.path != "/v1.0/me/calendars/cal%2F1/events" {
+		t.Errorf("path = %q", request.path)
+	}
+
+	var sent graphEvent
+	if err := json.Unmarshal(request.body, &sent); err != nil {
+		t.Fatalf("invalid request body %s: %v", request.body, err)
+	}
+	want := graphEvent{
+		TransactionID: "tx1",
+		Subject:       "Planning",
+		Body:          graphBody{ContentType: "text", Content: "Q3 roadmap"},
+		Start:         graphDateTime{DateTime: "2025-08-04T15:00:00", TimeZone: "America/New_York"},
+		End:           graphDateTime{DateTime: "2025-08-04T16:00:00", TimeZone: "America/New_York"},
+		Location:      graphLocation{DisplayName: "HQ"},
+		Attendees: []graphAttendee{
+			{EmailAddress: graphEmailAddress{Address: "bob@example.com"}, Type: "required"},
+		},
+	}
+	if !reflect.DeepEqual(sent, want) {
+		t.Errorf("sent %+v, want %+v", sent, want)
+	}
+}
+
+func TestGraphCreateEventRetry(t *testing.T) {
+	// The first attempt reaches Graph but fails; the retry finds the event already created
+	var creates int
+	fake := newFakeGraph(t, func(w http.ResponseWriter, r *http.Request, body []byte) {
+		if emptyCalendarView(w, r) {
+			return
+		}
+		creates++
+		if creates == 1 {
+			writeJSON(w, http.StatusServiceUnavailable, `{"error": {"code": "ServiceUnavailable", "message": "try again"}}`)
+			return
+		}
+		writeJSON(w, http.StatusConflict, `{"error": {"code": "ErrorDuplicateTransactionId", "message": "duplicate"}}`)
+	})
+	service := newGraphService(t, fake, "primary")
+
+	if err := service.CreateEvent(context.Background(), "Planning", "2025-08-04", "15:00", "", ""); err != nil {
+		t.Fatalf("CreateEvent() error = %v", err)
+	}
+	if got := fake.count(http.MethodPost); got != 2 {
+		t.Errorf("%d create requests, want 2", got)
+	}
+
+	var sent graphEvent
+	if err := json.Unmarshal(fake.last(t, http.MethodPost).body, &sent); err != nil {
+		t.Fatal(err)
+	}
+	if sent.TransactionID == "" {
+		t.Error("create sent no transaction ID")
+	}
+	if want := (graphDateTime{DateTime: "2025-08-04T15:00:00", TimeZone: "UTC"}); sent.Start != want {
+		t.Errorf("start = %+v, want %+v", sent.Start, want)
+	}
+}
+
+func TestGraphUpdateAndDeleteEvent(t *testing.T) {
+	fake := newFakeGraph(t, func(w http.ResponseWriter, r *http.Request, body []byte) {
+		if emptyCalendarView(w, r) {
+			return
+		}
+		switch r.Method {
+		case http.MethodPatch:
+			writeJSON(w, http.StatusOK, `{"id": "ev1"}`)
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+	service := newGraphService(t, fake, "primary").InCalendar("work")
+
+	if err := service.UpdateEvent(context.Background(), "ev1", "Moved", "2025-08-05", "10:30", "", "Room 2", 2*time.Hour); err != nil {
+		t.Fatalf("UpdateEvent() error = %v", err)
+	}
+	update := fake.last(t, http.MethodPatch)
+	if update.path != "/v1.0/users/ann@example.com/calendars/work/events/ev1" {
+		t.Errorf("update path = %q", update.path)
+	}
+
+	var sent map[string]json.RawMessage
+	if err := json.Unmarshal(update.body, &sent); err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"attendees", "body"} {
+		if _, ok := sent[field]; ok {
+			t.Errorf("update changed the %s it wasn't given", field)
+		}
+	}
+	if got := string(sent["start"]); got != `{"dateTime":"2025-08-05T10:30:00","timeZone":"UTC"}` {
+		t.Errorf("start = %s", got)
+	}
+	if got := string(sent["end"]); got != `{"dateTime":"2025-08-05T12:30:00","timeZone":"UTC"}` {
+		t.Errorf("end = %s, want the duration kept", got)
+	}
+
+	if err := service.DeleteEvent(context.Background(), "ev1"); err != nil {
+		t.Fatalf("DeleteEvent() error = %v", err)
+	}
+	if got := fake.last(t, http.MethodDelete).path; got != "/v1.0/users/ann@example.com/calendars/work/events/ev1" {
+		t.Errorf("delete path = %q", got)
+	}
+}
+
+func TestGraphError(t *testing.T) {
+	fake := newFakeGraph(t, func(w http.ResponseWriter, r *http.Request, body []byte) {
+		if emptyCalendarView(w, r) {
+			return
+		}
+		writeJSON(w, http.StatusNotFound, `{"error": {"code": "ErrorItemNotFound", "message": "The specified object was not found in the store."}}`)
+	})
+	service := newGraphService(t, fake, "primary")
+
+	err := service.DeleteEvent(context.Background(), "missing")
+	var graphErr *GraphError
+	if !errors.As(err, &graphErr) {
+		t.Fatalf("DeleteEvent() error = %v, want a GraphError", err)
+	}
+	if graphErr.StatusCode != http.StatusNotFound || graphErr.Code != "ErrorItemNotFound" {
+		t.Errorf("error = %+v", graphErr)
+	}
+	if got := fake.count(http.MethodDelete); got != 1 {
+		t.Errorf("%d delete requests, want 1: a missing event is not retried", got)
+	}
+}
+
+func TestGraphListCalendars(t *testing.T) {
+	var fake *fakeGraph
+	fake = newFakeGraph(t, func(w http.ResponseWriter, r *http.Request, body []byte) {
+		if emptyCalendarView(w, r) {
+			return
+		}
+		if r.URL.Query().Get("page") == "" {
+			writeJSON(w, http.StatusOK, `{
+				"value": [{"id": "holidays", "name": "Holidays", "hexColor": "#ff0000", "canEdit": false}],
+				"@odata.nextLink": "`+fake.URL+`/v1.0/users/ann@example.com/calendars?page=2"
+			}`)
+			return
+		}
+		writeJSON(w, http.StatusOK, `{
+			"value": [{"id": "main", "name": "Calendar", "hexColor": "#00ff00", "canEdit": true, "isDefaultCalendar": true}]
+		}`)
+	})
+	service := newGraphService(t, fake, "primary")
+
+	calendars, err := service.ListCalendars(context.Background())
+	if err != nil {
+		t.Fatalf("ListCalendars() error = %v", err)
+	}
+	want := []types.CalendarInfo{
+		{ID: "main", Name: "Calendar", Color: "#00ff00", Primary: true, Writable: true},
+		{ID: "holidays", Name: "Holidays", Color: "#ff0000"},
+	}
+	if !reflect.DeepEqual(calendars, want) {
+		t.Errorf("ListCalendars() = %+v, want %+v", calendars, want)
+	}
+}
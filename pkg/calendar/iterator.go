@@ -0,0 +1,158 @@
+package calendar
+
+import (
+	"context"
+	"time"
+
+	"calendar-assistant-bot/pkg/types"
+)
+
+// listPageSize is the number of events requested per page when listing events
+const listPageSize = 250
+
+// EventIterator walks through the events of a time range in start time order, merged across the
+// read calendars. Events of calendars in the event cache are read from it. Otherwise backends
+// return long lists in pages, which the iterator fetches as needed, each page as its own request.
+//
+//	it := service.Events(ctx, start, end, "")
+//	for it.Next() {
+//		event := it.Event()
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type EventIterator struct {
+	sources []*calendarPages
+	event   types.CalendarEvent
+	err     error
+}
+
+// calendarPages fetches the pages of events of one calendar
+type calendarPages struct {
+	service   *Service
+	ctx       context.Context
+	calendar  types.CalendarInfo
+	label     bool
+	startTime time.Time
+	endTime   time.Time
+	query     string
+
+	page      []types.CalendarEvent
+	pageToken string
+	fetched   bool
+}
+
+// Events returns an iterator over the events between startTime and endTime.
+// query is passed to the backend as a free text search and may be empty.
+func (s *Service) Events(ctx context.Context, startTime, endTime time.Time, query string) *EventIterator {
+	calendars := s.readCalendars()
+	it := &EventIterator{}
+	for _, info := range calendars {
+		it.sources = append(it.sources, &calendarPages{
+			service:   s,
+			ctx:       ctx,
+			calendar:  info,
+			label:     len(calendars) > 1,
+			startTime: startTime,
+			endTime:   endTime,
+			query:     query,
+		})
+	}
+	return it
+}
+
+// Next advances to the next event. It returns false when there are no more events or a request failed.
+func (it *EventIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	// Take the earliest of the next events of each calendar
+	var next *calendarPages
+	for _, source := range it.sources {
+		ok, err := source.fill()
+		if err != nil {
+			it.err = err
+			return false
+		}
+		if ok && (next == nil || source.page[0].Start.Before(next.page[0].Start)) {
+			next = source
+		}
+	}
+	if next == nil {
+		return false
+	}
+
+	it.event = next.page[0]
+	next.page = next.page[1:]
+	return true
+}
+
+// Event returns the current event
+func (it *EventIterator) Event() types.CalendarEvent {
+	return it.event
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *EventIterator) Err() error {
+	return it.err
+}
+
+// fill fetches pages until an event is buffered or the calendar has no more events,
+// and reports whether an event is buffered
+func (c *calendarPages) fill() (bool, error) {
+	for len(c.page) == 0 {
+		if c.fetched && c.pageToken == "" {
+			return false, nil
+		}
+		if err := c.fetchPage(); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// fetchPage requests the next page of events. Ranges the event cache covers are served from it
+// as a single page.
+func (c *calendarPages) fetchPage() error {
+	if !c.fetched {
+		if events, ok := c.service.cachedEvents(c.ctx, c.calendar.ID, c.startTime, c.endTime, c.query); ok {
+			c.fetched = true
+			c.add(events)
+			return nil
+		}
+	}
+
+	var events []types.CalendarEvent
+	var nextPageToken string
+	err := c.service.call(c.ctx, "list", func(ctx context.Context) error {
+		var err error
+		events, nextPageToken, err = c.service.backend.ListEvents(ctx, c.calendar.ID, EventsQuery{
+			Start:     c.startTime,
+			End:       c.endTime,
+			Text:      c.query,
+			PageToken: c.pageToken,
+			PageSize:  listPageSize,
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	c.fetched = true
+	c.pageToken = nextPageToken
+	c.add(events)
+	return nil
+}
+
+// add buffers events, with their calendar
+func (c *calendarPages) add(events []types.CalendarEvent) {
+	for _, calendarEvent := range events {
+		calendarEvent.CalendarID = c.calendar.ID
+		if c.label {
+			calendarEvent.Calendar = c.calendar.Name
+		}
+		c.page = append(c.page, calendarEvent)
+	}
+}
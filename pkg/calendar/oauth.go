@@ -0,0 +1,87 @@
+package calendar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"calendar-assistant-bot/pkg/resilience"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+)
+
+// OAuth connects users' own Google accounts with the OAuth2 authorization code flow.
+// Users open AuthCodeURL, Google redirects back to the redirect URL with a code, and Exchange turns
+// the code into a refresh token from which UserService builds calendar services acting as the user.
+type OAuth struct {
+	config   *oauth2.Config
+	upstream *resilience.Client
+}
+
+// NewOAuth creates the OAuth2 flow for a Google OAuth client. redirectURL must be registered
+// as an authorized redirect URI of the client.
+func NewOAuth(clientID, clientSecret, redirectURL string) *OAuth {
+	return &OAuth{
+		config: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+			Endpoint:     google.Endpoint,
+			Scopes:       []string{calendar.CalendarScope},
+		},
+		upstream: resilience.NewClient("calendar", resilience.DefaultPolicy,
+			resilience.NewBreaker("calendar_users", 5, 30*time.Second), classifyError),
+	}
+}
+
+// AuthCodeURL returns the consent page URL for a login; state comes back with the redirect.
+// Consent is always asked for, since Google only returns a refresh token on consent.
+func (o *OAuth) AuthCodeURL(state string) string {
+	return o.config.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce)
+}
+
+// Exchange turns the code of a redirect into a refresh token
+func (o *OAuth) Exchange(ctx context.Context, code string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	token, err := o.config.Exchange(ctx, code)
+	if err != nil {
+		return "", fmt.Errorf("failed to exchange authorization code: %w", err)
+	}
+	if token.RefreshToken == "" {
+		return "", fmt.Errorf("google returned no refresh token")
+	}
+	return token.RefreshToken, nil
+}
+
+// UserService returns a service acting as the user the refresh token belongs to, reading from
+// and writing to their primary calendar. Access tokens are refreshed as they expire.
+// All user services share one client, so retries and the circuit breaker apply across users.
+func (o *OAuth) UserService(refreshToken string) (*Service, error) {
+	// The token source outlives any request, so it refreshes with a background context
+	ctx := context.Background()
+	tokens := o.config.TokenSource(ctx, &oauth2.Token{RefreshToken: refreshToken})
+	service, err := calendar.NewService(ctx, option.WithTokenSource(tokens))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create calendar client: %w", err)
+	}
+
+	return &Service{
+		backend:    NewGoogleBackend(service),
+		calendarID: "primary",
+		upstream:   o.upstream,
+		cache:      newEventCache(),
+	}, nil
+}
+
+// IsAuthError reports whether err means Google refused a user's refresh token, because the user
+// revoked access or the token expired. The user has to log in again.
+func IsAuthError(err error) bool {
+	var tokenErr *oauth2.RetrieveError
+	return errors.As(err, &tokenErr) && tokenErr.ErrorCode == "invalid_grant"
+}
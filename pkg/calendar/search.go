@@ -0,0 +1,158 @@
+package calendar
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"calendar-assistant-bot/pkg/dateparse"
+	"calendar-assistant-bot/pkg/types"
+)
+
+const (
+	// searchPageSize is the default number of events in a page of search results
+	searchPageSize = 10
+	// defaultSearchDays is how far ahead a search without an end date looks
+	defaultSearchDays = 180
+)
+
+// SearchQuery describes an event search. Text matches the title, description, location or
+// attendees; Attendee and Location only match those fields. All matches are case-insensitive
+// substrings. StartDate and EndDate (inclusive) take any date dateparse.Date accepts, such as
+// YYYY-MM-DD, and default to today and 180 days later.
+type SearchQuery struct {
+	Text      string
+	Attendee  string
+	Location  string
+	StartDate string
+	EndDate   string
+	// Page is 1-based; 0 is the first page
+	Page int
+	// PageSize is the number of events per page; 0 means 10
+	PageSize int
+}
+
+// SearchResult is a page of search results
+type SearchResult struct {
+	Events []types.CalendarEvent
+	// Total is the number of matching events across all pages
+	Total      int
+	Page       int
+	TotalPages int
+}
+
+// SearchEvents finds the events matching query, sorted by start time and paged.
+// Google's free text search only matches whole words, so if it finds nothing the events of the
+// range are filtered locally instead, which also finds "hair" in "Haircut".
+func (s *Service) SearchEvents(ctx context.Context, query SearchQuery) (*SearchResult, error) {
+	if query.Text == "" && query.Attendee == "" && query.Location == "" {
+		return nil, fmt.Errorf("search needs a keyword, attendee or location")
+	}
+
+	startTime, endTime, err := searchRange(query.StartDate, query.EndDate)
+	if err != nil {
+		return nil, err
+	}
+
+	var terms []string
+	for _, term := range []string{query.Text, query.Attendee, query.Location} {
+		if term != "" {
+			terms = append(terms, term)
+		}
+	}
+
+	events, err := s.listEvents(ctx, startTime, endTime, strings.Join(terms, " "))
+	if err != nil {
+		return nil, err
+	}
+	matches := filterEvents(events, query)
+
+	if len(matches) == 0 {
+		if events, err = s.listEvents(ctx, startTime, endTime, ""); err != nil {
+			return nil, err
+		}
+		matches = filterEvents(events, query)
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Start.Before(matches[j].Start)
+	})
+
+	return pageResults(matches, query.Page, query.PageSize), nil
+}
+
+// searchRange parses the date range of a search
+func searchRange(startDate, endDate string) (time.Time, time.Time, error) {
+	now := time.Now().UTC()
+	startTime := now.Truncate(24 * time.Hour)
+	if startDate != "" {
+		var err error
+		if startTime, err = dateparse.Date(startDate, now); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid start date format: %v", err)
+		}
+	}
+
+	endTime := startTime.AddDate(0, 0, defaultSearchDays)
+	if endDate != "" {
+		end, err := dateparse.Date(endDate, now)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid end date format: %v", err)
+		}
+		// Include the full end date
+		endTime = end.AddDate(0, 0, 1)
+	}
+
+	if !endTime.After(startTime) {
+		return time.Time{}, time.Time{}, fmt.Errorf("end date is before start date")
+	}
+	return startTime, endTime, nil
+}
+
+// filterEvents returns the events that match every field set in query
+func filterEvents(events []types.CalendarEvent, query SearchQuery) []types.CalendarEvent {
+	var matches []types.CalendarEvent
+	for _, event := range events {
+		attendees := strings.Join(event.Attendees, "\n")
+		text := strings.Join([]string{event.Summary, event.Description, event.Location, attendees}, "\n")
+
+		if containsFold(text, query.Text) &&
+			containsFold(attendees, query.Attendee) &&
+			containsFold(event.Location, query.Location) {
+			matches = append(matches, event)
+		}
+	}
+	return matches
+}
+
+// containsFold reports whether substr is within s, ignoring case. An empty substr always matches.
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(strings.TrimSpace(substr)))
+}
+
+// pageResults returns one page of sorted matches; pages past the end are empty
+func pageResults(matches []types.CalendarEvent, page, pageSize int) *SearchResult {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = searchPageSize
+	}
+
+	result := &SearchResult{
+		Total:      len(matches),
+		Page:       page,
+		TotalPages: (len(matches) + pageSize - 1) / pageSize,
+	}
+
+	start := (page - 1) * pageSize
+	if start < len(matches) {
+		end := start + pageSize
+		if end > len(matches) {
+			end = len(matches)
+		}
+		result.Events = matches[start:end]
+	}
+	return result
+}
@@ -0,0 +1,466 @@
+package calendar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"calendar-assistant-bot/pkg/types"
+)
+
+const (
+	// syncWindow is how far back a full sync of a calendar reaches. Changes to events that ended
+	// before it are never reported, and older reads go to the backend.
+	syncWindow = 30 * 24 * time.Hour
+	// syncHorizon is how far ahead a full sync reaches; later reads go to the backend
+	syncHorizon = 365 * 24 * time.Hour
+	// cacheTTL is how long cached events are served before a read syncs the calendar again
+	cacheTTL = time.Minute
+	// fullSyncInterval is how often the cache window is moved forward with a full sync
+	fullSyncInterval = 24 * time.Hour
+	// fullSyncTimeout bounds a full sync, which runs in the background
+	fullSyncTimeout = 5 * time.Minute
+)
+
+// syncPageSize is the page size of sync requests
+const syncPageSize = 250
+
+// ErrSyncUnsupported is returned when syncing a calendar whose backend can't report changes
+var ErrSyncUnsupported = errors.New("the calendar backend does not support change notifications")
+
+// Kinds of EventChange
+const (
+	ChangeNew       = "new"
+	ChangeMoved     = "moved"
+	ChangeCancelled = "cancelled"
+)
+
+// EventChange is a change to an upcoming event that a sync found and the bot didn't make
+type EventChange struct {
+	Kind  string
+	Event types.CalendarEvent
+	// Previous is the event before it was moved
+	Previous types.CalendarEvent
+}
+
+// eventCache holds the events of the calendars of a service, kept up to date by incremental sync.
+// Views of a service share it, so writes through any view reach it.
+type eventCache struct {
+	mutex     sync.Mutex
+	calendars map[string]*calendarCache
+	// syncing serializes the syncs of each calendar
+	syncing map[string]*sync.Mutex
+	// filling are the calendars being synced in full in the background
+	filling map[string]bool
+	// watched are the calendars whose changes are kept for the watcher
+	watched map[string]bool
+}
+
+// calendarCache is the synced state of one calendar
+type calendarCache struct {
+	events    map[string]types.CalendarEvent
+	syncToken string
+	syncedAt  time.Time
+	// from and until bound the events of the last full sync, at fullSyncAt
+	from       time.Time
+	until      time.Time
+	fullSyncAt time.Time
+	// changes found since the watcher last took them
+	changes []EventChange
+}
+
+func newEventCache() *eventCache {
+	return &eventCache{
+		calendars: make(map[string]*calendarCache),
+		syncing:   make(map[string]*sync.Mutex),
+		filling:   make(map[string]bool),
+		watched:   make(map[string]bool),
+	}
+}
+
+// put writes through an event the bot created or updated
+func (c *eventCache) put(calendarID string, event types.CalendarEvent) {
+	if c == nil || event.ID == "" {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if cached, ok := c.calendars[calendarID]; ok {
+		cached.events[event.ID] = event
+	}
+}
+
+// remove writes through an event the bot deleted
+func (c *eventCache) remove(calendarID, eventID string) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if cached, ok := c.calendars[calendarID]; ok {
+		delete(cached.events, eventID)
+	}
+}
+
+// lock takes the sync lock of a calendar and returns its unlock function
+func (c *eventCache) lock(calendarID string) func() {
+	calendarLock := c.syncLock(calendarID)
+	calendarLock.Lock()
+	return calendarLock.Unlock
+}
+
+// tryLock takes the sync lock of a calendar if no sync holds it, and returns its unlock function
+func (c *eventCache) tryLock(calendarID string) (func(), bool) {
+	calendarLock := c.syncLock(calendarID)
+	if !calendarLock.TryLock() {
+		return nil, false
+	}
+	return calendarLock.Unlock, true
+}
+
+// syncLock returns the sync lock of a calendar
+func (c *eventCache) syncLock(calendarID string) *sync.Mutex {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	calendarLock, ok := c.syncing[calendarID]
+	if !ok {
+		calendarLock = &sync.Mutex{}
+		c.syncing[calendarID] = calendarLock
+	}
+	return calendarLock
+}
+
+// startFill marks a calendar as being synced in full in the background. It returns false if
+// it already is.
+func (c *eventCache) startFill(calendarID string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.filling[calendarID] {
+		return false
+	}
+	c.filling[calendarID] = true
+	return true
+}
+
+// endFill clears the mark of startFill
+func (c *eventCache) endFill(calendarID string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.filling, calendarID)
+}
+
+// state returns the sync token of a calendar, and whether the cache of it is still fresh and
+// when its last full sync was. The token is empty if the calendar wasn't synced yet.
+func (c *eventCache) state(calendarID string) (string, bool, time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	cached, ok := c.calendars[calendarID]
+	if !ok {
+		return "", false, time.Time{}
+	}
+	return cached.syncToken, time.Since(cached.syncedAt) < cacheTTL, cached.fullSyncAt
+}
+
+// setWatched starts or stops keeping the changes of a calendar for the watcher
+func (c *eventCache) setWatched(calendarID string, watched bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if watched {
+		c.watched[calendarID] = true
+		return
+	}
+	delete(c.watched, calendarID)
+	if cached, ok := c.calendars[calendarID]; ok {
+		cached.changes = nil
+	}
+}
+
+// takeChanges returns and clears the changes found in a calendar
+func (c *eventCache) takeChanges(calendarID string) []EventChange {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	cached, ok := c.calendars[calendarID]
+	if !ok {
+		return nil
+	}
+	changes := cached.changes
+	cached.changes = nil
+	return changes
+}
+
+// replace stores the result of a full sync of the events between from and until
+func (c *eventCache) replace(calendarID string, events []types.CalendarEvent, syncToken string, from, until time.Time) {
+	now := time.Now()
+	cached := &calendarCache{
+		events:     make(map[string]types.CalendarEvent, len(events)),
+		syncToken:  syncToken,
+		syncedAt:   now,
+		from:       from,
+		until:      until,
+		fullSyncAt: now,
+	}
+	for _, event := range events {
+		if !event.Cancelled {
+			cached.events[event.ID] = event
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	// Changes found before the full sync are still to be reported
+	if previous, ok := c.calendars[calendarID]; ok {
+		cached.changes = previous.changes
+	}
+	c.calendars[calendarID] = cached
+}
+
+// apply stores the result of an incremental sync. Changes to upcoming events are kept for the
+// watcher if the calendar is watched.
+func (c *eventCache) apply(calendarID string, events []types.CalendarEvent, syncToken string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	cached, ok := c.calendars[calendarID]
+	if !ok {
+		return
+	}
+	cached.syncToken = syncToken
+	cached.syncedAt = time.Now()
+
+	now := time.Now()
+	var changes []EventChange
+	for _, event := range events {
+		previous, known := cached.events[event.ID]
+		switch {
+		case event.Cancelled:
+			// Events the bot deleted are gone from the cache already
+			delete(cached.events, event.ID)
+			if known && previous.End.After(now) {
+				changes = append(changes, EventChange{Kind: ChangeCancelled, Event: previous})
+			}
+			continue
+		case !known:
+			if event.End.After(now) {
+				changes = append(changes, EventChange{Kind: ChangeNew, Event: event})
+			}
+		case !event.Start.Equal(previous.Start) || !event.End.Equal(previous.End):
+			if event.End.After(now) || previous.End.After(now) {
+				changes = append(changes, EventChange{Kind: ChangeMoved, Event: event, Previous: previous})
+			}
+		}
+		cached.events[event.ID] = event
+	}
+
+	if c.watched[calendarID] {
+		cached.changes = append(cached.changes, changes...)
+	}
+}
+
+// events returns the cached events of a calendar overlapping startTime to endTime whose text
+// contains every word of query, in start time order. It returns false if the range is outside
+// the cache window.
+func (c *eventCache) events(calendarID string, startTime, endTime time.Time, query string) ([]types.CalendarEvent, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	cached, ok := c.calendars[calendarID]
+	if !ok || startTime.Before(cached.from) || endTime.After(cached.until) {
+		return nil, false
+	}
+
+	words := strings.Fields(query)
+	var events []types.CalendarEvent
+	for _, event := range cached.events {
+		if !event.End.After(startTime) || !event.Start.Before(endTime) {
+			continue
+		}
+		text := strings.Join(append([]string{event.Summary, event.Description, event.Location}, event.Attendees...), "\n")
+		matches := true
+		for _, word := range words {
+			if !containsFold(text, word) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			events = append(events, event)
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Start.Equal(events[j].Start) {
+			return events[i].ID < events[j].ID
+		}
+		return events[i].Start.Before(events[j].Start)
+	})
+	return events, true
+}
+
+// cachedEvents returns the events of a calendar from the cache, applying the changes since the
+// last sync first if the cache is older than cacheTTL. A full sync reads a year of events, so it
+// never runs in a read: the first read of a calendar, and the first once the cache window is
+// fullSyncInterval old, start one in the background. It returns false if the backend can't sync,
+// the calendar isn't synced yet, another sync of it is running, the range is outside the cache
+// window or the sync failed; the events are then read from the backend.
+func (s *Service) cachedEvents(ctx context.Context, calendarID string, startTime, endTime time.Time, query string) ([]types.CalendarEvent, bool) {
+	if _, ok := s.backend.(SyncBackend); !ok || s.cache == nil {
+		return nil, false
+	}
+
+	syncToken, fresh, fullSyncAt := s.cache.state(calendarID)
+	if syncToken == "" || time.Since(fullSyncAt) >= fullSyncInterval {
+		s.fill(calendarID)
+		if syncToken == "" {
+			return nil, false
+		}
+	}
+	if !fresh {
+		if err := s.syncChanges(ctx, calendarID); err != nil {
+			log.Printf("Failed to sync calendar %s, reading from the backend: %v", calendarID, err)
+			return nil, false
+		}
+	}
+	return s.cache.events(calendarID, startTime, endTime, query)
+}
+
+// errSyncBusy is returned by syncChanges while another sync of the calendar is running
+var errSyncBusy = errors.New("another sync of the calendar is running")
+
+// syncChanges applies the changes of a calendar since its last sync, unless another request did
+// meanwhile. Reads don't wait for a sync that is running, which may be a full one; they fail with
+// errSyncBusy instead. An expired sync token starts a full sync in the background.
+func (s *Service) syncChanges(ctx context.Context, calendarID string) error {
+	backend, ok := s.backend.(SyncBackend)
+	if !ok {
+		return ErrSyncUnsupported
+	}
+
+	unlock, ok := s.cache.tryLock(calendarID)
+	if !ok {
+		return errSyncBusy
+	}
+	defer unlock()
+
+	syncToken, fresh, _ := s.cache.state(calendarID)
+	if fresh {
+		return nil
+	}
+
+	events, nextSyncToken, err := s.fetchChanges(ctx, backend, calendarID, SyncQuery{SyncToken: syncToken})
+	if err != nil {
+		if errors.Is(err, ErrSyncExpired) {
+			s.fill(calendarID)
+		}
+		return fmt.Errorf("failed to sync events: %w", err)
+	}
+	s.cache.apply(calendarID, events, nextSyncToken)
+	return nil
+}
+
+// fill syncs a calendar in the background, in full if it is due, unless that is running already
+func (s *Service) fill(calendarID string) {
+	if !s.cache.startFill(calendarID) {
+		return
+	}
+
+	go func() {
+		defer s.cache.endFill(calendarID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), fullSyncTimeout)
+		defer cancel()
+		if err := s.refresh(ctx, calendarID); err != nil {
+			log.Printf("Failed to sync calendar %s in the background: %v", calendarID, err)
+		}
+	}()
+}
+
+// syncEvents syncs a watched calendar and returns what changed since the watcher last asked.
+// The first sync of a calendar only fills the cache and reports nothing, as does the full sync
+// after an expired sync token.
+func (s *Service) syncEvents(ctx context.Context, calendarID string) ([]EventChange, error) {
+	if _, ok := s.backend.(SyncBackend); !ok {
+		return nil, ErrSyncUnsupported
+	}
+
+	s.cache.setWatched(calendarID, true)
+	if err := s.refresh(ctx, calendarID); err != nil {
+		return nil, err
+	}
+	return s.cache.takeChanges(calendarID), nil
+}
+
+// unwatch stops keeping the changes of a calendar
+func (s *Service) unwatch(calendarID string) {
+	s.cache.setWatched(calendarID, false)
+}
+
+// refresh brings the cached events of a calendar up to date: in full on the first sync, once
+// the cache window is fullSyncInterval old, or when the sync token expired, and incrementally
+// otherwise
+func (s *Service) refresh(ctx context.Context, calendarID string) error {
+	backend, ok := s.backend.(SyncBackend)
+	if !ok {
+		return ErrSyncUnsupported
+	}
+
+	unlock := s.cache.lock(calendarID)
+	defer unlock()
+
+	syncToken, _, fullSyncAt := s.cache.state(calendarID)
+
+	if syncToken != "" && time.Since(fullSyncAt) < fullSyncInterval {
+		events, nextSyncToken, err := s.fetchChanges(ctx, backend, calendarID, SyncQuery{SyncToken: syncToken})
+		if err == nil {
+			s.cache.apply(calendarID, events, nextSyncToken)
+			return nil
+		}
+		if !errors.Is(err, ErrSyncExpired) {
+			return fmt.Errorf("failed to sync events: %w", err)
+		}
+		log.Printf("Sync token of calendar %s expired, syncing it in full", calendarID)
+	} else if syncToken != "" {
+		// Report what changed up to now before the window moves
+		if events, nextSyncToken, err := s.fetchChanges(ctx, backend, calendarID, SyncQuery{SyncToken: syncToken}); err == nil {
+			s.cache.apply(calendarID, events, nextSyncToken)
+		}
+	}
+
+	now := time.Now()
+	from, until := now.Add(-syncWindow), now.Add(syncHorizon)
+	events, nextSyncToken, err := s.fetchChanges(ctx, backend, calendarID, SyncQuery{Start: from, End: until})
+	if err != nil {
+		return fmt.Errorf("failed to sync events: %w", err)
+	}
+	s.cache.replace(calendarID, events, nextSyncToken, from, until)
+	return nil
+}
+
+// fetchChanges reads all pages of the changed events of a query and returns them with the next
+// sync token
+func (s *Service) fetchChanges(ctx context.Context, backend SyncBackend, calendarID string, query SyncQuery) ([]types.CalendarEvent, string, error) {
+	query.PageSize = syncPageSize
+
+	var events []types.CalendarEvent
+	for {
+		var page *SyncPage
+		err := s.call(ctx, "sync", func(ctx context.Context) error {
+			var err error
+			page, err = backend.SyncEvents(ctx, calendarID, query)
+			return err
+		})
+		if err != nil {
+			return nil, "", err
+		}
+
+		events = append(events, page.Events...)
+		if page.NextPageToken == "" {
+			return events, page.NextSyncToken, nil
+		}
+		query.PageToken = page.NextPageToken
+	}
+}
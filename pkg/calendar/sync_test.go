@@ -0,0 +1,356 @@
+package calendar
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"calendar-assistant-bot/pkg/types"
+
+	calendarapi "google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+)
+
+// fakeGoogle is a local stand-in for the Google Calendar events endpoint. A request with a sync
+// token gets the changes, one with orderBy is a list, and any other one is a full sync.
+type fakeGoogle struct {
+	*httptest.Server
+	mutex sync.Mutex
+	// items are the events listed and fully synced, changes those of an incremental sync
+	items   []string
+	changes []string
+	// expired answers incremental syncs with 410 Gone
+	expired  bool
+	requests []string
+}
+
+func newFakeGoogle(t *testing.T, items ...string) *fakeGoogle {
+	fake := &fakeGoogle{items: items}
+	fake.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fake.mutex.Lock()
+		defer fake.mutex.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		query := r.URL.Query()
+		switch {
+		case query.Get("syncToken") != "":
+			fake.requests = append(fake.requests, "sync")
+			if fake.expired {
+				writeJSON(w, http.StatusGone, `{"error": {"code": 410, "message": "Sync token is no longer valid"}}`)
+				return
+			}
+			writeJSON(w, http.StatusOK, `{"items": [`+strings.Join(fake.changes, ",")+`], "nextSyncToken": "token-2"}`)
+		case query.Get("orderBy") != "":
+			fake.requests = append(fake.requests, "list")
+			writeJSON(w, http.StatusOK, `{"items": [`+strings.Join(fake.items, ",")+`]}`)
+		default:
+			fake.requests = append(fake.requests, "full")
+			writeJSON(w, http.StatusOK, `{"items": [`+strings.Join(fake.items, ",")+`], "nextSyncToken": "token-1"}`)
+		}
+	}))
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+// count returns the number of requests of a kind
+func (f *fakeGoogle) count(kind string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	n := 0
+	for _, request := range f.requests {
+		if request == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// set changes the fake's answers
+func (f *fakeGoogle) set(update func(f *fakeGoogle)) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	update(f)
+}
+
+func newGoogleService(t *testing.T, fake *fakeGoogle, calendarID string) *Service {
+	t.Helper()
+	api, err := calendarapi.NewService(context.Background(),
+		option.WithEndpoint(fake.URL+"/"), option.WithHTTPClient(fake.Client()))
+	if err != nil {
+		t.Fatalf("calendar.NewService() error = %v", err)
+	}
+	return NewService(context.Background(), NewGoogleBackend(api), calendarID)
+}
+
+// googleEventJSON renders an event as the Google API returns it
+func googleEventJSON(id, summary string, start time.Time, status string) string {
+	return fmt.Sprintf(`{"id": %q, "summary": %q, "status": %q, "start": {"dateTime": %q}, "end": {"dateTime": %q}}`,
+		id, summary, status, start.Format(time.RFC3339), start.Add(time.Hour).Format(time.RFC3339))
+}
+
+// waitFilled waits for the background sync of a calendar to finish
+func waitFilled(t *testing.T, s *Service, calendarID string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s.cache.mutex.Lock()
+		filling := s.cache.filling[calendarID]
+		s.cache.mutex.Unlock()
+		if syncToken, _, _ := s.cache.state(calendarID); syncToken != "" && !filling {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("calendar %s was not synced in the background", calendarID)
+}
+
+// age moves the last sync and full sync of a cached calendar back in time
+func age(s *Service, calendarID string, synced, fullSynced time.Duration) {
+	s.cache.mutex.Lock()
+	defer s.cache.mutex.Unlock()
+	cached := s.cache.calendars[calendarID]
+	cached.syncedAt = cached.syncedAt.Add(-synced)
+	cached.fullSyncAt = cached.fullSyncAt.Add(-fullSynced)
+}
+
+func eventIDs(events []types.CalendarEvent) []string {
+	ids := []string{}
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	return ids
+}
+
+func TestServiceSync(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Hour)
+	fake := newFakeGoogle(t,
+		googleEventJSON("ev1", "Standup", now.Add(time.Hour), "confirmed"),
+		googleEventJSON("ev2", "Review", now.Add(3*time.Hour), "confirmed"),
+	)
+	service := newGoogleService(t, fake, "primary")
+	waitFilled(t, service, "primary")
+	from, until := now, now.Add(24*time.Hour)
+
+	// The startup check reads one event; the full sync runs in the background
+	if fake.count("list") != 1 || fake.count("full") != 1 {
+		t.Fatalf("requests at startup = %v, want one list and one full sync", fake.requests)
+	}
+
+	t.Run("fresh cache is read without requests", func(t *testing.T) {
+		events, ok := service.cachedEvents(context.Background(), "primary", from, until, "")
+		if !ok {
+			t.Fatal("cachedEvents() missed the cache")
+		}
+		if got, want := eventIDs(events), []string{"ev1", "ev2"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("events = %v, want %v", got, want)
+		}
+		if fake.count("sync") != 0 {
+			t.Errorf("requests = %v, want no sync", fake.requests)
+		}
+	})
+
+	t.Run("stale cache applies the changes first", func(t *testing.T) {
+		fake.set(func(f *fakeGoogle) {
+			f.changes = []string{
+				googleEventJSON("ev2", "Review", now.Add(3*time.Hour), "cancelled"),
+				googleEventJSON("ev3", "Lunch", now.Add(2*time.Hour), "confirmed"),
+			}
+		})
+		age(service, "primary", cacheTTL, 0)
+
+		events, ok := service.cachedEvents(context.Background(), "primary", from, until, "")
+		if !ok {
+			t.Fatal("cachedEvents() missed the cache")
+		}
+		if got, want := eventIDs(events), []string{"ev1", "ev3"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("events = %v, want %v", got, want)
+		}
+		if fake.count("sync") != 1 || fake.count("full") != 1 {
+			t.Errorf("requests = %v, want one incremental sync", fake.requests)
+		}
+	})
+
+	t.Run("old window is synced in full in the background", func(t *testing.T) {
+		fake.set(func(f *fakeGoogle) { f.changes = nil })
+		age(service, "primary", cacheTTL, fullSyncInterval)
+
+		if _, ok := service.cachedEvents(context.Background(), "primary", from, until, ""); !ok {
+			t.Fatal("cachedEvents() missed the cache while the full sync runs")
+		}
+		waitFilled(t, service, "primary")
+		if fake.count("full") != 2 {
+			t.Errorf("requests = %v, want a second full sync", fake.requests)
+		}
+	})
+
+	t.Run("read doesn't wait for a running sync", func(t *testing.T) {
+		age(service, "primary", cacheTTL, 0)
+		unlock := service.cache.lock("primary")
+		_, ok := service.cachedEvents(context.Background(), "primary", from, until, "")
+		unlock()
+		if ok {
+			t.Error("cachedEvents() served a stale cache while a sync runs")
+		}
+	})
+
+	t.Run("expired sync token reads from the backend and syncs in full", func(t *testing.T) {
+		fake.set(func(f *fakeGoogle) { f.expired = true })
+		full := fake.count("full")
+		age(service, "primary", cacheTTL, 0)
+
+		if _, ok := service.cachedEvents(context.Background(), "primary", from, until, ""); ok {
+			t.Error("cachedEvents() served the cache after the sync token expired")
+		}
+		waitFilled(t, service, "primary")
+		if fake.count("full") != full+1 {
+			t.Errorf("requests = %v, want another full sync", fake.requests)
+		}
+	})
+
+	t.Run("first read of a calendar goes to the backend", func(t *testing.T) {
+		if _, ok := service.cachedEvents(context.Background(), "team", from, until, ""); ok {
+			t.Error("cachedEvents() served a calendar that wasn't synced")
+		}
+		waitFilled(t, service, "team")
+		if _, ok := service.cachedEvents(context.Background(), "team", from, until, ""); !ok {
+			t.Error("cachedEvents() missed the cache after the background sync")
+		}
+	})
+}
+
+func TestEventCacheEvents(t *testing.T) {
+	day := time.Date(2025, 8, 4, 0, 0, 0, 0, time.UTC)
+	cache := newEventCache()
+	cache.replace("primary", []types.CalendarEvent{
+		{ID: "b", Summary: "Standup", Start: day.Add(9 * time.Hour), End: day.Add(9*time.Hour + 15*time.Minute)},
+		{ID: "a", Summary: "Design review", Description: "Q3 roadmap", Start: day.Add(9 * time.Hour), End: day.Add(10 * time.Hour)},
+		{ID: "c", Summary: "Lunch", Location: "Cafe Berlin", Attendees: []string{"Bob Smith"}, Start: day.Add(12 * time.Hour), End: day.Add(13 * time.Hour)},
+		{ID: "d", Summary: "Offsite", Start: day.Add(-24 * time.Hour), End: day.Add(48 * time.Hour)},
+		{ID: "e", Summary: "Cancelled", Start: day.Add(11 * time.Hour), End: day.Add(12 * time.Hour), Cancelled: true},
+	}, "token", day.Add(-7*24*time.Hour), day.Add(7*24*time.Hour))
+
+	tests := []struct {
+		name   string
+		start  time.Time
+		end    time.Time
+		query  string
+		want   []string
+		wantOK bool
+	}{
+		{"whole day in start order", day, day.Add(24 * time.Hour), "", []string{"d", "a", "b", "c"}, true},
+		{"overlapping events only", day.Add(9*time.Hour + 30*time.Minute), day.Add(12 * time.Hour), "", []string{"d", "a"}, true},
+		{"end is exclusive", day.Add(13 * time.Hour), day.Add(14 * time.Hour), "", []string{"d"}, true},
+		{"query matches description", day, day.Add(24 * time.Hour), "roadmap", []string{"a"}, true},
+		{"query ignores case", day, day.Add(24 * time.Hour), "STANDUP", []string{"b"}, true},
+		{"every word must match", day, day.Add(24 * time.Hour), "lunch bob", []string{"c"}, true},
+		{"location and attendees match", day, day.Add(24 * time.Hour), "berlin smith", []string{"c"}, true},
+		{"no match", day, day.Add(24 * time.Hour), "dentist", []string{}, true},
+		{"before the window", day.Add(-8 * 24 * time.Hour), day, "", nil, false},
+		{"after the window", day, day.Add(8 * 24 * time.Hour), "", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, ok := cache.events("primary", tt.start, tt.end, tt.query)
+			if ok != tt.wantOK {
+				t.Fatalf("events() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if got := eventIDs(events); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("events() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, ok := cache.events("team", day, day.Add(time.Hour), ""); ok {
+		t.Error("events() of a calendar that wasn't synced returned true")
+	}
+}
+
+func TestEventCacheApply(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Minute)
+	past := now.Add(-48 * time.Hour)
+	initial := []types.CalendarEvent{
+		{ID: "move", Summary: "Standup", Start: now.Add(time.Hour), End: now.Add(2 * time.Hour)},
+		{ID: "cancel", Summary: "Review", Start: now.Add(3 * time.Hour), End: now.Add(4 * time.Hour)},
+		{ID: "rename", Summary: "Lunch", Start: now.Add(5 * time.Hour), End: now.Add(6 * time.Hour)},
+		{ID: "old", Summary: "Retro", Start: past, End: past.Add(time.Hour)},
+	}
+	changed := []types.CalendarEvent{
+		{ID: "move", Summary: "Standup", Start: now.Add(2 * time.Hour), End: now.Add(3 * time.Hour)},
+		{ID: "cancel", Cancelled: true},
+		{ID: "rename", Summary: "Team lunch", Start: now.Add(5 * time.Hour), End: now.Add(6 * time.Hour)},
+		{ID: "old", Cancelled: true},
+		{ID: "new", Summary: "Dentist", Start: now.Add(24 * time.Hour), End: now.Add(25 * time.Hour)},
+		{ID: "new-past", Summary: "Forgotten", Start: past, End: past.Add(time.Hour)},
+		{ID: "gone", Cancelled: true},
+	}
+
+	tests := []struct {
+		name    string
+		watched bool
+		want    []string
+	}{
+		{"watched", true, []string{"moved move", "cancelled cancel", "new new"}},
+		{"not watched", false, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := newEventCache()
+			cache.setWatched("primary", tt.watched)
+			cache.replace("primary", initial, "token-1", now.Add(-syncWindow), now.Add(syncHorizon))
+			cache.apply("primary", changed, "token-2")
+
+			var got []string
+			for _, change := range cache.takeChanges("primary") {
+				got = append(got, change.Kind+" "+change.Event.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("changes = %v, want %v", got, tt.want)
+			}
+			if changes := cache.takeChanges("primary"); len(changes) != 0 {
+				t.Errorf("changes after takeChanges() = %v, want none", changes)
+			}
+
+			events, _ := cache.events("primary", past, now.Add(48*time.Hour), "")
+			if got, want := eventIDs(events), []string{"new-past", "move", "rename", "new"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("events = %v, want %v", got, want)
+			}
+			if syncToken, fresh, _ := cache.state("primary"); syncToken != "token-2" || !fresh {
+				t.Errorf("state() = %q, %v, want token-2, fresh", syncToken, fresh)
+			}
+		})
+	}
+
+	t.Run("full sync keeps changes not yet taken", func(t *testing.T) {
+		cache := newEventCache()
+		cache.setWatched("primary", true)
+		cache.replace("primary", initial, "token-1", now.Add(-syncWindow), now.Add(syncHorizon))
+		cache.apply("primary", changed[:1], "token-2")
+		cache.replace("primary", changed, "token-3", now.Add(-syncWindow), now.Add(syncHorizon))
+
+		if changes := cache.takeChanges("primary"); len(changes) != 1 || changes[0].Kind != ChangeMoved {
+			t.Errorf("changes = %+v, want the move", changes)
+		}
+		if events, _ := cache.events("primary", past, now.Add(48*time.Hour), ""); len(events) != 4 {
+			t.Errorf("events after full sync = %v, want the 4 not cancelled", eventIDs(events))
+		}
+	})
+
+	t.Run("calendar that wasn't synced is ignored", func(t *testing.T) {
+		cache := newEventCache()
+		cache.apply("primary", changed, "token-2")
+		if syncToken, _, _ := cache.state("primary"); syncToken != "" {
+			t.Errorf("sync token = %q, want none", syncToken)
+		}
+	})
+}
@@ -0,0 +1,305 @@
+package calendar
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// syncTimeout bounds a sync started by a notification or by Run
+const syncTimeout = time.Minute
+
+// NotifyFunc receives the changes found in a watched calendar, identified by the key it was
+// watched under
+type NotifyFunc func(key string, changes []EventChange)
+
+// Watcher keeps push notification channels open for calendars and syncs a calendar whenever its
+// channel reports a change. Each calendar is watched under a key chosen by the caller, since
+// calendar IDs such as "primary" are only unique per account.
+type Watcher struct {
+	address string
+	token   string
+	notify  NotifyFunc
+
+	mutex   sync.Mutex
+	watches map[string]*watch // by key
+	stopped bool
+	syncs   sync.WaitGroup
+}
+
+// watch is a watched calendar and its channel
+type watch struct {
+	key        string
+	service    *Service
+	calendarID string
+	channel    Channel
+	// syncing is set while a sync runs, pending when another notification arrived meanwhile
+	syncing bool
+	pending bool
+}
+
+// NewWatcher creates a watcher whose channels post to address, the public HTTPS URL its Handler
+// is served on. token authenticates the notifications.
+func NewWatcher(address, token string, notify NotifyFunc) *Watcher {
+	return &Watcher{
+		address: address,
+		token:   token,
+		notify:  notify,
+		watches: make(map[string]*watch),
+	}
+}
+
+// Watch starts watching a calendar of service under key: it syncs the calendar into the event
+// cache of service to know its current events and opens a channel for it. Watching a key again
+// does nothing.
+func (w *Watcher) Watch(ctx context.Context, key string, service *Service, calendarID string) error {
+	w.mutex.Lock()
+	_, exists := w.watches[key]
+	w.mutex.Unlock()
+	if exists {
+		return nil
+	}
+
+	if _, err := service.syncEvents(ctx, calendarID); err != nil {
+		service.unwatch(calendarID)
+		return err
+	}
+	channel, err := w.openChannel(ctx, service, calendarID)
+	if err != nil {
+		service.unwatch(calendarID)
+		return err
+	}
+
+	w.mutex.Lock()
+	_, exists = w.watches[key]
+	if !exists && !w.stopped {
+		w.watches[key] = &watch{key: key, service: service, calendarID: calendarID, channel: channel}
+	}
+	stopped := w.stopped
+	w.mutex.Unlock()
+
+	if exists || stopped {
+		// Watched concurrently, or shutting down
+		return stopChannel(ctx, service, channel)
+	}
+	log.Printf("Watching calendar %s for changes (channel %s, expires %s)", calendarID, channel.ID, channel.Expiration.Format(time.RFC3339))
+	return nil
+}
+
+// Unwatch stops watching the calendar watched under key
+func (w *Watcher) Unwatch(ctx context.Context, key string) {
+	w.mutex.Lock()
+	watched, ok := w.watches[key]
+	var channel Channel
+	if ok {
+		channel = watched.channel
+		delete(w.watches, key)
+	}
+	w.mutex.Unlock()
+	if !ok {
+		return
+	}
+
+	watched.service.unwatch(watched.calendarID)
+	if err := stopChannel(ctx, watched.service, channel); err != nil {
+		log.Printf("Failed to stop channel %s of calendar %s: %v", channel.ID, watched.calendarID, err)
+	}
+}
+
+// Handler receives the notifications of the watcher's channels. Notifications are answered right
+// away and the calendar is synced in the background.
+func (w *Watcher) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Goog-Channel-Token")), []byte(w.token)) != 1 {
+			http.Error(rw, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		channelID := r.Header.Get("X-Goog-Channel-ID")
+		// The first notification of a channel only confirms it was opened
+		if r.Header.Get("X-Goog-Resource-State") != "sync" {
+			w.notified(channelID)
+		}
+		rw.WriteHeader(http.StatusOK)
+	})
+}
+
+// notified starts a sync of the calendar of a channel, or marks one pending if a sync is running
+func (w *Watcher) notified(channelID string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	watched := w.byChannel(channelID)
+	if watched == nil || w.stopped {
+		// A channel of a calendar no longer watched, which expires on its own
+		return
+	}
+	w.startSync(watched)
+}
+
+// byChannel returns the watch with a channel; the mutex must be held
+func (w *Watcher) byChannel(channelID string) *watch {
+	for _, watched := range w.watches {
+		if watched.channel.ID == channelID {
+			return watched
+		}
+	}
+	return nil
+}
+
+// startSync syncs a watched calendar in the background; the mutex must be held
+func (w *Watcher) startSync(watched *watch) {
+	if watched.syncing {
+		watched.pending = true
+		return
+	}
+	watched.syncing = true
+
+	w.syncs.Add(1)
+	go func() {
+		defer w.syncs.Done()
+		for {
+			w.sync(watched)
+
+			w.mutex.Lock()
+			if !watched.pending || w.stopped {
+				watched.syncing = false
+				w.mutex.Unlock()
+				return
+			}
+			watched.pending = false
+			w.mutex.Unlock()
+		}
+	}()
+}
+
+// sync syncs a watched calendar and passes the changes on
+func (w *Watcher) sync(watched *watch) {
+	ctx, cancel := context.WithTimeout(context.Background(), syncTimeout)
+	defer cancel()
+
+	changes, err := watched.service.syncEvents(ctx, watched.calendarID)
+	if err != nil {
+		log.Printf("Failed to sync calendar %s: %v", watched.calendarID, err)
+		return
+	}
+	if len(changes) > 0 {
+		log.Printf("Found %d changes in calendar %s", len(changes), watched.calendarID)
+		w.notify(watched.key, changes)
+	}
+}
+
+// Run renews channels before they expire and syncs every watched calendar every interval, which
+// catches changes whose notification got lost. When ctx is done it closes all channels and
+// waits for running syncs.
+func (w *Watcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			w.stop()
+			return
+		case <-ticker.C:
+		}
+
+		w.mutex.Lock()
+		var expiring []*watch
+		for _, watched := range w.watches {
+			if !watched.channel.Expiration.IsZero() && time.Until(watched.channel.Expiration) < 2*interval {
+				expiring = append(expiring, watched)
+			}
+			w.startSync(watched)
+		}
+		w.mutex.Unlock()
+
+		for _, watched := range expiring {
+			w.renew(ctx, watched)
+		}
+	}
+}
+
+// renew replaces the channel of a watched calendar with a new one
+func (w *Watcher) renew(ctx context.Context, watched *watch) {
+	channel, err := w.openChannel(ctx, watched.service, watched.calendarID)
+	if err != nil {
+		log.Printf("Failed to renew channel of calendar %s: %v", watched.calendarID, err)
+		return
+	}
+
+	w.mutex.Lock()
+	previous := watched.channel
+	if w.watches[watched.key] == watched {
+		watched.channel = channel
+	} else {
+		// Unwatched meanwhile
+		previous = channel
+	}
+	w.mutex.Unlock()
+
+	if err := stopChannel(ctx, watched.service, previous); err != nil {
+		log.Printf("Failed to stop channel %s of calendar %s: %v", previous.ID, watched.calendarID, err)
+	}
+}
+
+// stop closes all channels and waits for running syncs
+func (w *Watcher) stop() {
+	w.mutex.Lock()
+	w.stopped = true
+	watches := w.watches
+	w.watches = make(map[string]*watch)
+	w.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	for _, watched := range watches {
+		if err := stopChannel(ctx, watched.service, watched.channel); err != nil {
+			log.Printf("Failed to stop channel %s of calendar %s: %v", watched.channel.ID, watched.calendarID, err)
+		}
+	}
+
+	w.syncs.Wait()
+}
+
+// openChannel opens a channel for a calendar with a random ID
+func (w *Watcher) openChannel(ctx context.Context, service *Service, calendarID string) (Channel, error) {
+	backend, ok := service.backend.(SyncBackend)
+	if !ok {
+		return Channel{}, ErrSyncUnsupported
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return Channel{}, err
+	}
+	channel := Channel{ID: hex.EncodeToString(b), Address: w.address, Token: w.token}
+
+	var opened Channel
+	err := service.call(ctx, "watch", func(ctx context.Context) error {
+		var err error
+		opened, err = backend.WatchEvents(ctx, calendarID, channel)
+		return err
+	})
+	return opened, err
+}
+
+// stopChannel closes a channel through the backend of service
+func stopChannel(ctx context.Context, service *Service, channel Channel) error {
+	backend, ok := service.backend.(SyncBackend)
+	if !ok {
+		return ErrSyncUnsupported
+	}
+	return service.call(ctx, "stop_channel", func(ctx context.Context) error {
+		return backend.StopChannel(ctx, channel)
+	})
+}
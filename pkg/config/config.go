@@ -3,11 +3,26 @@ package config
 import (
 	"fmt"
 	"log"
+	"net/url"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+// Update modes for receiving Telegram updates
+const (
+	ModePolling = "polling"
+	ModeWebhook = "webhook"
+)
+
+// Calendar backends of the shared calendar
+const (
+	BackendGoogle    = "google"
+	BackendMicrosoft = "microsoft"
+)
+
 // Config holds application configuration
 type Config struct {
 	TelegramToken string
@@ -15,6 +30,40 @@ type Config struct {
 	GoogleCreds   string
 	CalendarID    string
 	Port          string
+	BotMode       string
+	WebhookURL    string
+	WebhookSecret string
+
+	GoogleOAuthClientID     string
+	GoogleOAuthClientSecret string
+	GoogleOAuthRedirectURL  string
+
+	CalendarBackend       string
+	MicrosoftTenantID     string
+	MicrosoftClientID     string
+	MicrosoftClientSecret string
+	MicrosoftUser         string
+
+	CalendarWatchURL      string
+	CalendarWatchSecret   string
+	CalendarWatchInterval time.Duration
+
+	DatabaseDriver    string
+	DataDir           string
+	EncryptionKeys    string
+	EncryptionKeyFile string
+	BackupInterval    time.Duration
+	BackupRetention   int
+
+	HistoryMaxAge      time.Duration
+	HistoryMaxPerUser  int
+	RetentionInterval  time.Duration
+	HistoryTokenBudget int
+
+	WorkerPoolSize  int
+	WorkerQueueSize int
+	ShutdownTimeout time.Duration
+	RequestTimeout  time.Duration
 }
 
 // Load loads configuration from environment variables
@@ -31,6 +80,27 @@ func Load() (*Config, error) {
 		GoogleCreds:   os.Getenv("GOOGLE_CREDENTIALS_FILE"),
 		CalendarID:    os.Getenv("GOOGLE_CALENDAR_ID"),
 		Port:          os.Getenv("PORT"),
+		BotMode:       os.Getenv("BOT_MODE"),
+		WebhookURL:    os.Getenv("WEBHOOK_URL"),
+		WebhookSecret: os.Getenv("WEBHOOK_SECRET"),
+
+		GoogleOAuthClientID:     os.Getenv("GOOGLE_OAUTH_CLIENT_ID"),
+		GoogleOAuthClientSecret: os.Getenv("GOOGLE_OAUTH_CLIENT_SECRET"),
+		GoogleOAuthRedirectURL:  os.Getenv("GOOGLE_OAUTH_REDIRECT_URL"),
+
+		CalendarBackend:       os.Getenv("CALENDAR_BACKEND"),
+		MicrosoftTenantID:     os.Getenv("MICROSOFT_TENANT_ID"),
+		MicrosoftClientID:     os.Getenv("MICROSOFT_CLIENT_ID"),
+		MicrosoftClientSecret: os.Getenv("MICROSOFT_CLIENT_SECRET"),
+		MicrosoftUser:         os.Getenv("MICROSOFT_USER"),
+
+		CalendarWatchURL:    os.Getenv("CALENDAR_WATCH_URL"),
+		CalendarWatchSecret: os.Getenv("CALENDAR_WATCH_SECRET"),
+
+		DatabaseDriver:    os.Getenv("DATABASE_DRIVER"),
+		DataDir:           os.Getenv("DATA_DIR"),
+		EncryptionKeys:    os.Getenv("ENCRYPTION_KEYS"),
+		EncryptionKeyFile: os.Getenv("ENCRYPTION_KEY_FILE"),
 	}
 
 	if config.Port == "" {
@@ -38,12 +108,111 @@ func Load() (*Config, error) {
 		log.Printf("Using default port: %s", config.Port)
 	}
 
+	if config.BotMode == "" {
+		config.BotMode = ModePolling
+	}
+
+	if config.CalendarBackend == "" {
+		config.CalendarBackend = BackendGoogle
+	}
+	if config.CalendarBackend == BackendMicrosoft {
+		config.CalendarID = os.Getenv("MICROSOFT_CALENDAR_ID")
+		if config.CalendarID == "" {
+			config.CalendarID = "primary"
+		}
+	}
+
+	if config.DatabaseDriver == "" {
+		config.DatabaseDriver = "bolt"
+	}
+
+	if config.DataDir == "" {
+		config.DataDir = "./data"
+	}
+
+	var err error
+	if config.WorkerPoolSize, err = getEnvInt("WORKER_POOL_SIZE", 8); err != nil {
+		return nil, err
+	}
+	if config.WorkerQueueSize, err = getEnvInt("WORKER_QUEUE_SIZE", 100); err != nil {
+		return nil, err
+	}
+	if config.ShutdownTimeout, err = getEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if config.RequestTimeout, err = getEnvDuration("REQUEST_TIMEOUT", 60*time.Second); err != nil {
+		return nil, err
+	}
+	if config.CalendarWatchInterval, err = getEnvDuration("CALENDAR_WATCH_INTERVAL", time.Hour); err != nil {
+		return nil, err
+	}
+	if config.BackupInterval, err = getEnvDuration("BACKUP_INTERVAL", time.Hour); err != nil {
+		return nil, err
+	}
+	if config.BackupRetention, err = getEnvInt("BACKUP_RETENTION", 24); err != nil {
+		return nil, err
+	}
+	if config.HistoryMaxAge, err = getEnvDuration("HISTORY_MAX_AGE", 30*24*time.Hour); err != nil {
+		return nil, err
+	}
+	if config.HistoryMaxPerUser, err = getEnvInt("HISTORY_MAX_PER_USER", 50); err != nil {
+		return nil, err
+	}
+	if config.RetentionInterval, err = getEnvDuration("RETENTION_INTERVAL", time.Hour); err != nil {
+		return nil, err
+	}
+	if config.HistoryTokenBudget, err = getEnvInt("HISTORY_TOKEN_BUDGET", 2000); err != nil {
+		return nil, err
+	}
+
 	log.Printf("Configuration loaded:")
 	log.Printf("  Telegram Token: %s", MaskToken(config.TelegramToken))
 	log.Printf("  OpenAI Key: %s", MaskToken(config.OpenAIKey))
-	log.Printf("  Google Credentials: %s", config.GoogleCreds)
+	log.Printf("  Calendar Backend: %s", config.CalendarBackend)
+	if config.CalendarBackend == BackendMicrosoft {
+		log.Printf("  Microsoft Tenant: %s, client %s, user %s", config.MicrosoftTenantID, config.MicrosoftClientID, config.MicrosoftUser)
+		log.Printf("  Microsoft Client Secret: %s", MaskToken(config.MicrosoftClientSecret))
+	} else {
+		log.Printf("  Google Credentials: %s", config.GoogleCreds)
+	}
 	log.Printf("  Calendar ID: %s", config.CalendarID)
+	if config.OAuthEnabled() {
+		log.Printf("  Google Login: client %s, redirect %s", config.GoogleOAuthClientID, config.GoogleOAuthRedirectURL)
+		log.Printf("  Google Client Secret: %s", MaskToken(config.GoogleOAuthClientSecret))
+	} else {
+		log.Printf("  Google Login: disabled")
+	}
+	if config.WatchEnabled() {
+		log.Printf("  Change Notifications: %s, resync every %s", config.CalendarWatchURL, config.CalendarWatchInterval)
+		log.Printf("  Change Notification Secret: %s", MaskToken(config.CalendarWatchSecret))
+	} else {
+		log.Printf("  Change Notifications: disabled")
+	}
 	log.Printf("  Port: %s", config.Port)
+	log.Printf("  Bot Mode: %s", config.BotMode)
+	if config.BotMode == ModeWebhook {
+		log.Printf("  Webhook URL: %s", config.WebhookURL)
+		log.Printf("  Webhook Secret: %s", MaskToken(config.WebhookSecret))
+	}
+	log.Printf("  Database: %s in %s", config.DatabaseDriver, config.DataDir)
+	switch {
+	case config.EncryptionKeyFile != "":
+		log.Printf("  Encryption: keys from %s", config.EncryptionKeyFile)
+	case config.EncryptionKeys != "":
+		log.Printf("  Encryption: keys from ENCRYPTION_KEYS")
+	default:
+		log.Printf("  Encryption: disabled")
+	}
+	if config.BackupInterval > 0 {
+		log.Printf("  Backups: every %s, keeping %d", config.BackupInterval, config.BackupRetention)
+	} else {
+		log.Printf("  Backups: disabled")
+	}
+	log.Printf("  History Retention: max age %s, max %d per user, every %s", config.HistoryMaxAge, config.HistoryMaxPerUser, config.RetentionInterval)
+	log.Printf("  History Token Budget: %d", config.HistoryTokenBudget)
+	log.Printf("  Worker Pool: %d workers, queue %d", config.WorkerPoolSize, config.WorkerQueueSize)
+	log.Printf("  Shutdown Timeout: %s", config.ShutdownTimeout)
+	log.Printf("  Request Timeout: %s", config.RequestTimeout)
 
 	// Validate required config
 	if err := config.Validate(); err != nil {
@@ -62,15 +231,153 @@ func (c *Config) Validate() error {
 	if c.OpenAIKey == "" {
 		return fmt.Errorf("OPENAI_API_KEY is required")
 	}
-	if c.GoogleCreds == "" {
-		return fmt.Errorf("GOOGLE_CREDENTIALS_FILE is required")
+
+	switch c.CalendarBackend {
+	case BackendGoogle:
+		if c.GoogleCreds == "" && !c.OAuthEnabled() {
+			return fmt.Errorf("GOOGLE_CREDENTIALS_FILE is required unless users log in with GOOGLE_OAUTH_CLIENT_ID")
+		}
+		if c.GoogleCreds != "" && c.CalendarID == "" {
+			return fmt.Errorf("GOOGLE_CALENDAR_ID is required with GOOGLE_CREDENTIALS_FILE")
+		}
+	case BackendMicrosoft:
+		if c.MicrosoftTenantID == "" || c.MicrosoftClientID == "" || c.MicrosoftClientSecret == "" {
+			return fmt.Errorf("MICROSOFT_TENANT_ID, MICROSOFT_CLIENT_ID and MICROSOFT_CLIENT_SECRET are required with the microsoft backend")
+		}
+		if c.MicrosoftUser == "" {
+			return fmt.Errorf("MICROSOFT_USER is required with the microsoft backend")
+		}
+	default:
+		return fmt.Errorf("CALENDAR_BACKEND must be %q or %q, got %q", BackendGoogle, BackendMicrosoft, c.CalendarBackend)
+	}
+	if c.OAuthEnabled() {
+		if c.GoogleOAuthClientSecret == "" || c.GoogleOAuthRedirectURL == "" {
+			return fmt.Errorf("GOOGLE_OAUTH_CLIENT_SECRET and GOOGLE_OAUTH_REDIRECT_URL are required with GOOGLE_OAUTH_CLIENT_ID")
+		}
+		u, err := url.Parse(c.GoogleOAuthRedirectURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("GOOGLE_OAUTH_REDIRECT_URL must be an absolute URL")
+		}
+		// Refresh tokens are stored in the database, which must not hold them in plaintext
+		if c.EncryptionKeys == "" && c.EncryptionKeyFile == "" {
+			return fmt.Errorf("ENCRYPTION_KEYS or ENCRYPTION_KEY_FILE is required with GOOGLE_OAUTH_CLIENT_ID")
+		}
+	}
+
+	if c.WatchEnabled() {
+		u, err := url.Parse(c.CalendarWatchURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("CALENDAR_WATCH_URL must be an absolute https URL")
+		}
+		if c.CalendarWatchSecret == "" {
+			return fmt.Errorf("CALENDAR_WATCH_SECRET is required with CALENDAR_WATCH_URL")
+		}
+		if c.CalendarWatchInterval <= 0 {
+			return fmt.Errorf("CALENDAR_WATCH_INTERVAL must be positive")
+		}
+	}
+
+	switch c.BotMode {
+	case ModePolling:
+	case ModeWebhook:
+		if c.WebhookURL == "" {
+			return fmt.Errorf("WEBHOOK_URL is required in webhook mode")
+		}
+		u, err := url.Parse(c.WebhookURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("WEBHOOK_URL must be an absolute https URL")
+		}
+		if c.WebhookSecret == "" {
+			return fmt.Errorf("WEBHOOK_SECRET is required in webhook mode")
+		}
+	default:
+		return fmt.Errorf("BOT_MODE must be %q or %q, got %q", ModePolling, ModeWebhook, c.BotMode)
+	}
+	if err := c.checkRoutes(); err != nil {
+		return err
+	}
+
+	if c.DatabaseDriver != "bolt" && c.DatabaseDriver != "json" {
+		return fmt.Errorf("DATABASE_DRIVER must be \"bolt\" or \"json\", got %q", c.DatabaseDriver)
+	}
+
+	if c.EncryptionKeys != "" && c.EncryptionKeyFile != "" {
+		return fmt.Errorf("set either ENCRYPTION_KEYS or ENCRYPTION_KEY_FILE, not both")
+	}
+
+	if c.BackupInterval < 0 {
+		return fmt.Errorf("BACKUP_INTERVAL must not be negative")
+	}
+	if c.BackupRetention < 1 {
+		return fmt.Errorf("BACKUP_RETENTION must be at least 1")
+	}
+
+	if c.HistoryMaxAge < 0 {
+		return fmt.Errorf("HISTORY_MAX_AGE must not be negative")
+	}
+	if c.HistoryMaxPerUser < 0 {
+		return fmt.Errorf("HISTORY_MAX_PER_USER must not be negative")
+	}
+	if c.RetentionInterval <= 0 {
+		return fmt.Errorf("RETENTION_INTERVAL must be positive")
+	}
+	if c.HistoryTokenBudget < 100 {
+		return fmt.Errorf("HISTORY_TOKEN_BUDGET must be at least 100")
+	}
+
+	if c.WorkerPoolSize < 1 {
+		return fmt.Errorf("WORKER_POOL_SIZE must be at least 1")
+	}
+	if c.WorkerQueueSize < 1 {
+		return fmt.Errorf("WORKER_QUEUE_SIZE must be at least 1")
+	}
+	if c.ShutdownTimeout <= 0 {
+		return fmt.Errorf("SHUTDOWN_TIMEOUT must be positive")
 	}
-	if c.CalendarID == "" {
-		return fmt.Errorf("GOOGLE_CALENDAR_ID is required")
+	if c.RequestTimeout <= 0 {
+		return fmt.Errorf("REQUEST_TIMEOUT must be positive")
 	}
 	return nil
 }
 
+// OAuthEnabled reports whether users can connect their own Google accounts with /login
+func (c *Config) OAuthEnabled() bool {
+	return c.GoogleOAuthClientID != ""
+}
+
+// WatchEnabled reports whether users can subscribe to change notifications with /notifications
+func (c *Config) WatchEnabled() bool {
+	return c.CalendarWatchURL != ""
+}
+
+// getEnvInt reads an integer environment variable, returning def if it is unset
+func getEnvInt(key string, def int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer: %v", key, err)
+	}
+	return n, nil
+}
+
+// getEnvDuration reads a duration environment variable such as "30s", returning def if it is unset
+func getEnvDuration(key string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a duration like 30s: %v", key, err)
+	}
+	return d, nil
+}
+
 // MaskToken masks sensitive tokens for logging
 func MaskToken(token string) string {
 	if len(token) <= 8 {
@@ -78,3 +385,42 @@ func MaskToken(token string) string {
 	}
 	return token[:4] + "..." + token[len(token)-4:]
 }
+
+// builtinPaths are served on PORT next to the configured URLs
+var builtinPaths = []string{"/healthz", "/readyz", "/metrics"}
+
+// checkRoutes checks that every URL the bot serves on PORT has a path of its own. The paths are
+// registered on one ServeMux, which panics on a duplicate and would route everything to "/".
+func (c *Config) checkRoutes() error {
+	routes := []struct {
+		name    string
+		url     string
+		enabled bool
+	}{
+		{"WEBHOOK_URL", c.WebhookURL, c.BotMode == ModeWebhook},
+		{"GOOGLE_OAUTH_REDIRECT_URL", c.GoogleOAuthRedirectURL, c.OAuthEnabled()},
+		{"CALENDAR_WATCH_URL", c.CalendarWatchURL, c.WatchEnabled()},
+	}
+
+	taken := map[string]string{}
+	for _, path := range builtinPaths {
+		taken[path] = "the built-in endpoint"
+	}
+	for _, route := range routes {
+		if !route.enabled {
+			continue
+		}
+		u, err := url.Parse(route.url)
+		if err != nil {
+			return fmt.Errorf("%s is not a valid URL: %v", route.name, err)
+		}
+		if u.Path == "" || u.Path == "/" {
+			return fmt.Errorf("%s must have a path, such as /telegram/webhook", route.name)
+		}
+		if other, ok := taken[u.Path]; ok {
+			return fmt.Errorf("%s has the same path %s as %s; each needs its own", route.name, u.Path, other)
+		}
+		taken[u.Path] = route.name
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// backupDirName is the directory inside the data directory that holds backups
+	backupDirName = "backups"
+	// backupTimeFormat sorts lexicographically in time order
+	backupTimeFormat = "20060102T150405.000Z"
+)
+
+// DataFile returns the path of the main data file of a driver in dataDir
+func DataFile(driver, dataDir string) string {
+	if driver == DriverJSON {
+		return filepath.Join(dataDir, "interactions.json")
+	}
+	return filepath.Join(dataDir, "bot.db")
+}
+
+// Backups writes rotating timestamped backups of a store next to its data file,
+// e.g. data/backups/interactions-20240101T120000.000Z.json, keeping the newest ones
+type Backups struct {
+	store     Store
+	dataFile  string
+	retention int
+}
+
+// NewBackups creates a backup rotation for store keeping at most retention backups
+func NewBackups(store Store, dataFile string, retention int) *Backups {
+	return &Backups{
+		store:     store,
+		dataFile:  dataFile,
+		retention: retention,
+	}
+}
+
+// Create writes a new backup and removes the oldest ones beyond the retention limit
+func (b *Backups) Create() (string, error) {
+	path := backupPath(b.dataFile, time.Now())
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %v", err)
+	}
+
+	if err := b.store.Backup(path); err != nil {
+		return "", err
+	}
+
+	backups, err := listBackups(b.dataFile)
+	if err != nil {
+		return path, err
+	}
+	for i := b.retention; i < len(backups); i++ {
+		if err := os.Remove(backups[i]); err != nil {
+			log.Printf("Warning: Could not remove old backup %s: %v", backups[i], err)
+		}
+	}
+
+	return path, nil
+}
+
+// Run creates a backup right away and then every interval until ctx is done
+func (b *Backups) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if path, err := b.Create(); err != nil {
+			log.Printf("Backup failed: %v", err)
+		} else {
+			log.Printf("Backup written to %s", path)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// backupPath returns the path of a backup of dataFile taken at t
+func backupPath(dataFile string, t time.Time) string {
+	ext := filepath.Ext(dataFile)
+	name := strings.TrimSuffix(filepath.Base(dataFile), ext)
+	return filepath.Join(filepath.Dir(dataFile), backupDirName, name+"-"+t.UTC().Format(backupTimeFormat)+ext)
+}
+
+// listBackups returns the backups of dataFile, newest first
+func listBackups(dataFile string) ([]string, error) {
+	ext := filepath.Ext(dataFile)
+	name := strings.TrimSuffix(filepath.Base(dataFile), ext)
+	pattern := filepath.Join(filepath.Dir(dataFile), backupDirName, name+"-*"+ext)
+
+	backups, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %v", err)
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	return backups, nil
+}
+
+// writeBytes returns a write function for writeFileAtomic that writes data
+func writeBytes(data []byte) func(w io.Writer) error {
+	return func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	}
+}
+
+// writeFileAtomic writes a file so that path holds either its old or its new content, even after a crash:
+// the content goes to a temporary file in the same directory, which is synced and renamed over path
+func writeFileAtomic(path string, perm os.FileMode, write func(w io.Writer) error) error {
+	dir := filepath.Dir(path)
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName) // no-op once renamed
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpName, path); err != nil {
+		return err
+	}
+
+	// Sync the directory so the rename itself survives a crash
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// moveSealed moves the file at from, whose content is data, to to, encrypting it with the keyring on
+// the way, so files kept aside are no more readable than the store they came from
+func moveSealed(keys *Keyring, from, to string, data []byte) error {
+	sealed, _, err := keys.rewrap(data)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(to, 0600, writeBytes(sealed)); err != nil {
+		return err
+	}
+	return os.Remove(from)
+}
@@ -0,0 +1,590 @@
+package database
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"calendar-assistant-bot/pkg/types"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bucket and key names
+var (
+	metaBucket         = []byte("meta")
+	interactionsBucket = []byte("interactions")
+	stateBucket        = []byte("state")
+
+	schemaVersionKey = []byte("schema_version")
+	jsonImportedKey  = []byte("json_imported")
+	writeCheckKey    = []byte("write_check")
+)
+
+// migrations upgrade the schema one version at a time: migrations[i] moves it from version i to i+1
+var migrations = []func(tx *bolt.Tx) error{
+	// 1: metadata and interactions, with one nested bucket per user keyed by a sequence number
+	func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(metaBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(interactionsBucket)
+		return err
+	},
+	// 2: key-value state, with one nested bucket per user or chat
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(stateBucket)
+		return err
+	},
+}
+
+// BoltStore is the bbolt implementation of Store.
+// Every write is a transaction, so a crash never leaves a partially written database.
+type BoltStore struct {
+	db      *bolt.DB
+	dataDir string
+	keys    *Keyring
+}
+
+// NewBoltStore opens (or creates) the bbolt database in dataDir, migrates its schema and
+// imports interactions.json from the JSON file store on first start.
+// If keys is not nil, interactions are encrypted and existing ones are re-encrypted with the primary key.
+func NewBoltStore(dataDir string, keys *Keyring) (*BoltStore, error) {
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %v", err)
+	}
+
+	path := DataFile(DriverBolt, dataDir)
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s: %v", path, err)
+	}
+
+	store := &BoltStore{db: db, dataDir: dataDir, keys: keys}
+
+	if err := store.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := store.rewrapAll(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := store.importJSON(); err != nil {
+		log.Printf("Warning: Could not import interactions.json: %v", err)
+	}
+
+	return store, nil
+}
+
+// migrate brings the schema up to the latest version
+func (s *BoltStore) migrate() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		var version uint64
+		if meta := tx.Bucket(metaBucket); meta != nil {
+			if v := meta.Get(schemaVersionKey); v != nil {
+				version = binary.BigEndian.Uint64(v)
+			}
+		}
+
+		if version > uint64(len(migrations)) {
+			return fmt.Errorf("database schema version %d is newer than this binary supports (%d)", version, len(migrations))
+		}
+
+		for v := version; v < uint64(len(migrations)); v++ {
+			log.Printf("Migrating database schema to version %d", v+1)
+			if err := migrations[v](tx); err != nil {
+				return fmt.Errorf("failed to migrate database schema to version %d: %v", v+1, err)
+			}
+		}
+
+		return tx.Bucket(metaBucket).Put(schemaVersionKey, uint64Key(uint64(len(migrations))))
+	})
+}
+
+// encryptedBuckets are the buckets whose nested per-user buckets hold encrypted values
+var encryptedBuckets = [][]byte{interactionsBucket, stateBucket}
+
+// rewrapAll encrypts values stored before encryption was enabled and rewraps those
+// encrypted with a key other than the primary key
+func (s *BoltStore) rewrapAll() error {
+	if s.keys == nil {
+		return s.checkPlaintext()
+	}
+
+	total := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range encryptedBuckets {
+			count, err := s.rewrapBucket(tx.Bucket(name))
+			if err != nil {
+				return err
+			}
+			total += count
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to re-encrypt data: %v", err)
+	}
+
+	if total > 0 {
+		log.Printf("Re-encrypted %d values with key %q", total, s.keys.Primary())
+	}
+	return nil
+}
+
+// rewrapBucket rewraps the values of all nested buckets of users and returns how many changed
+func (s *BoltStore) rewrapBucket(users *bolt.Bucket) (int, error) {
+	total := 0
+	err := users.ForEachBucket(func(user []byte) error {
+		bucket := users.Bucket(user)
+
+		// Values can't be changed while iterating over them
+		updated := make(map[string][]byte)
+		err := bucket.ForEach(func(k, v []byte) error {
+			rewrapped, changed, err := s.keys.rewrap(v)
+			if err != nil {
+				return err
+			}
+			if changed {
+				updated[string(k)] = rewrapped
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for k, v := range updated {
+			if err := bucket.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+		total += len(updated)
+		return nil
+	})
+	return total, err
+}
+
+// checkPlaintext fails if the database contains encrypted values, which can't be read without a key
+func (s *BoltStore) checkPlaintext() error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		for _, name := range encryptedBuckets {
+			users := tx.Bucket(name)
+			err := users.ForEachBucket(func(user []byte) error {
+				if _, v := users.Bucket(user).Cursor().First(); v != nil && isEnvelope(v) {
+					return fmt.Errorf("database is encrypted but no encryption key is configured")
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// importJSON imports interactions.json once and moves it aside, encrypted like the store, so it is
+// not imported again
+func (s *BoltStore) importJSON() error {
+	var imported bool
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		imported = tx.Bucket(metaBucket).Get(jsonImportedKey) != nil
+		return nil
+	}); err != nil {
+		return err
+	}
+	if imported {
+		return nil
+	}
+
+	jsonPath := filepath.Join(s.dataDir, "interactions.json")
+	raw, err := os.ReadFile(jsonPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read interactions file: %v", err)
+	}
+
+	data, err := s.keys.open(raw)
+	if err != nil {
+		return err
+	}
+
+	file := &fileData{}
+	if len(data) > 0 {
+		if file, err = decodeFile(data); err != nil {
+			return err
+		}
+	}
+
+	total := 0
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		for userID, userInteractions := range file.Interactions {
+			bucket, err := tx.Bucket(interactionsBucket).CreateBucketIfNotExists(userKey(userID))
+			if err != nil {
+				return err
+			}
+			for _, interaction := range userInteractions {
+				if err := s.putInteraction(bucket, interaction); err != nil {
+					return err
+				}
+				total++
+			}
+		}
+		for id, values := range file.State {
+			bucket, err := tx.Bucket(stateBucket).CreateBucketIfNotExists(userKey(id))
+			if err != nil {
+				return err
+			}
+			for key, value := range values {
+				sealed, err := s.keys.seal(value)
+				if err != nil {
+					return err
+				}
+				if err := bucket.Put([]byte(key), sealed); err != nil {
+					return err
+				}
+			}
+		}
+		return tx.Bucket(metaBucket).Put(jsonImportedKey, []byte(time.Now().Format(time.RFC3339)))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to import interactions: %v", err)
+	}
+
+	if len(data) > 0 {
+		log.Printf("Imported %d interactions for %d users from %s", total, len(file.Interactions), jsonPath)
+		if err := moveSealed(s.keys, jsonPath, jsonPath+".migrated", raw); err != nil {
+			log.Printf("Warning: Could not move %s aside after import: %v", jsonPath, err)
+		}
+	}
+
+	return nil
+}
+
+// AddInteraction stores a new interaction
+func (s *BoltStore) AddInteraction(interaction types.Interaction) error {
+	if interaction.Timestamp.IsZero() {
+		interaction.Timestamp = time.Now()
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket(interactionsBucket).CreateBucketIfNotExists(userKey(interaction.UserID))
+		if err != nil {
+			return err
+		}
+		return s.putInteraction(bucket, interaction)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store interaction: %v", err)
+	}
+
+	return nil
+}
+
+// GetUserInteractions retrieves interactions for a specific user
+func (s *BoltStore) GetUserInteractions(userID int64, limit int) []types.Interaction {
+	interactions := []types.Interaction{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(interactionsBucket).Bucket(userKey(userID))
+		if bucket == nil {
+			return nil
+		}
+
+		// Walk backwards from the newest interaction
+		cursor := bucket.Cursor()
+		for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+			if limit > 0 && len(interactions) >= limit {
+				break
+			}
+			interaction, err := s.decodeInteraction(v)
+			if err != nil {
+				return err
+			}
+			interactions = append(interactions, interaction)
+		}
+		return nil
+	})
+	if err != nil {
+		logStoreError("reading interactions", err)
+	}
+
+	// Return them oldest first
+	for i, j := 0, len(interactions)-1; i < j; i, j = i+1, j-1 {
+		interactions[i], interactions[j] = interactions[j], interactions[i]
+	}
+
+	return interactions
+}
+
+// GetUserStats retrieves user interaction statistics
+func (s *BoltStore) GetUserStats(userID int64) map[string]interface{} {
+	return computeUserStats(s.GetUserInteractions(userID, 0))
+}
+
+// Cleanup removes interactions outside the retention limits
+func (s *BoltStore) Cleanup(maxAge time.Duration, maxPerUser int) (int, error) {
+	cutoff := retentionCutoff(maxAge)
+	totalRemoved := 0
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		users := tx.Bucket(interactionsBucket)
+
+		var emptied [][]byte
+		err := users.ForEachBucket(func(user []byte) error {
+			bucket := users.Bucket(user)
+
+			var keys [][]byte
+			var interactions []types.Interaction
+			err := bucket.ForEach(func(k, v []byte) error {
+				interaction, err := s.decodeInteraction(v)
+				if err != nil {
+					return err
+				}
+				keys = append(keys, k)
+				interactions = append(interactions, interaction)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			expired := expiredInteractions(interactions, cutoff, maxPerUser)
+			for _, i := range expired {
+				if err := bucket.Delete(keys[i]); err != nil {
+					return err
+				}
+			}
+			totalRemoved += len(expired)
+
+			if len(expired) == len(keys) {
+				emptied = append(emptied, user)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// Buckets can't be deleted while iterating over them
+		for _, user := range emptied {
+			if err := users.DeleteBucket(user); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to clean up interactions: %v", err)
+	}
+
+	return totalRemoved, nil
+}
+
+// ExportUserData returns everything stored about a user
+func (s *BoltStore) ExportUserData(userID int64) (*UserData, error) {
+	data := &UserData{
+		UserID:       userID,
+		ExportedAt:   time.Now(),
+		Interactions: []types.Interaction{},
+		State:        make(map[string]json.RawMessage),
+	}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket(interactionsBucket).Bucket(userKey(userID)); bucket != nil {
+			err := bucket.ForEach(func(k, v []byte) error {
+				interaction, err := s.decodeInteraction(v)
+				if err != nil {
+					return err
+				}
+				data.Interactions = append(data.Interactions, interaction)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if bucket := tx.Bucket(stateBucket).Bucket(userKey(userID)); bucket != nil {
+			return bucket.ForEach(func(k, v []byte) error {
+				value, err := s.keys.open(v)
+				if err != nil {
+					return err
+				}
+				data.State[string(k)] = json.RawMessage(value)
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export user data: %v", err)
+	}
+
+	return data, nil
+}
+
+// DeleteUserData removes everything stored about a user
+func (s *BoltStore) DeleteUserData(userID int64) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{interactionsBucket, stateBucket} {
+			err := tx.Bucket(name).DeleteBucket(userKey(userID))
+			if err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete user data: %v", err)
+	}
+	return nil
+}
+
+// GetState loads the value stored under key for a user or chat
+func (s *BoltStore) GetState(id int64, key string, value interface{}) (bool, error) {
+	var data []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(stateBucket).Bucket(userKey(id))
+		if bucket == nil {
+			return nil
+		}
+		if v := bucket.Get([]byte(key)); v != nil {
+			var err error
+			data, err = s.keys.open(v)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to read state %q: %v", key, err)
+	}
+	if data == nil {
+		return false, nil
+	}
+
+	if err := json.Unmarshal(data, value); err != nil {
+		return false, fmt.Errorf("failed to unmarshal state %q: %v", key, err)
+	}
+	return true, nil
+}
+
+// SetState stores value under key for a user or chat; a nil value deletes it
+func (s *BoltStore) SetState(id int64, key string, value interface{}) error {
+	var data []byte
+	if value != nil {
+		var err error
+		if data, err = json.Marshal(value); err != nil {
+			return fmt.Errorf("failed to marshal state %q: %v", key, err)
+		}
+		if data, err = s.keys.seal(data); err != nil {
+			return fmt.Errorf("failed to encrypt state %q: %v", key, err)
+		}
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if data == nil {
+			bucket := tx.Bucket(stateBucket).Bucket(userKey(id))
+			if bucket == nil {
+				return nil
+			}
+			return bucket.Delete([]byte(key))
+		}
+
+		bucket, err := tx.Bucket(stateBucket).CreateBucketIfNotExists(userKey(id))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key), data)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store state %q: %v", key, err)
+	}
+	return nil
+}
+
+// CheckWritable verifies that the database accepts writes
+func (s *BoltStore) CheckWritable() error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket).Put(writeCheckKey, []byte(time.Now().Format(time.RFC3339)))
+	})
+	if err != nil {
+		return fmt.Errorf("database not writable: %v", err)
+	}
+	return nil
+}
+
+// Backup writes a consistent copy of the database to backupPath while it stays available for writes
+func (s *BoltStore) Backup(backupPath string) error {
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return writeFileAtomic(backupPath, 0600, func(w io.Writer) error {
+			_, err := tx.WriteTo(w)
+			return err
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write backup file: %v", err)
+	}
+	return nil
+}
+
+// Close closes the database; committed transactions are already on disk
+func (s *BoltStore) Close() error {
+	log.Printf("Closing database")
+	if err := s.db.Close(); err != nil {
+		return fmt.Errorf("failed to close database: %v", err)
+	}
+	return nil
+}
+
+// putInteraction appends an interaction to a user bucket
+func (s *BoltStore) putInteraction(bucket *bolt.Bucket, interaction types.Interaction) error {
+	seq, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(interaction)
+	if err != nil {
+		return fmt.Errorf("failed to marshal interaction: %v", err)
+	}
+	if data, err = s.keys.seal(data); err != nil {
+		return fmt.Errorf("failed to encrypt interaction: %v", err)
+	}
+
+	return bucket.Put(uint64Key(seq), data)
+}
+
+// decodeInteraction decrypts and unmarshals a stored interaction
+func (s *BoltStore) decodeInteraction(v []byte) (types.Interaction, error) {
+	var interaction types.Interaction
+
+	data, err := s.keys.open(v)
+	if err != nil {
+		return interaction, err
+	}
+	if err := json.Unmarshal(data, &interaction); err != nil {
+		return interaction, fmt.Errorf("failed to unmarshal interaction: %v", err)
+	}
+	return interaction, nil
+}
+
+// userKey encodes a user ID as a bucket key
+func userKey(userID int64) []byte {
+	return uint64Key(uint64(userID))
+}
+
+// uint64Key encodes n big-endian so that keys sort numerically
+func uint64Key(n uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, n)
+	return key
+}
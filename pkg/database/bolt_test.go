@@ -0,0 +1,165 @@
+package database
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"calendar-assistant-bot/pkg/types"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// writeInteractionsFile writes interactions.json as the JSON file store did before state was stored
+func writeInteractionsFile(t *testing.T, dir string, interactions map[int64][]types.Interaction) {
+	t.Helper()
+	data, err := json.Marshal(interactions)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "interactions.json"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// userMessages lists the messages of interactions
+func userMessages(interactions []types.Interaction) []string {
+	messages := []string{}
+	for _, interaction := range interactions {
+		messages = append(messages, interaction.UserMessage)
+	}
+	return messages
+}
+
+func TestBoltStoreImportJSON(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2025, 8, 4, 10, 0, 0, 0, time.UTC)
+	writeInteractionsFile(t, dir, map[int64][]types.Interaction{
+		1: {
+			{UserID: 1, Timestamp: now, UserMessage: "first", AIResponse: "{}", Action: "None"},
+			{UserID: 1, Timestamp: now.Add(time.Minute), UserMessage: "second", AIResponse: "{}", Action: "getEvents"},
+		},
+		2: {{UserID: 2, Timestamp: now, UserMessage: "hello", AIResponse: "{}"}},
+	})
+
+	store, err := NewBoltStore(dir, nil)
+	if err != nil {
+		t.Fatalf("NewBoltStore() error = %v", err)
+	}
+	if got := userMessages(store.GetUserInteractions(1, 0)); len(got) != 2 || got[0] != "first" || got[1] != "second" {
+		t.Errorf("GetUserInteractions(1) = %v, want [first second]", got)
+	}
+	if got := userMessages(store.GetUserInteractions(2, 0)); len(got) != 1 || got[0] != "hello" {
+		t.Errorf("GetUserInteractions(2) = %v, want [hello]", got)
+	}
+	store.Close()
+
+	if _, err := os.Stat(filepath.Join(dir, "interactions.json")); !os.IsNotExist(err) {
+		t.Errorf("interactions.json was not moved aside after import: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "interactions.json.migrated")); err != nil {
+		t.Errorf("interactions.json.migrated: %v", err)
+	}
+
+	// A file written again later is not imported a second time
+	writeInteractionsFile(t, dir, map[int64][]types.Interaction{1: {{UserID: 1, Timestamp: now, UserMessage: "again"}}})
+	store, err = NewBoltStore(dir, nil)
+	if err != nil {
+		t.Fatalf("NewBoltStore() reopen error = %v", err)
+	}
+	defer store.Close()
+	if got := store.GetUserInteractions(1, 0); len(got) != 2 {
+		t.Errorf("GetUserInteractions(1) after reopen = %v, want the 2 imported once", userMessages(got))
+	}
+}
+
+// createDatabase creates bot.db at schema version, running the migrations up to it, and lets fill
+// write to it
+func createDatabase(t *testing.T, dir string, version uint64, fill func(tx *bolt.Tx) error) {
+	t.Helper()
+	db, err := bolt.Open(filepath.Join(dir, "bot.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("bolt.Open() error = %v", err)
+	}
+	defer db.Close()
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, migration := range migrations[:version] {
+			if err := migration(tx); err != nil {
+				return err
+			}
+		}
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+		if err := meta.Put(schemaVersionKey, uint64Key(version)); err != nil {
+			return err
+		}
+		return fill(tx)
+	})
+	if err != nil {
+		t.Fatalf("creating database at version %d: %v", version, err)
+	}
+}
+
+func TestBoltStoreMigrate(t *testing.T) {
+	dir := t.TempDir()
+	older := uint64(len(migrations) - 1)
+	interaction, _ := json.Marshal(types.Interaction{UserID: 1, UserMessage: "before the upgrade"})
+	createDatabase(t, dir, older, func(tx *bolt.Tx) error {
+		// Interactions exist from version 1 on
+		if older == 0 {
+			return nil
+		}
+		bucket, err := tx.Bucket(interactionsBucket).CreateBucket(userKey(1))
+		if err != nil {
+			return err
+		}
+		return bucket.Put(uint64Key(1), interaction)
+	})
+
+	store, err := NewBoltStore(dir, nil)
+	if err != nil {
+		t.Fatalf("NewBoltStore() error = %v", err)
+	}
+	defer store.Close()
+
+	var version uint64
+	store.db.View(func(tx *bolt.Tx) error {
+		version = binary.BigEndian.Uint64(tx.Bucket(metaBucket).Get(schemaVersionKey))
+		return nil
+	})
+	if version != uint64(len(migrations)) {
+		t.Errorf("schema version = %d, want %d", version, len(migrations))
+	}
+	if older > 0 {
+		if got := userMessages(store.GetUserInteractions(1, 0)); len(got) != 1 || got[0] != "before the upgrade" {
+			t.Errorf("GetUserInteractions(1) = %v, want the interaction from before the upgrade", got)
+		}
+	}
+
+	// State was added in version 2
+	if err := store.SetState(1, "key", "value"); err != nil {
+		t.Fatalf("SetState() error = %v", err)
+	}
+	var value string
+	if found, err := store.GetState(1, "key", &value); err != nil || !found || value != "value" {
+		t.Errorf("GetState() = %q, %v, %v, want value", value, found, err)
+	}
+}
+
+func TestBoltStoreNewerSchema(t *testing.T) {
+	dir := t.TempDir()
+	createDatabase(t, dir, uint64(len(migrations)), func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket).Put(schemaVersionKey, uint64Key(uint64(len(migrations)+1)))
+	})
+
+	if store, err := NewBoltStore(dir, nil); err == nil {
+		store.Close()
+		t.Fatal("NewBoltStore() of a newer schema succeeded, want an error")
+	}
+}
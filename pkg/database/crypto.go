@@ -0,0 +1,278 @@
+package database
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Encrypted data is stored as an envelope:
+//
+//	magic | key ID length (1 byte) | key ID | wrapped data key | nonce | ciphertext
+//
+// Every envelope has its own random data key, which encrypts the data and is itself encrypted
+// ("wrapped") with a key from the keyring. Rotating the keyring only rewraps data keys.
+const (
+	envelopeMagic = "CBE1"
+	dataKeySize   = 32
+	nonceSize     = 12
+	tagSize       = 16
+	// wrappedKeySize is the size of a data key encrypted with AES-GCM, including its nonce and tag
+	wrappedKeySize = nonceSize + dataKeySize + tagSize
+)
+
+// Keyring holds the keys that encrypt stored data. The primary key encrypts new data; the
+// others are kept so that data encrypted before a key rotation can still be read.
+// A nil Keyring stores data in plaintext.
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// LoadKeyring loads the keyring from a key list (see ParseKeyring) or from a file with one key per line.
+// It returns nil if neither is set, which disables encryption.
+func LoadKeyring(keys, keyFile string) (*Keyring, error) {
+	if keys != "" && keyFile != "" {
+		return nil, fmt.Errorf("set either the encryption keys or the key file, not both")
+	}
+
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file: %v", err)
+		}
+		keys = string(data)
+	}
+
+	if strings.TrimSpace(keys) == "" {
+		if keyFile != "" {
+			return nil, fmt.Errorf("key file %s contains no keys", keyFile)
+		}
+		return nil, nil
+	}
+
+	return ParseKeyring(keys)
+}
+
+// ParseKeyring parses keys of the form "id:base64key", separated by commas or newlines.
+// Keys are 32 bytes (AES-256) and the first one is the primary key. Lines starting with # are ignored.
+func ParseKeyring(spec string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+
+	for _, entry := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == '\n' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("encryption key must look like id:base64key")
+		}
+		if len(id) > 255 {
+			return nil, fmt.Errorf("encryption key ID %q is too long", id)
+		}
+		if _, exists := k.keys[id]; exists {
+			return nil, fmt.Errorf("duplicate encryption key ID %q", id)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %q is not valid base64: %v", id, err)
+		}
+		if len(key) != dataKeySize {
+			return nil, fmt.Errorf("encryption key %q must be %d bytes, got %d", id, dataKeySize, len(key))
+		}
+
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[id] = aead
+		if k.primary == "" {
+			k.primary = id
+		}
+	}
+
+	if k.primary == "" {
+		return nil, fmt.Errorf("no encryption keys found")
+	}
+	return k, nil
+}
+
+// Primary returns the ID of the key that encrypts new data
+func (k *Keyring) Primary() string {
+	if k == nil {
+		return ""
+	}
+	return k.primary
+}
+
+// seal encrypts plaintext into an envelope with the primary key, or returns it unchanged if k is nil
+func (k *Keyring) seal(plaintext []byte) ([]byte, error) {
+	if k == nil {
+		return plaintext, nil
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %v", err)
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := encrypt(dataAEAD, plaintext, []byte(envelopeMagic))
+	if err != nil {
+		return nil, err
+	}
+	return k.wrap(dataKey, ciphertext)
+}
+
+// open decrypts an envelope. Plaintext data written before encryption was enabled is returned unchanged.
+func (k *Keyring) open(data []byte) ([]byte, error) {
+	if !isEnvelope(data) {
+		return data, nil
+	}
+
+	keyID, wrapped, ciphertext, err := parseEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := k.unwrap(keyID, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := decrypt(dataAEAD, ciphertext, []byte(envelopeMagic))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data: %v", err)
+	}
+	return plaintext, nil
+}
+
+// rewrap returns data re-encrypted so that it is readable with the primary key: plaintext is sealed,
+// and the data key of an envelope under an older key is rewrapped without touching the ciphertext.
+// It returns false if data is already up to date.
+func (k *Keyring) rewrap(data []byte) ([]byte, bool, error) {
+	if k == nil {
+		return data, false, nil
+	}
+	if !isEnvelope(data) {
+		sealed, err := k.seal(data)
+		return sealed, true, err
+	}
+
+	keyID, wrapped, ciphertext, err := parseEnvelope(data)
+	if err != nil {
+		return nil, false, err
+	}
+	if keyID == k.primary {
+		return data, false, nil
+	}
+
+	dataKey, err := k.unwrap(keyID, wrapped)
+	if err != nil {
+		return nil, false, err
+	}
+	rewrapped, err := k.wrap(dataKey, ciphertext)
+	return rewrapped, true, err
+}
+
+// wrap encrypts a data key with the primary key and builds the envelope around ciphertext
+func (k *Keyring) wrap(dataKey, ciphertext []byte) ([]byte, error) {
+	header := k.header(k.primary)
+	wrapped, err := encrypt(k.keys[k.primary], dataKey, header)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope := make([]byte, 0, len(header)+len(wrapped)+len(ciphertext))
+	envelope = append(envelope, header...)
+	envelope = append(envelope, wrapped...)
+	return append(envelope, ciphertext...), nil
+}
+
+// unwrap decrypts a data key with the keyring key keyID
+func (k *Keyring) unwrap(keyID string, wrapped []byte) ([]byte, error) {
+	if k == nil {
+		return nil, fmt.Errorf("data is encrypted but no encryption key is configured")
+	}
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("data is encrypted with unknown key %q", keyID)
+	}
+
+	dataKey, err := decrypt(aead, wrapped, k.header(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key with key %q: %v", keyID, err)
+	}
+	return dataKey, nil
+}
+
+// header returns the envelope header up to and including the key ID.
+// It is authenticated together with the wrapped data key, so a data key can't be moved to another key ID.
+func (k *Keyring) header(keyID string) []byte {
+	header := make([]byte, 0, len(envelopeMagic)+1+len(keyID))
+	header = append(header, envelopeMagic...)
+	header = append(header, byte(len(keyID)))
+	return append(header, keyID...)
+}
+
+// isEnvelope reports whether data is an encrypted envelope
+func isEnvelope(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(envelopeMagic))
+}
+
+// parseEnvelope splits an envelope into its key ID, wrapped data key and ciphertext
+func parseEnvelope(data []byte) (string, []byte, []byte, error) {
+	rest := data[len(envelopeMagic):]
+	if len(rest) < 1 {
+		return "", nil, nil, fmt.Errorf("encrypted data is truncated")
+	}
+	idLen := int(rest[0])
+	rest = rest[1:]
+	if len(rest) < idLen+wrappedKeySize+nonceSize+tagSize {
+		return "", nil, nil, fmt.Errorf("encrypted data is truncated")
+	}
+
+	keyID := string(rest[:idLen])
+	wrapped := rest[idLen : idLen+wrappedKeySize]
+	return keyID, wrapped, rest[idLen+wrappedKeySize:], nil
+}
+
+// newAEAD creates an AES-GCM cipher
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypt encrypts plaintext with a random nonce, which is prepended to the result
+func encrypt(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// decrypt decrypts the output of encrypt
+func decrypt(aead cipher.AEAD, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < nonceSize {
+		return nil, fmt.Errorf("ciphertext is truncated")
+	}
+	return aead.Open(nil, ciphertext[:nonceSize], ciphertext[nonceSize:], additionalData)
+}
@@ -0,0 +1,207 @@
+package database
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"calendar-assistant-bot/pkg/types"
+)
+
+// testKey returns a keyring entry with ID id and a key of 32 times fill
+func testKey(id string, fill byte) string {
+	return id + ":" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{fill}, dataKeySize))
+}
+
+// mustKeyring parses spec, failing the test on error
+func mustKeyring(t *testing.T, spec string) *Keyring {
+	t.Helper()
+	keys, err := ParseKeyring(spec)
+	if err != nil {
+		t.Fatalf("ParseKeyring(%q) error = %v", spec, err)
+	}
+	return keys
+}
+
+// envelopeKeyID returns the ID of the key an envelope's data key is wrapped with
+func envelopeKeyID(t *testing.T, data []byte) string {
+	t.Helper()
+	keyID, _, _, err := parseEnvelope(data)
+	if err != nil {
+		t.Fatalf("parseEnvelope() error = %v", err)
+	}
+	return keyID
+}
+
+func TestKeyringSealOpen(t *testing.T) {
+	keys := mustKeyring(t, testKey("k1", 1))
+	plaintext := []byte(`{"user_message": "dentist on Friday"}`)
+
+	sealed, err := keys.seal(plaintext)
+	if err != nil {
+		t.Fatalf("seal() error = %v", err)
+	}
+	if !isEnvelope(sealed) || bytes.Contains(sealed, []byte("dentist")) {
+		t.Errorf("seal() = %q, want an envelope without the plaintext", sealed)
+	}
+	if again, _ := keys.seal(plaintext); bytes.Equal(again, sealed) {
+		t.Error("seal() twice gave the same envelope, want a new data key and nonce each time")
+	}
+
+	opened, err := keys.open(sealed)
+	if err != nil {
+		t.Fatalf("open() error = %v", err)
+	}
+	if !bytes.Equal(opened, plaintext) {
+		t.Errorf("open() = %q, want %q", opened, plaintext)
+	}
+
+	// Data written before encryption was enabled is read as it is
+	if opened, err := keys.open(plaintext); err != nil || !bytes.Equal(opened, plaintext) {
+		t.Errorf("open(plaintext) = %q, %v, want it unchanged", opened, err)
+	}
+	var none *Keyring
+	if sealed, err := none.seal(plaintext); err != nil || !bytes.Equal(sealed, plaintext) {
+		t.Errorf("nil seal() = %q, %v, want plaintext", sealed, err)
+	}
+}
+
+func TestKeyringOpenFails(t *testing.T) {
+	keys := mustKeyring(t, testKey("k1", 1))
+	sealed, err := keys.seal([]byte("secret"))
+	if err != nil {
+		t.Fatalf("seal() error = %v", err)
+	}
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name string
+		keys *Keyring
+		data []byte
+	}{
+		{"wrong key with the same ID", mustKeyring(t, testKey("k1", 2)), sealed},
+		{"unknown key ID", mustKeyring(t, testKey("k2", 1)), sealed},
+		{"no keyring", nil, sealed},
+		{"tampered ciphertext", keys, tampered},
+		{"truncated", keys, sealed[:len(envelopeMagic)+4]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if opened, err := tt.keys.open(tt.data); err == nil {
+				t.Errorf("open() = %q, want an error", opened)
+			}
+		})
+	}
+}
+
+func TestKeyringRewrap(t *testing.T) {
+	old := mustKeyring(t, testKey("old", 1))
+	sealed, err := old.seal([]byte("secret"))
+	if err != nil {
+		t.Fatalf("seal() error = %v", err)
+	}
+
+	// After a rotation the new key is primary and the old one still reads existing data
+	rotated := mustKeyring(t, testKey("new", 2)+","+testKey("old", 1))
+	if opened, err := rotated.open(sealed); err != nil || string(opened) != "secret" {
+		t.Fatalf("open() after rotation = %q, %v, want secret", opened, err)
+	}
+
+	rewrapped, changed, err := rotated.rewrap(sealed)
+	if err != nil || !changed {
+		t.Fatalf("rewrap() = %v, %v, want changed", changed, err)
+	}
+	if keyID := envelopeKeyID(t, rewrapped); keyID != "new" {
+		t.Errorf("rewrapped key ID = %q, want new", keyID)
+	}
+	if opened, err := mustKeyring(t, testKey("new", 2)).open(rewrapped); err != nil || string(opened) != "secret" {
+		t.Errorf("open() without the old key = %q, %v, want secret", opened, err)
+	}
+	if _, changed, _ := rotated.rewrap(rewrapped); changed {
+		t.Error("rewrap() of up-to-date data changed it")
+	}
+
+	// Plaintext is sealed
+	sealedPlaintext, changed, err := rotated.rewrap([]byte("plain"))
+	if err != nil || !changed || envelopeKeyID(t, sealedPlaintext) != "new" {
+		t.Errorf("rewrap(plaintext) = %q, %v, %v, want sealed with new", sealedPlaintext, changed, err)
+	}
+}
+
+func TestParseKeyring(t *testing.T) {
+	tests := []struct {
+		name        string
+		spec        string
+		wantPrimary string
+		wantErr     bool
+	}{
+		{"one key", testKey("k1", 1), "k1", false},
+		{"first is primary", testKey("k2", 2) + "," + testKey("k1", 1), "k2", false},
+		{"lines and comments", "# rotated in August\n" + testKey("k2", 2) + "\n\n" + testKey("k1", 1) + "\n", "k2", false},
+		{"no ID", ":" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, dataKeySize)), "", true},
+		{"duplicate ID", testKey("k1", 1) + "," + testKey("k1", 2), "", true},
+		{"short key", "k1:" + base64.StdEncoding.EncodeToString([]byte("short")), "", true},
+		{"not base64", "k1:not base64!", "", true},
+		{"only comments", "# none yet", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := ParseKeyring(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseKeyring() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := keys.Primary(); got != tt.wantPrimary {
+				t.Errorf("ParseKeyring().Primary() = %q, want %q", got, tt.wantPrimary)
+			}
+		})
+	}
+}
+
+func TestBoltStoreRotation(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2025, 8, 4, 10, 0, 0, 0, time.UTC)
+	writeInteractionsFile(t, dir, map[int64][]types.Interaction{
+		1: {{UserID: 1, Timestamp: now, UserMessage: "dentist on Friday", AIResponse: "{}"}},
+	})
+
+	// The import is encrypted with the first key, and so is the file moved aside
+	store, err := NewBoltStore(dir, mustKeyring(t, testKey("old", 1)))
+	if err != nil {
+		t.Fatalf("NewBoltStore() error = %v", err)
+	}
+	store.Close()
+	migrated, err := os.ReadFile(filepath.Join(dir, "interactions.json.migrated"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isEnvelope(migrated) || envelopeKeyID(t, migrated) != "old" {
+		t.Errorf("interactions.json.migrated = %q, want it encrypted with old", migrated)
+	}
+
+	if store, err := NewBoltStore(dir, nil); err == nil {
+		store.Close()
+		t.Error("NewBoltStore() of an encrypted database without keys succeeded, want an error")
+	}
+
+	// Opening with a new primary key rewraps everything, so the old key can be dropped
+	store, err = NewBoltStore(dir, mustKeyring(t, testKey("new", 2)+","+testKey("old", 1)))
+	if err != nil {
+		t.Fatalf("NewBoltStore() after rotation error = %v", err)
+	}
+	store.Close()
+
+	store, err = NewBoltStore(dir, mustKeyring(t, testKey("new", 2)))
+	if err != nil {
+		t.Fatalf("NewBoltStore() with only the new key error = %v", err)
+	}
+	defer store.Close()
+	if got := userMessages(store.GetUserInteractions(1, 0)); len(got) != 1 || got[0] != "dentist on Friday" {
+		t.Errorf("GetUserInteractions(1) = %v, want the imported interaction", got)
+	}
+}
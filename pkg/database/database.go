@@ -5,52 +5,87 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
 	"sync"
 	"time"
 
 	"calendar-assistant-bot/pkg/types"
 )
 
-// Database handles storage and retrieval of AI interactions
+// Database is the JSON file implementation of Store.
+// It keeps all data in memory and atomically rewrites interactions.json on every change.
 type Database struct {
+	dataDir      string
 	filePath     string
+	keys         *Keyring
 	mutex        sync.RWMutex
 	interactions map[int64][]types.Interaction
+	state        map[int64]map[string]json.RawMessage
 }
 
-// NewDatabase creates a new database instance
-func NewDatabase(dataDir string) (*Database, error) {
+// fileData is the content of interactions.json
+type fileData struct {
+	Interactions map[int64][]types.Interaction        `json:"interactions"`
+	State        map[int64]map[string]json.RawMessage `json:"state,omitempty"`
+}
+
+// decodeFile parses interactions.json. Files written before state was stored hold only the interactions map.
+func decodeFile(data []byte) (*fileData, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal interactions: %v", err)
+	}
+
+	file := &fileData{}
+	if _, ok := fields["interactions"]; ok {
+		if err := json.Unmarshal(data, file); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal interactions: %v", err)
+		}
+	} else if err := json.Unmarshal(data, &file.Interactions); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal interactions: %v", err)
+	}
+
+	if file.Interactions == nil {
+		file.Interactions = make(map[int64][]types.Interaction)
+	}
+	if file.State == nil {
+		file.State = make(map[int64]map[string]json.RawMessage)
+	}
+	return file, nil
+}
+
+// NewDatabase creates a new JSON file database instance.
+// If keys is not nil, the file is encrypted; an existing file is re-encrypted with the primary key.
+func NewDatabase(dataDir string, keys *Keyring) (*Database, error) {
 	db := &Database{
-		filePath:     filepath.Join(dataDir, "interactions.json"),
+		dataDir:      dataDir,
+		filePath:     DataFile(DriverJSON, dataDir),
+		keys:         keys,
 		interactions: make(map[int64][]types.Interaction),
+		state:        make(map[int64]map[string]json.RawMessage),
 	}
 
 	// Create data directory if it doesn't exist
-	if err := os.MkdirAll(dataDir, 0755); err != nil {
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
 		return nil, fmt.Errorf("failed to create data directory: %v", err)
 	}
 
-	// Load existing interactions
+	// Load existing interactions; refuse to start rather than overwrite a file that could not be read
 	if err := db.loadInteractions(); err != nil {
-		log.Printf("Warning: Could not load existing interactions: %v", err)
+		return nil, fmt.Errorf("could not load existing interactions: %v", err)
 	}
 
 	return db, nil
 }
 
 // AddInteraction stores a new interaction
-func (d *Database) AddInteraction(userID int64, userMessage, aiResponse, action string) error {
+func (d *Database) AddInteraction(interaction types.Interaction) error {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
 
-	interaction := types.Interaction{
-		UserID:      userID,
-		Timestamp:   time.Now(),
-		UserMessage: userMessage,
-		AIResponse:  aiResponse,
-		Action:      action,
+	if interaction.Timestamp.IsZero() {
+		interaction.Timestamp = time.Now()
 	}
+	userID := interaction.UserID
 
 	// Add to in-memory storage
 	if d.interactions[userID] == nil {
@@ -58,14 +93,8 @@ func (d *Database) AddInteraction(userID int64, userMessage, aiResponse, action
 	}
 	d.interactions[userID] = append(d.interactions[userID], interaction)
 
-	// Keep only last 50 interactions per user
-	if len(d.interactions[userID]) > 50 {
-		d.interactions[userID] = d.interactions[userID][len(d.interactions[userID])-50:]
-	}
-
 	// Persist to disk
 	log.Printf("Saving interactions for user %d", userID)
-	log.Printf("Interactions: %v", d.interactions[userID])
 	return d.saveInteractions()
 }
 
@@ -87,44 +116,9 @@ func (d *Database) GetUserInteractions(userID int64, limit int) []types.Interact
 	return interactions
 }
 
-// GetUserContext retrieves recent conversation context for a user
-func (d *Database) GetUserContext(userID int64, messageCount int) string {
-	interactions := d.GetUserInteractions(userID, messageCount)
-	if len(interactions) == 0 {
-		return ""
-	}
-
-	var context string
-	for _, interaction := range interactions {
-		context += fmt.Sprintf("User: %s\nAI: %s\n\n", interaction.UserMessage, interaction.AIResponse)
-	}
-
-	return context
-}
-
 // GetUserStats retrieves user interaction statistics
 func (d *Database) GetUserStats(userID int64) map[string]interface{} {
-	interactions := d.GetUserInteractions(userID, 0)
-	stats := map[string]interface{}{
-		"total_interactions": len(interactions),
-		"first_interaction":  nil,
-		"last_interaction":   nil,
-		"actions_used":       make(map[string]int),
-	}
-
-	if len(interactions) > 0 {
-		stats["first_interaction"] = interactions[0].Timestamp
-		stats["last_interaction"] = interactions[len(interactions)-1].Timestamp
-
-		// Count actions
-		for _, interaction := range interactions {
-			if interaction.Action != "" {
-				stats["actions_used"].(map[string]int)[interaction.Action]++
-			}
-		}
-	}
-
-	return stats
+	return computeUserStats(d.GetUserInteractions(userID, 0))
 }
 
 // loadInteractions loads interactions from disk
@@ -141,12 +135,68 @@ func (d *Database) loadInteractions() error {
 		return nil
 	}
 
-	var interactions map[int64][]types.Interaction
-	if err := json.Unmarshal(data, &interactions); err != nil {
-		return fmt.Errorf("failed to unmarshal interactions: %v", err)
+	// A decryption error is not corruption: the file is fine, the key is wrong or missing
+	plaintext, err := d.keys.open(data)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt interactions file: %v", err)
 	}
 
-	d.interactions = interactions
+	file, err := decodeFile(plaintext)
+	if err != nil {
+		return d.recoverFromBackup(data, err)
+	}
+
+	d.interactions = file.Interactions
+	d.state = file.State
+
+	// Encrypt a plaintext file, or one encrypted with a retired key, right away
+	if _, changed, err := d.keys.rewrap(data); err == nil && changed {
+		log.Printf("Re-encrypting interactions file with key %q", d.keys.Primary())
+		return d.saveInteractions()
+	}
+	return nil
+}
+
+// recoverFromBackup moves a corrupt interactions file, whose content is data, aside and restores the
+// newest backup that parses
+func (d *Database) recoverFromBackup(data []byte, cause error) error {
+	corruptPath := fmt.Sprintf("%s.corrupt-%s", d.filePath, time.Now().UTC().Format(backupTimeFormat))
+	log.Printf("Interactions file is corrupt (%v), moving it to %s", cause, corruptPath)
+	if err := moveSealed(d.keys, d.filePath, corruptPath, data); err != nil {
+		return fmt.Errorf("failed to move corrupt interactions file aside: %v", err)
+	}
+
+	backups, err := listBackups(d.filePath)
+	if err != nil {
+		return err
+	}
+
+	for _, backup := range backups {
+		data, err := os.ReadFile(backup)
+		if err == nil {
+			data, err = d.keys.open(data)
+		}
+		if err != nil {
+			log.Printf("Skipping backup %s: %v", backup, err)
+			continue
+		}
+
+		file, err := decodeFile(data)
+		if err != nil {
+			log.Printf("Skipping corrupt backup %s: %v", backup, err)
+			continue
+		}
+
+		d.interactions = file.Interactions
+		d.state = file.State
+		if err := d.saveInteractions(); err != nil {
+			return fmt.Errorf("failed to restore backup %s: %v", backup, err)
+		}
+		log.Printf("Restored interactions from backup %s", backup)
+		return nil
+	}
+
+	log.Printf("Warning: No usable backup found, starting with empty history (the corrupt file is kept at %s)", corruptPath)
 	return nil
 }
 
@@ -154,56 +204,184 @@ func (d *Database) loadInteractions() error {
 func (d *Database) saveInteractions() error {
 	// Note: This function is called from functions that already hold the write lock
 	// so we don't need to acquire any additional locks here
-	data, err := json.MarshalIndent(d.interactions, "", "  ")
-
+	data, err := d.encode()
 	if err != nil {
-		return fmt.Errorf("failed to marshal interactions: %v", err)
+		return err
 	}
 
-	if err := os.WriteFile(d.filePath, data, 0644); err != nil {
+	if err := writeFileAtomic(d.filePath, 0600, writeBytes(data)); err != nil {
 		return fmt.Errorf("failed to write interactions file: %v", err)
 	}
 
 	return nil
 }
 
+// encode marshals and encrypts the file content; the caller holds the lock
+func (d *Database) encode() ([]byte, error) {
+	data, err := json.MarshalIndent(fileData{Interactions: d.interactions, State: d.state}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal interactions: %v", err)
+	}
+
+	if data, err = d.keys.seal(data); err != nil {
+		return nil, fmt.Errorf("failed to encrypt interactions: %v", err)
+	}
+	return data, nil
+}
+
+// Close flushes all interactions to disk.
+// Writes hold the lock for their whole duration, so Close also waits for any write in progress.
+func (d *Database) Close() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	log.Printf("Flushing database to disk")
+	return d.saveInteractions()
+}
+
+// CheckWritable verifies that the data directory accepts writes
+func (d *Database) CheckWritable() error {
+	file, err := os.CreateTemp(d.dataDir, ".writecheck-*")
+	if err != nil {
+		return fmt.Errorf("data directory not writable: %v", err)
+	}
+	name := file.Name()
+	defer os.Remove(name)
+
+	if _, err := file.Write([]byte("ok")); err != nil {
+		file.Close()
+		return fmt.Errorf("data directory not writable: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("data directory not writable: %v", err)
+	}
+
+	return nil
+}
+
 // Backup creates a backup of the current database
 func (d *Database) Backup(backupPath string) error {
 	d.mutex.RLock()
-	data, err := json.MarshalIndent(d.interactions, "", "  ")
+	data, err := d.encode()
 	d.mutex.RUnlock()
 
 	if err != nil {
-		return fmt.Errorf("failed to marshal interactions for backup: %v", err)
+		return err
 	}
 
-	if err := os.WriteFile(backupPath, data, 0644); err != nil {
+	if err := writeFileAtomic(backupPath, 0600, writeBytes(data)); err != nil {
 		return fmt.Errorf("failed to write backup file: %v", err)
 	}
 
 	return nil
 }
 
-// Cleanup removes old interactions (older than specified days)
-func (d *Database) Cleanup(daysOld int) error {
+// Cleanup removes interactions outside the retention limits
+func (d *Database) Cleanup(maxAge time.Duration, maxPerUser int) (int, error) {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
 
-	cutoff := time.Now().AddDate(0, 0, -daysOld)
+	cutoff := retentionCutoff(maxAge)
 	totalRemoved := 0
 
 	for userID, interactions := range d.interactions {
+		expired := expiredInteractions(interactions, cutoff, maxPerUser)
+		if len(expired) == 0 {
+			continue
+		}
+
 		var validInteractions []types.Interaction
-		for _, interaction := range interactions {
-			if interaction.Timestamp.After(cutoff) {
-				validInteractions = append(validInteractions, interaction)
-			} else {
-				totalRemoved++
+		next := 0
+		for i, interaction := range interactions {
+			if next < len(expired) && expired[next] == i {
+				next++
+				continue
 			}
+			validInteractions = append(validInteractions, interaction)
+		}
+		totalRemoved += len(expired)
+
+		if len(validInteractions) == 0 {
+			delete(d.interactions, userID)
+		} else {
+			d.interactions[userID] = validInteractions
+		}
+	}
+
+	if totalRemoved == 0 {
+		return 0, nil
+	}
+	return totalRemoved, d.saveInteractions()
+}
+
+// ExportUserData returns everything stored about a user
+func (d *Database) ExportUserData(userID int64) (*UserData, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	interactions := append([]types.Interaction{}, d.interactions[userID]...)
+	state := make(map[string]json.RawMessage)
+	for key, value := range d.state[userID] {
+		state[key] = value
+	}
+
+	return &UserData{
+		UserID:       userID,
+		ExportedAt:   time.Now(),
+		Interactions: interactions,
+		State:        state,
+	}, nil
+}
+
+// DeleteUserData removes everything stored about a user
+func (d *Database) DeleteUserData(userID int64) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	delete(d.interactions, userID)
+	delete(d.state, userID)
+	return d.saveInteractions()
+}
+
+// GetState loads the value stored under key for a user or chat
+func (d *Database) GetState(id int64, key string, value interface{}) (bool, error) {
+	d.mutex.RLock()
+	data, ok := d.state[id][key]
+	d.mutex.RUnlock()
+
+	if !ok {
+		return false, nil
+	}
+	if err := json.Unmarshal(data, value); err != nil {
+		return false, fmt.Errorf("failed to unmarshal state %q: %v", key, err)
+	}
+	return true, nil
+}
+
+// SetState stores value under key for a user or chat; a nil value deletes it
+func (d *Database) SetState(id int64, key string, value interface{}) error {
+	var data []byte
+	if value != nil {
+		var err error
+		if data, err = json.Marshal(value); err != nil {
+			return fmt.Errorf("failed to marshal state %q: %v", key, err)
+		}
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if data == nil {
+		delete(d.state[id], key)
+		if len(d.state[id]) == 0 {
+			delete(d.state, id)
+		}
+	} else {
+		if d.state[id] == nil {
+			d.state[id] = make(map[string]json.RawMessage)
 		}
-		d.interactions[userID] = validInteractions
+		d.state[id][key] = data
 	}
 
-	log.Printf("Cleaned up %d old interactions", totalRemoved)
 	return d.saveInteractions()
 }
@@ -0,0 +1,48 @@
+package database
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Retention removes interactions that are older than maxAge or beyond the newest maxPerUser of each user
+type Retention struct {
+	store      Store
+	maxAge     time.Duration
+	maxPerUser int
+}
+
+// NewRetention creates a retention policy for store. A zero limit is not applied.
+func NewRetention(store Store, maxAge time.Duration, maxPerUser int) *Retention {
+	return &Retention{
+		store:      store,
+		maxAge:     maxAge,
+		maxPerUser: maxPerUser,
+	}
+}
+
+// Apply removes the interactions outside the limits and returns how many were removed
+func (r *Retention) Apply() (int, error) {
+	return r.store.Cleanup(r.maxAge, r.maxPerUser)
+}
+
+// Run applies the limits right away and then every interval until ctx is done
+func (r *Retention) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if removed, err := r.Apply(); err != nil {
+			log.Printf("History retention failed: %v", err)
+		} else if removed > 0 {
+			log.Printf("History retention removed %d interactions", removed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
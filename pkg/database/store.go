@@ -0,0 +1,116 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"calendar-assistant-bot/pkg/types"
+)
+
+// Storage drivers
+const (
+	DriverBolt = "bolt"
+	DriverJSON = "json"
+)
+
+// Store persists AI interactions per user
+type Store interface {
+	// AddInteraction stores a new interaction. A zero timestamp is set to the current time.
+	AddInteraction(interaction types.Interaction) error
+	// GetUserInteractions retrieves the most recent interactions of a user, oldest first.
+	// A limit of 0 returns all of them.
+	GetUserInteractions(userID int64, limit int) []types.Interaction
+	// GetUserStats retrieves user interaction statistics
+	GetUserStats(userID int64) map[string]interface{}
+	// Cleanup removes interactions older than maxAge and all but the newest maxPerUser interactions
+	// of each user, and returns how many were removed. A zero limit is not applied.
+	Cleanup(maxAge time.Duration, maxPerUser int) (int, error)
+	// ExportUserData returns everything stored about a user
+	ExportUserData(userID int64) (*UserData, error)
+	// DeleteUserData removes everything stored about a user, including the state of their private chat
+	DeleteUserData(userID int64) error
+	// GetState loads the JSON value stored under key for a user or chat into value and reports whether it exists.
+	// User and private chat IDs are positive and group chat IDs are negative, so they don't collide;
+	// ID 0 holds bot-wide state.
+	GetState(id int64, key string, value interface{}) (bool, error)
+	// SetState stores value as JSON under key for a user or chat; a nil value deletes it
+	SetState(id int64, key string, value interface{}) error
+	// Backup writes a consistent copy of all data to backupPath
+	Backup(backupPath string) error
+	// CheckWritable verifies that the store accepts writes
+	CheckWritable() error
+	// Close flushes pending writes and releases the store
+	Close() error
+}
+
+// UserData is everything stored about a user
+type UserData struct {
+	UserID       int64                      `json:"user_id"`
+	ExportedAt   time.Time                  `json:"exported_at"`
+	Interactions []types.Interaction        `json:"interactions"`
+	State        map[string]json.RawMessage `json:"state"`
+}
+
+// Open opens the store for the given driver in dataDir. If keys is not nil, stored data is encrypted.
+func Open(driver, dataDir string, keys *Keyring) (Store, error) {
+	switch driver {
+	case DriverBolt:
+		return NewBoltStore(dataDir, keys)
+	case DriverJSON:
+		return NewDatabase(dataDir, keys)
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", driver)
+	}
+}
+
+// computeUserStats computes interaction statistics
+func computeUserStats(interactions []types.Interaction) map[string]interface{} {
+	stats := map[string]interface{}{
+		"total_interactions": len(interactions),
+		"first_interaction":  nil,
+		"last_interaction":   nil,
+		"actions_used":       make(map[string]int),
+	}
+
+	if len(interactions) > 0 {
+		stats["first_interaction"] = interactions[0].Timestamp
+		stats["last_interaction"] = interactions[len(interactions)-1].Timestamp
+
+		// Count actions
+		for _, interaction := range interactions {
+			if interaction.Action != "" {
+				stats["actions_used"].(map[string]int)[interaction.Action]++
+			}
+		}
+	}
+
+	return stats
+}
+
+// expiredInteractions returns the indexes of interactions, oldest first, that fall outside the retention limits
+func expiredInteractions(interactions []types.Interaction, cutoff time.Time, maxPerUser int) []int {
+	var expired []int
+	for i, interaction := range interactions {
+		overLimit := maxPerUser > 0 && i < len(interactions)-maxPerUser
+		tooOld := !cutoff.IsZero() && !interaction.Timestamp.After(cutoff)
+		if overLimit || tooOld {
+			expired = append(expired, i)
+		}
+	}
+	return expired
+}
+
+// retentionCutoff returns the time before which interactions are expired, or the zero time if maxAge is 0
+func retentionCutoff(maxAge time.Duration) time.Time {
+	if maxAge <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(-maxAge)
+}
+
+// logStoreError logs a read error for methods that can't return one
+func logStoreError(operation string, err error) {
+	log.Printf("Database error while %s: %v", operation, err)
+}
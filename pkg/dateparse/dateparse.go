@@ -0,0 +1,448 @@
+// Package dateparse parses the dates and times people write, such as "next Friday", "in 2 hours",
+// "the 3rd", "3pm" or "15h", without a language model. Expressions are resolved relative to a
+// reference time and in its location, so the same input and reference always give the same result.
+//
+// Dates without a year, days of the month and bare weekdays mean their next occurrence, today
+// included: on Wednesday, "Friday" is in two days and "Wednesday" is today, while "next Wednesday"
+// is in a week and "last Wednesday" a week ago.
+package dateparse
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrDate is returned for input that isn't a recognized date
+	ErrDate = errors.New("unrecognized date")
+	// ErrTime is returned for input that isn't a recognized time of day
+	ErrTime = errors.New("unrecognized time")
+	// ErrNoTime is returned by DateTime for a date without a time of day
+	ErrNoTime = errors.New("no time of day given")
+)
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "sun": time.Sunday,
+	"monday": time.Monday, "mon": time.Monday,
+	"tuesday": time.Tuesday, "tue": time.Tuesday, "tues": time.Tuesday,
+	"wednesday": time.Wednesday, "wed": time.Wednesday,
+	"thursday": time.Thursday, "thu": time.Thursday, "thur": time.Thursday, "thurs": time.Thursday,
+	"friday": time.Friday, "fri": time.Friday,
+	"saturday": time.Saturday, "sat": time.Saturday,
+}
+
+var months = map[string]time.Month{
+	"january": time.January, "jan": time.January,
+	"february": time.February, "feb": time.February,
+	"march": time.March, "mar": time.March,
+	"april": time.April, "apr": time.April,
+	"may": time.May, "june": time.June, "jun": time.June,
+	"july": time.July, "jul": time.July,
+	"august": time.August, "aug": time.August,
+	"september": time.September, "sep": time.September, "sept": time.September,
+	"october": time.October, "oct": time.October,
+	"november": time.November, "nov": time.November,
+	"december": time.December, "dec": time.December,
+}
+
+// numbers are the spelled-out counts accepted in relative expressions
+var numbers = map[string]int{
+	"a": 1, "an": 1, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5, "six": 6,
+	"seven": 7, "eight": 8, "nine": 9, "ten": 10, "eleven": 11, "twelve": 12,
+}
+
+// dayWords are the days named relative to today
+var dayWords = map[string]int{
+	"today": 0, "tonight": 0,
+	"tomorrow": 1, "tmrw": 1, "day after tomorrow": 2, "the day after tomorrow": 2,
+	"yesterday": -1, "day before yesterday": -2, "the day before yesterday": -2,
+}
+
+var (
+	isoDate     = regexp.MustCompile(`^(\d{4})[-/.](\d{1,2})[-/.](\d{1,2})$`)
+	dayOfMonth  = regexp.MustCompile(`^(the )?(\d{1,2})(st|nd|rd|th)?$`)
+	monthDay    = regexp.MustCompile(`^([a-z]+) (\d{1,2})(?:st|nd|rd|th)?(?: (\d{4}))?$`)
+	dayMonth    = regexp.MustCompile(`^(?:the )?(\d{1,2})(?:st|nd|rd|th)?(?: of)? ([a-z]+)(?: (\d{4}))?$`)
+	relativeIn  = regexp.MustCompile(`^in ([a-z0-9]+) ([a-z]+?)s?$`)
+	relativeAgo = regexp.MustCompile(`^([a-z0-9]+) ([a-z]+?)s? (ago|from now|from today|later)$`)
+	namedDay    = regexp.MustCompile(`^(?:(this|next|last|coming|past) )?([a-z]+)$`)
+	clock12     = regexp.MustCompile(`^(\d{1,2})(?:[:.h](\d{2}))? ?(am|pm)$`)
+	clock24     = regexp.MustCompile(`^(\d{1,2})[:.](\d{2})(?::(\d{2}))?$`)
+	clockH      = regexp.MustCompile(`^(\d{1,2})h(\d{2})?$`)
+	dateTimeT   = regexp.MustCompile(`(\d)t(\d)`)
+	spaces      = regexp.MustCompile(`\s+`)
+)
+
+// Date parses a date and returns midnight of that day in the location of now. It accepts
+// "today", "tomorrow", "yesterday", weekdays ("Friday", "next Fri", "last Monday"), "this week",
+// "next month", "in 3 days", "2 weeks ago", days of the month ("the 3rd"), month names
+// ("August 5", "5th of Aug 2025") and numeric dates ("2025-08-05", "2025-8-5", "2025/08/05").
+func Date(s string, now time.Time) (time.Time, error) {
+	if t, ok := parseTimestamp(s, now.Location()); ok {
+		return midnight(t), nil
+	}
+
+	text := strings.TrimPrefix(normalize(s), "on ")
+	if day, ok := parseDate(text, now); ok {
+		return day, nil
+	}
+	return time.Time{}, fmt.Errorf("%w: %q", ErrDate, s)
+}
+
+// Clock parses a time of day: "15:04", "15:04:05", "3pm", "3:30 pm", "3.30pm", "15h", "15h30",
+// "noon" or "midnight". Hours without minutes need am, pm or h, since "at 5" could be either.
+func Clock(s string) (hour, minute int, err error) {
+	text := strings.TrimPrefix(normalize(s), "at ")
+	if hour, minute, ok := parseClock(text); ok {
+		return hour, minute, nil
+	}
+	return 0, 0, fmt.Errorf("%w: %q", ErrTime, s)
+}
+
+// DateTime parses a date and a time of day, in either order and optionally joined by "at"
+// ("next Friday at 3pm", "3pm tomorrow", "2025-08-05 15:00"), a time alone, which is today, or
+// a time relative to now ("in 2 hours", "30 minutes ago"). A trailing time zone ("UTC",
+// "Europe/Paris") gives the time in that zone; the result is always in the location of now.
+// A date without a time returns an error wrapping ErrNoTime.
+func DateTime(s string, now time.Time) (time.Time, error) {
+	if t, ok := parseTimestamp(s, now.Location()); ok {
+		return t, nil
+	}
+
+	// A time zone only changes where the expression is resolved
+	location := now.Location()
+	if fields := strings.Fields(strings.TrimSpace(s)); len(fields) > 1 {
+		if zone, ok := parseZone(fields[len(fields)-1]); ok {
+			location = zone
+			s = strings.Join(fields[:len(fields)-1], " ")
+		}
+	}
+	local := now.In(location)
+
+	text := normalize(s)
+	if t, ok := parseRelativeTime(text, local); ok {
+		return t.In(now.Location()), nil
+	}
+
+	var tokens []string
+	for _, token := range strings.Fields(text) {
+		if token != "at" {
+			tokens = append(tokens, token)
+		}
+	}
+	if len(tokens) > 0 && tokens[0] == "on" {
+		tokens = tokens[1:]
+	}
+
+	// The time is the last or first one or two words, such as "3 pm"; the rest is the date
+	for _, timeFirst := range []bool{false, true} {
+		for n := 2; n >= 1; n-- {
+			if len(tokens) < n {
+				continue
+			}
+			clockTokens, dateTokens := tokens[len(tokens)-n:], tokens[:len(tokens)-n]
+			if timeFirst {
+				clockTokens, dateTokens = tokens[:n], tokens[n:]
+			}
+
+			hour, minute, ok := parseClock(strings.Join(clockTokens, " "))
+			if !ok {
+				continue
+			}
+			day := midnight(local)
+			if len(dateTokens) > 0 {
+				if day, ok = parseDate(strings.TrimPrefix(strings.Join(dateTokens, " "), "on "), local); !ok {
+					continue
+				}
+			}
+			t := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, location)
+			return t.In(now.Location()), nil
+		}
+	}
+
+	if _, ok := parseDate(strings.Join(tokens, " "), local); ok {
+		return time.Time{}, fmt.Errorf("%w: %q", ErrNoTime, s)
+	}
+	return time.Time{}, fmt.Errorf("%w: %q", ErrDate, s)
+}
+
+// normalize lowercases s and removes commas and repeated spaces
+func normalize(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.NewReplacer(",", " ", "a.m.", "am", "p.m.", "pm").Replace(s)
+	s = dateTimeT.ReplaceAllString(s, "$1 $2")
+	return strings.TrimSpace(spaces.ReplaceAllString(s, " "))
+}
+
+// parseTimestamp parses an RFC 3339 timestamp, which carries its own offset
+func parseTimestamp(s string, location *time.Location) (time.Time, bool) {
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(s))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t.In(location), true
+}
+
+// parseZone parses a time zone name such as "UTC" or "America/New_York"
+func parseZone(name string) (*time.Location, bool) {
+	switch strings.ToLower(name) {
+	case "utc", "gmt", "z":
+		return time.UTC, true
+	}
+	if !strings.Contains(name, "/") {
+		return nil, false
+	}
+	location, err := time.LoadLocation(name)
+	return location, err == nil
+}
+
+// parseDate parses a normalized date expression
+func parseDate(text string, now time.Time) (time.Time, bool) {
+	today := midnight(now)
+	if text == "" {
+		return time.Time{}, false
+	}
+
+	if offset, ok := dayWords[text]; ok {
+		return today.AddDate(0, 0, offset), true
+	}
+
+	if m := isoDate.FindStringSubmatch(text); m != nil {
+		return validDate(atoi(m[1]), atoi(m[2]), atoi(m[3]), now.Location())
+	}
+
+	if day, ok := parseRelativeDate(text, today); ok {
+		return day, true
+	}
+
+	if m := namedDay.FindStringSubmatch(text); m != nil {
+		if day, ok := parseNamedDay(m[1], m[2], today); ok {
+			return day, true
+		}
+	}
+
+	if m := dayOfMonth.FindStringSubmatch(text); m != nil && (m[1] != "" || m[3] != "") {
+		return nextDayOfMonth(atoi(m[2]), today)
+	}
+
+	if m := monthDay.FindStringSubmatch(text); m != nil {
+		if month, ok := months[m[1]]; ok {
+			return monthDate(month, atoi(m[2]), m[3], today)
+		}
+	}
+	if m := dayMonth.FindStringSubmatch(text); m != nil {
+		if month, ok := months[m[2]]; ok {
+			return monthDate(month, atoi(m[1]), m[3], today)
+		}
+	}
+
+	// A weekday before a date, as in "Friday, August 8", is redundant
+	if first, rest, found := strings.Cut(text, " "); found {
+		if _, ok := weekdays[first]; ok {
+			return parseDate(rest, now)
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseRelativeDate parses "in 3 days", "2 weeks ago" or "a month from now"
+func parseRelativeDate(text string, today time.Time) (time.Time, bool) {
+	var count int
+	var unit string
+	if m := relativeIn.FindStringSubmatch(text); m != nil {
+		count, unit = parseCount(m[1]), m[2]
+	} else if m := relativeAgo.FindStringSubmatch(text); m != nil {
+		count, unit = parseCount(m[1]), m[2]
+		if m[3] == "ago" {
+			count = -count
+		}
+	} else {
+		return time.Time{}, false
+	}
+	if count == 0 {
+		return time.Time{}, false
+	}
+
+	switch unit {
+	case "day":
+		return today.AddDate(0, 0, count), true
+	case "week":
+		return today.AddDate(0, 0, 7*count), true
+	case "month":
+		return today.AddDate(0, count, 0), true
+	case "year":
+		return today.AddDate(count, 0, 0), true
+	}
+	return time.Time{}, false
+}
+
+// parseNamedDay parses a weekday or "week", "month" or "year" after an optional modifier
+func parseNamedDay(modifier, name string, today time.Time) (time.Time, bool) {
+	if weekday, ok := weekdays[name]; ok {
+		ahead := (int(weekday) - int(today.Weekday()) + 7) % 7
+		switch modifier {
+		case "next":
+			if ahead == 0 {
+				ahead = 7
+			}
+		case "last", "past":
+			ahead -= 7
+		}
+		return today.AddDate(0, 0, ahead), true
+	}
+
+	step := map[string]int{"this": 0, "coming": 1, "next": 1, "last": -1, "past": -1}
+	offset, ok := step[modifier]
+	if !ok {
+		return time.Time{}, false
+	}
+	switch name {
+	case "week":
+		monday := today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+		return monday.AddDate(0, 0, 7*offset), true
+	case "month":
+		return time.Date(today.Year(), today.Month()+time.Month(offset), 1, 0, 0, 0, 0, today.Location()), true
+	case "year":
+		return time.Date(today.Year()+offset, time.January, 1, 0, 0, 0, 0, today.Location()), true
+	}
+	return time.Time{}, false
+}
+
+// nextDayOfMonth returns the next day numbered day, today included, skipping months too short for it
+func nextDayOfMonth(day int, today time.Time) (time.Time, bool) {
+	if day < 1 || day > 31 {
+		return time.Time{}, false
+	}
+	for i := 0; i < 12; i++ {
+		first := time.Date(today.Year(), today.Month()+time.Month(i), 1, 0, 0, 0, 0, today.Location())
+		if date, ok := validDate(first.Year(), int(first.Month()), day, today.Location()); ok && !date.Before(today) {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// monthDate returns a day of a month, in the given year or, without one, the next occurrence
+func monthDate(month time.Month, day int, year string, today time.Time) (time.Time, bool) {
+	if year != "" {
+		return validDate(atoi(year), int(month), day, today.Location())
+	}
+	// February 29 may be several years ahead
+	for y := today.Year(); y <= today.Year()+8; y++ {
+		if date, ok := validDate(y, int(month), day, today.Location()); ok && !date.Before(today) {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseRelativeTime parses "in 2 hours", "in half an hour" or "30 minutes ago"
+func parseRelativeTime(text string, now time.Time) (time.Time, bool) {
+	if text == "in half an hour" {
+		return now.Add(30 * time.Minute).Truncate(time.Minute), true
+	}
+
+	var count int
+	var unit string
+	if m := relativeIn.FindStringSubmatch(text); m != nil {
+		count, unit = parseCount(m[1]), m[2]
+	} else if m := relativeAgo.FindStringSubmatch(text); m != nil {
+		count, unit = parseCount(m[1]), m[2]
+		if m[3] == "ago" {
+			count = -count
+		}
+	} else {
+		return time.Time{}, false
+	}
+	if count == 0 {
+		return time.Time{}, false
+	}
+
+	var d time.Duration
+	switch unit {
+	case "minute", "min":
+		d = time.Minute
+	case "hour", "hr":
+		d = time.Hour
+	default:
+		return time.Time{}, false
+	}
+	return now.Add(time.Duration(count) * d).Truncate(time.Minute), true
+}
+
+// parseClock parses a normalized time of day
+func parseClock(text string) (int, int, bool) {
+	switch text {
+	case "noon", "midday":
+		return 12, 0, true
+	case "midnight":
+		return 0, 0, true
+	}
+
+	if m := clock12.FindStringSubmatch(text); m != nil {
+		hour, minute := atoi(m[1]), atoi(m[2])
+		if hour < 1 || hour > 12 || minute > 59 {
+			return 0, 0, false
+		}
+		hour %= 12
+		if m[3] == "pm" {
+			hour += 12
+		}
+		return hour, minute, true
+	}
+
+	var hour, minute int
+	if m := clock24.FindStringSubmatch(text); m != nil {
+		hour, minute = atoi(m[1]), atoi(m[2])
+		if m[3] != "" && atoi(m[3]) > 59 {
+			return 0, 0, false
+		}
+	} else if m := clockH.FindStringSubmatch(text); m != nil {
+		hour, minute = atoi(m[1]), atoi(m[2])
+	} else {
+		return 0, 0, false
+	}
+	if hour > 23 || minute > 59 {
+		return 0, 0, false
+	}
+	return hour, minute, true
+}
+
+// parseCount parses a count written as digits or a word; 0 means it isn't one
+func parseCount(s string) int {
+	if n, ok := numbers[s]; ok {
+		return n
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// validDate returns the date, or false if the day doesn't exist in that month
+func validDate(year, month, day int, location *time.Location) (time.Time, bool) {
+	if month < 1 || month > 12 || day < 1 {
+		return time.Time{}, false
+	}
+	date := time.Date(year, time.Month(month), day, 0, 0, 0, 0, location)
+	if date.Day() != day {
+		return time.Time{}, false
+	}
+	return date, true
+}
+
+// midnight returns the start of the day of t, in its location
+func midnight(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// atoi converts a matched number; empty groups are 0
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
@@ -0,0 +1,272 @@
+package dateparse
+
+import (
+	"errors"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+// now is Wednesday, August 6, 2025, 10:30 UTC
+var now = time.Date(2025, time.August, 6, 10, 30, 0, 0, time.UTC)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestDate(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want time.Time
+	}{
+		{"today", "today", date(2025, time.August, 6)},
+		{"tonight", "Tonight", date(2025, time.August, 6)},
+		{"tomorrow", "tomorrow", date(2025, time.August, 7)},
+		{"yesterday", "Yesterday", date(2025, time.August, 5)},
+		{"day after tomorrow", "the day after tomorrow", date(2025, time.August, 8)},
+		{"day before yesterday", "day before yesterday", date(2025, time.August, 4)},
+		{"later weekday", "Friday", date(2025, time.August, 8)},
+		{"same weekday", "wednesday", date(2025, time.August, 6)},
+		{"earlier weekday", "Mon", date(2025, time.August, 11)},
+		{"this weekday", "this monday", date(2025, time.August, 11)},
+		{"next weekday", "next Friday", date(2025, time.August, 8)},
+		{"next same weekday", "next Wednesday", date(2025, time.August, 13)},
+		{"last weekday", "last friday", date(2025, time.August, 1)},
+		{"last same weekday", "last wed", date(2025, time.July, 30)},
+		{"this week", "this week", date(2025, time.August, 4)},
+		{"next week", "next week", date(2025, time.August, 11)},
+		{"last week", "last week", date(2025, time.July, 28)},
+		{"next month", "next month", date(2025, time.September, 1)},
+		{"this year", "this year", date(2025, time.January, 1)},
+		{"next year", "next year", date(2026, time.January, 1)},
+		{"in days", "in 3 days", date(2025, time.August, 9)},
+		{"in a week", "in a week", date(2025, time.August, 13)},
+		{"in words", "in one month", date(2025, time.September, 6)},
+		{"weeks ago", "2 weeks ago", date(2025, time.July, 23)},
+		{"from now", "a year from now", date(2026, time.August, 6)},
+		{"later", "three days later", date(2025, time.August, 9)},
+		{"day of month ahead", "the 21st", date(2025, time.August, 21)},
+		{"day of month today", "6th", date(2025, time.August, 6)},
+		{"day of month passed", "the 3rd", date(2025, time.September, 3)},
+		{"day of month with on", "on the 31st", date(2025, time.August, 31)},
+		{"month day", "Aug 6th", date(2025, time.August, 6)},
+		{"month day passed", "August 5", date(2026, time.August, 5)},
+		{"month day with year", "December 25, 2025", date(2025, time.December, 25)},
+		{"day month", "5th of August 2025", date(2025, time.August, 5)},
+		{"day month short", "1 sept", date(2025, time.September, 1)},
+		{"weekday and date", "Friday, August 8", date(2025, time.August, 8)},
+		{"leap day", "Feb 29", date(2028, time.February, 29)},
+		{"iso", "2025-08-05", date(2025, time.August, 5)},
+		{"iso unpadded", "2025-8-5", date(2025, time.August, 5)},
+		{"iso slashes", "2025/08/05", date(2025, time.August, 5)},
+		{"rfc 3339", "2025-08-05T23:30:00-04:00", date(2025, time.August, 6)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Date(tt.text, now)
+			if err != nil {
+				t.Fatalf("Date(%q) returned error: %v", tt.text, err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("Date(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDateErrors(t *testing.T) {
+	tests := []string{"", "someday", "the 32nd", "5", "2025-13-01", "2025-02-29", "in 0 days", "February 30", "next blursday"}
+
+	for _, text := range tests {
+		t.Run(text, func(t *testing.T) {
+			if got, err := Date(text, now); !errors.Is(err, ErrDate) {
+				t.Errorf("Date(%q) = %v, %v, want ErrDate", text, got, err)
+			}
+		})
+	}
+}
+
+func TestDateSkipsShortMonths(t *testing.T) {
+	september := time.Date(2025, time.September, 2, 9, 0, 0, 0, time.UTC)
+	got, err := Date("the 31st", september)
+	if err != nil {
+		t.Fatalf("Date returned error: %v", err)
+	}
+	if want := date(2025, time.October, 31); !got.Equal(want) {
+		t.Errorf("Date(%q) = %v, want %v", "the 31st", got, want)
+	}
+}
+
+func TestClock(t *testing.T) {
+	tests := []struct {
+		name       string
+		text       string
+		wantHour   int
+		wantMinute int
+	}{
+		{"24 hour", "15:04", 15, 4},
+		{"with seconds", "15:04:05", 15, 4},
+		{"single digit hour", "9:05", 9, 5},
+		{"pm", "3pm", 15, 0},
+		{"pm upper case with space", "3 PM", 15, 0},
+		{"pm with minutes", "3:30 pm", 15, 30},
+		{"pm with dot", "3.30pm", 15, 30},
+		{"pm with periods", "3 p.m.", 15, 0},
+		{"am", "9am", 9, 0},
+		{"midnight am", "12am", 0, 0},
+		{"noon pm", "12pm", 12, 0},
+		{"h", "15h", 15, 0},
+		{"h with minutes", "15h30", 15, 30},
+		{"noon", "noon", 12, 0},
+		{"midday", "Midday", 12, 0},
+		{"midnight", "midnight", 0, 0},
+		{"at", "at 9am", 9, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hour, minute, err := Clock(tt.text)
+			if err != nil {
+				t.Fatalf("Clock(%q) returned error: %v", tt.text, err)
+			}
+			if hour != tt.wantHour || minute != tt.wantMinute {
+				t.Errorf("Clock(%q) = %d:%02d, want %d:%02d", tt.text, hour, minute, tt.wantHour, tt.wantMinute)
+			}
+		})
+	}
+}
+
+func TestClockErrors(t *testing.T) {
+	tests := []string{"", "5", "at 5", "13pm", "0am", "24:00", "15:60", "15:04:60", "25h", "teatime"}
+
+	for _, text := range tests {
+		t.Run(text, func(t *testing.T) {
+			if hour, minute, err := Clock(text); !errors.Is(err, ErrTime) {
+				t.Errorf("Clock(%q) = %d:%02d, %v, want ErrTime", text, hour, minute, err)
+			}
+		})
+	}
+}
+
+func TestDateTime(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want time.Time
+	}{
+		{"weekday at time", "next Friday at 3pm", time.Date(2025, time.August, 8, 15, 0, 0, 0, time.UTC)},
+		{"time before date", "3pm tomorrow", time.Date(2025, time.August, 7, 15, 0, 0, 0, time.UTC)},
+		{"h time", "tomorrow at 15h", time.Date(2025, time.August, 7, 15, 0, 0, 0, time.UTC)},
+		{"two word time", "August 5 at 3:30 pm", time.Date(2026, time.August, 5, 15, 30, 0, 0, time.UTC)},
+		{"day of month", "on the 3rd at 9am", time.Date(2025, time.September, 3, 9, 0, 0, 0, time.UTC)},
+		{"iso unpadded", "2025-8-5 3pm", time.Date(2025, time.August, 5, 15, 0, 0, 0, time.UTC)},
+		{"iso", "2025-08-05 15:04", time.Date(2025, time.August, 5, 15, 4, 0, 0, time.UTC)},
+		{"iso with T", "2025-08-05T15:04", time.Date(2025, time.August, 5, 15, 4, 0, 0, time.UTC)},
+		{"rfc 3339", "2025-08-05T15:04:00+02:00", time.Date(2025, time.August, 5, 13, 4, 0, 0, time.UTC)},
+		{"time only", "noon", time.Date(2025, time.August, 6, 12, 0, 0, 0, time.UTC)},
+		{"in hours", "in 2 hours", time.Date(2025, time.August, 6, 12, 30, 0, 0, time.UTC)},
+		{"in minutes", "in 45 mins", time.Date(2025, time.August, 6, 11, 15, 0, 0, time.UTC)},
+		{"in an hour", "in an hour", time.Date(2025, time.August, 6, 11, 30, 0, 0, time.UTC)},
+		{"in half an hour", "in half an hour", time.Date(2025, time.August, 6, 11, 0, 0, 0, time.UTC)},
+		{"minutes ago", "30 minutes ago", time.Date(2025, time.August, 6, 10, 0, 0, 0, time.UTC)},
+		{"zone", "tomorrow 3pm Europe/Paris", time.Date(2025, time.August, 7, 13, 0, 0, 0, time.UTC)},
+		{"utc", "9am UTC", time.Date(2025, time.August, 6, 9, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DateTime(tt.text, now)
+			if err != nil {
+				t.Fatalf("DateTime(%q) returned error: %v", tt.text, err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("DateTime(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDateTimeErrors(t *testing.T) {
+	tests := []struct {
+		text string
+		want error
+	}{
+		{"tomorrow", ErrNoTime},
+		{"in 2 days", ErrNoTime},
+		{"next Friday at 5", ErrDate},
+		{"next blursday at 3pm", ErrDate},
+		{"", ErrDate},
+		{"3pm Mars/Olympus", ErrDate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got, err := DateTime(tt.text, now); !errors.Is(err, tt.want) {
+				t.Errorf("DateTime(%q) = %v, %v, want %v", tt.text, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocation(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("LoadLocation: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		now  time.Time
+		text string
+		want time.Time
+	}{
+		{
+			// 02:00 UTC on August 6 is still August 5 in New York
+			name: "today is local",
+			now:  time.Date(2025, time.August, 5, 22, 0, 0, 0, newYork),
+			text: "tomorrow 9am",
+			want: time.Date(2025, time.August, 6, 9, 0, 0, 0, newYork),
+		},
+		{
+			name: "zone converted to location",
+			now:  time.Date(2025, time.August, 6, 8, 0, 0, 0, newYork),
+			text: "3pm Europe/Paris",
+			want: time.Date(2025, time.August, 6, 9, 0, 0, 0, newYork),
+		},
+		{
+			name: "across daylight saving start",
+			now:  time.Date(2025, time.March, 8, 12, 0, 0, 0, newYork),
+			text: "tomorrow 9am",
+			want: time.Date(2025, time.March, 9, 9, 0, 0, 0, newYork),
+		},
+		{
+			name: "relative across daylight saving start",
+			now:  time.Date(2025, time.March, 9, 1, 30, 0, 0, newYork),
+			text: "in 2 hours",
+			want: time.Date(2025, time.March, 9, 4, 30, 0, 0, newYork),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DateTime(tt.text, tt.now)
+			if err != nil {
+				t.Fatalf("DateTime(%q) returned error: %v", tt.text, err)
+			}
+			if !got.Equal(tt.want) || got.Location() != newYork {
+				t.Errorf("DateTime(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+
+	// Days start at local midnight, which is 23 hours after the previous one on March 9
+	got, err := Date("in 1 day", time.Date(2025, time.March, 9, 12, 0, 0, 0, newYork))
+	if err != nil {
+		t.Fatalf("Date returned error: %v", err)
+	}
+	if want := time.Date(2025, time.March, 10, 0, 0, 0, 0, newYork); !got.Equal(want) {
+		t.Errorf("Date(%q) = %v, want %v", "in 1 day", got, want)
+	}
+}
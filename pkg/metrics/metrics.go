@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "calendar_bot"
+
+var (
+	// MessagesReceived counts messages received from Telegram users
+	MessagesReceived = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_received_total",
+		Help:      "Number of messages received from Telegram.",
+	})
+
+	// MessagesSent counts messages sent to Telegram, by status
+	MessagesSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_sent_total",
+		Help:      "Number of messages sent to Telegram.",
+	}, []string{"status"})
+
+	// ActionsTotal counts executed agent actions, by action and status
+	ActionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "actions_total",
+		Help:      "Number of agent actions executed.",
+	}, []string{"action", "status"})
+
+	// LLMRequestDuration tracks the latency of LLM requests, by status
+	LLMRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "llm_request_duration_seconds",
+		Help:      "Latency of LLM requests.",
+		Buckets:   []float64{0.25, 0.5, 1, 2, 4, 8, 16, 32},
+	}, []string{"status"})
+
+	// LLMTokens counts tokens used by LLM requests, by type (prompt or completion)
+	LLMTokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "llm_tokens_total",
+		Help:      "Number of tokens used by LLM requests.",
+	}, []string{"type"})
+
+	// LLMRepairs counts LLM responses sent back to the model because they failed validation
+	LLMRepairs = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "llm_repairs_total",
+		Help:      "Number of LLM responses sent back for failing validation.",
+	})
+
+	// CalendarRequestDuration tracks the latency of calendar API requests, by operation and status
+	CalendarRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "calendar_request_duration_seconds",
+		Help:      "Latency of calendar API requests.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "status"})
+
+	// UpstreamRetries counts retried calls to upstream services, by upstream
+	UpstreamRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_retries_total",
+		Help:      "Number of retried calls to upstream services.",
+	}, []string{"upstream"})
+
+	// CircuitOpen is 1 while the circuit breaker of an upstream is open, by upstream
+	CircuitOpen = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "circuit_open",
+		Help:      "Whether the circuit breaker of an upstream is open.",
+	}, []string{"upstream"})
+
+	// HandlersInFlight tracks the number of updates currently being handled
+	HandlersInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "handlers_in_flight",
+		Help:      "Number of updates currently being handled.",
+	})
+)
+
+// Status returns the status label for an operation result
+func Status(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// ObserveCalendarRequest records the latency and outcome of a calendar API request
+func ObserveCalendarRequest(operation string, start time.Time, err error) {
+	CalendarRequestDuration.WithLabelValues(operation, Status(err)).Observe(time.Since(start).Seconds())
+}
+
+// Handler returns the HTTP handler serving metrics in the Prometheus format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
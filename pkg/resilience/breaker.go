@@ -0,0 +1,105 @@
+package resilience
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"calendar-assistant-bot/pkg/metrics"
+)
+
+// ErrCircuitOpen is returned while a circuit breaker is rejecting calls
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// Breaker states
+const (
+	stateClosed = iota
+	stateOpen
+	stateHalfOpen
+)
+
+// Breaker stops calls to an upstream after repeated failures and lets a single
+// probe call through once the cooldown has passed
+type Breaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+
+	mutex    sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewBreaker creates a breaker that opens after threshold consecutive failures
+// and stays open for cooldown
+func NewBreaker(name string, threshold int, cooldown time.Duration) *Breaker {
+	metrics.CircuitOpen.WithLabelValues(name).Set(0)
+	return &Breaker{
+		name:      name,
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// allow reports whether a call may proceed
+func (b *Breaker) allow() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case stateOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return ErrCircuitOpen
+		}
+		log.Printf("Circuit breaker %s half-open, probing upstream", b.name)
+		b.state = stateHalfOpen
+		b.probing = true
+		return nil
+	case stateHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+		return nil
+	}
+	return nil
+}
+
+// record registers the outcome of an allowed call
+func (b *Breaker) record(success bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.probing = false
+
+	if success {
+		if b.state != stateClosed {
+			log.Printf("Circuit breaker %s closed, upstream recovered", b.name)
+			metrics.CircuitOpen.WithLabelValues(b.name).Set(0)
+		}
+		b.state = stateClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == stateHalfOpen || b.failures >= b.threshold {
+		if b.state != stateOpen {
+			log.Printf("Circuit breaker %s opened after %d consecutive failures", b.name, b.failures)
+			metrics.CircuitOpen.WithLabelValues(b.name).Set(1)
+		}
+		b.state = stateOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// abort releases an allowed call without recording an outcome
+func (b *Breaker) abort() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.probing = false
+}
@@ -0,0 +1,208 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"calendar-assistant-bot/pkg/metrics"
+)
+
+// Policy configures how failed calls are retried
+type Policy struct {
+	// MaxAttempts is the total number of attempts, including the first one
+	MaxAttempts int
+	// BaseDelay is the backoff before the second attempt; it doubles on every further attempt
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff. A Retry-After longer than this is not waited for.
+	MaxDelay time.Duration
+}
+
+// DefaultPolicy retries up to three times with backoff starting at 500ms
+var DefaultPolicy = Policy{
+	MaxAttempts: 4,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    10 * time.Second,
+}
+
+// Classifier reports whether err is worth retrying and how long the upstream asked us to wait (zero if it didn't)
+type Classifier func(err error) (retryable bool, retryAfter time.Duration)
+
+// StatusError is an HTTP error status returned by an upstream
+type StatusError struct {
+	Code       int
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("upstream returned HTTP %d", e.Code)
+}
+
+// UnavailableError reports that an upstream could not be reached after retrying, or that its circuit is open
+type UnavailableError struct {
+	Upstream string
+	Err      error
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("%s temporarily unavailable: %v", e.Upstream, e.Err)
+}
+
+func (e *UnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// IsUnavailable reports whether err means the upstream is temporarily unavailable
+func IsUnavailable(err error) bool {
+	var unavailable *UnavailableError
+	return errors.As(err, &unavailable)
+}
+
+// Client runs calls to a single upstream with retries and a circuit breaker
+type Client struct {
+	name     string
+	policy   Policy
+	breaker  *Breaker
+	classify Classifier
+}
+
+// NewClient creates a client for the named upstream. If classify is nil, DefaultClassifier is used.
+func NewClient(name string, policy Policy, breaker *Breaker, classify Classifier) *Client {
+	if classify == nil {
+		classify = DefaultClassifier
+	}
+	return &Client{
+		name:     name,
+		policy:   policy,
+		breaker:  breaker,
+		classify: classify,
+	}
+}
+
+// Do calls fn until it succeeds, fails with a non-retryable error, or the attempts run out.
+// Retryable failures count against the circuit breaker; while it is open, Do fails fast.
+// If the upstream stays unavailable, the returned error is an *UnavailableError.
+func (c *Client) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	var lastErr error
+
+	for attempt := 1; ; attempt++ {
+		if err := c.breaker.allow(); err != nil {
+			if lastErr == nil {
+				lastErr = err
+			}
+			return &UnavailableError{Upstream: c.name, Err: lastErr}
+		}
+
+		err := fn(ctx)
+		if err == nil {
+			c.breaker.record(true)
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			// The caller gave up; this says nothing about the upstream
+			c.breaker.abort()
+			return err
+		}
+
+		retryable, retryAfter := c.classify(err)
+		if !retryable {
+			// The upstream answered, it just didn't like the request
+			c.breaker.record(true)
+			return err
+		}
+
+		c.breaker.record(false)
+		lastErr = err
+
+		if attempt >= c.policy.MaxAttempts {
+			return &UnavailableError{Upstream: c.name, Err: lastErr}
+		}
+
+		delay := c.policy.backoff(attempt)
+		if retryAfter > 0 {
+			if retryAfter > c.policy.MaxDelay {
+				log.Printf("%s asked to retry after %s, which is longer than we wait", c.name, retryAfter)
+				return &UnavailableError{Upstream: c.name, Err: lastErr}
+			}
+			delay = retryAfter
+		}
+
+		log.Printf("%s call failed (attempt %d/%d), retrying in %s: %v", c.name, attempt, c.policy.MaxAttempts, delay, err)
+		metrics.UpstreamRetries.WithLabelValues(c.name).Inc()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+// backoff returns the jittered exponential delay before the attempt following the given one
+func (p Policy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay << (attempt - 1)
+	if delay <= 0 || delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	// Equal jitter: keep half of the delay and randomize the rest
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// RetryableStatus reports whether an HTTP status code indicates a transient upstream problem
+func RetryableStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests,
+		http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// ParseRetryAfter parses a Retry-After header value, given either in seconds or as an HTTP date
+func ParseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
+
+// DefaultClassifier retries retryable HTTP statuses and network errors
+func DefaultClassifier(err error) (bool, time.Duration) {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return RetryableStatus(statusErr.Code), statusErr.RetryAfter
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true, 0
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, context.DeadlineExceeded) {
+		// A per-attempt timeout expired while the caller's context is still alive
+		return true, 0
+	}
+
+	return false, 0
+}
@@ -0,0 +1,63 @@
+package resilience
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Transport is an http.RoundTripper that retries requests through a Client.
+// It is meant for SDKs that hide response headers such as Retry-After from their errors.
+type Transport struct {
+	client *Client
+	base   http.RoundTripper
+}
+
+// NewTransport wraps base so that every request goes through client. If base is nil, http.DefaultTransport is used.
+func NewTransport(client *Client, base http.RoundTripper) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{client: client, base: base}
+}
+
+// RoundTrip sends the request, retrying on network errors and retryable statuses
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		// The body can't be replayed, so don't retry
+		return t.base.RoundTrip(req)
+	}
+
+	var resp *http.Response
+	err := t.client.Do(req.Context(), func(ctx context.Context) error {
+		attempt := req.Clone(ctx)
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return fmt.Errorf("failed to rewind request body: %v", err)
+			}
+			attempt.Body = body
+		}
+
+		r, err := t.base.RoundTrip(attempt)
+		if err != nil {
+			return err
+		}
+
+		if RetryableStatus(r.StatusCode) {
+			retryAfter := ParseRetryAfter(r.Header.Get("Retry-After"))
+			_, _ = io.Copy(io.Discard, r.Body)
+			r.Body.Close()
+			return &StatusError{Code: r.StatusCode, RetryAfter: retryAfter}
+		}
+
+		resp = r
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Check reports whether a dependency is usable
+type Check func(ctx context.Context) error
+
+// checkTimeout bounds how long a single readiness check may take
+const checkTimeout = 5 * time.Second
+
+// HealthHandler returns a liveness handler that reports the process is up
+func HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok\n"))
+	})
+}
+
+// ReadinessHandler returns a handler that runs all checks concurrently and
+// responds 200 only if every check passes, 503 otherwise
+func ReadinessHandler(checks map[string]Check) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+		defer cancel()
+
+		var mutex sync.Mutex
+		var wg sync.WaitGroup
+		results := make(map[string]string, len(checks))
+		ready := true
+
+		for name, check := range checks {
+			wg.Add(1)
+			go func(name string, check Check) {
+				defer wg.Done()
+
+				result := "ok"
+				if err := check(ctx); err != nil {
+					log.Printf("Readiness check %s failed: %v", name, err)
+					result = err.Error()
+				}
+
+				mutex.Lock()
+				defer mutex.Unlock()
+				results[name] = result
+				if result != "ok" {
+					ready = false
+				}
+			}(name, check)
+		}
+		wg.Wait()
+
+		status := http.StatusOK
+		body := map[string]interface{}{"status": "ready", "checks": results}
+		if !ready {
+			status = http.StatusServiceUnavailable
+			body["status"] = "not ready"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(body)
+	})
+}
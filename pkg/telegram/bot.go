@@ -1,17 +1,17 @@
 package telegram
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"strings"
-	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
 // Bot handles all Telegram bot interactions
 type Bot struct {
-	bot *tgbotapi.BotAPI
+	bot    *tgbotapi.BotAPI
+	outbox *outbox
 }
 
 // NewBot creates a new Telegram bot instance
@@ -22,84 +22,75 @@ func NewBot(token string) (*Bot, error) {
 	}
 
 	log.Printf("Telegram bot created successfully: %s", bot.Self.UserName)
-	return &Bot{bot: bot}, nil
+	return &Bot{bot: bot, outbox: newOutbox(bot)}, nil
 }
 
-// SendMessage sends a message to a specific chat
-func (t *Bot) SendMessage(chatID int64, text string) error {
+// SendMessage sends a message to a specific chat.
+// Messages go through the outbound queue, which keeps the bot within Telegram's send limits
+// and preserves the order of messages per chat. Nothing is sent once ctx is done.
+func (t *Bot) SendMessage(ctx context.Context, chatID int64, text string) error {
 	log.Printf("Sending message to chat %d: %s", chatID, text)
 
-	// Check if message is too long for Telegram (max 4096 characters)
-	if len(text) > 4096 {
-		log.Printf("Message too long (%d chars), splitting into multiple messages", len(text))
-		return t.sendLongMessage(chatID, text)
+	// Check if message is too long for Telegram (max 4096 UTF-16 code units)
+	if length := UTF16Len(text); length > MaxMessageLength {
+		log.Printf("Message too long (%d UTF-16 units), splitting into multiple messages", length)
+		return t.sendLongMessage(ctx, chatID, text)
 	}
 
 	msg := tgbotapi.NewMessage(chatID, text)
-	_, err := t.bot.Send(msg)
-	if err != nil {
+	if err := t.outbox.send(ctx, chatID, msg); err != nil {
 		return fmt.Errorf("failed to send message: %v", err)
 	}
 	return nil
 }
 
-// sendLongMessage splits a long message into multiple parts and sends them
-func (t *Bot) sendLongMessage(chatID int64, text string) error {
-	const maxLength = 4000 // Leave some buffer for safety
-
-	// Split the message into chunks
-	var messages []string
-	start := 0
+// continuationMarker is appended to every part of a split message except the last
+const continuationMarker = "\n\n[Message continued...]"
 
-	for start < len(text) {
-		end := start + maxLength
-		if end > len(text) {
-			end = len(text)
-		}
-
-		// Try to break at a newline if possible
-		if end < len(text) {
-			lastNewline := strings.LastIndex(text[start:end], "\n")
-			if lastNewline > 0 && lastNewline > maxLength/2 {
-				end = start + lastNewline + 1
-			}
+// sendLongMessage splits a long message into multiple parts and sends them
+func (t *Bot) sendLongMessage(ctx context.Context, chatID int64, text string) error {
+	// Leave room for the continuation marker in every part
+	parts := SplitMessage(text, nil, MaxMessageLength-UTF16Len(continuationMarker))
+
+	messages := make([]tgbotapi.Chattable, len(parts))
+	for i, part := range parts {
+		msg := tgbotapi.NewMessage(chatID, part.Text)
+		msg.Entities = part.Entities
+		if i < len(parts)-1 {
+			msg.Text += continuationMarker
 		}
-
-		messages = append(messages, text[start:end])
-		start = end
+		messages[i] = msg
 	}
 
-	// Send each chunk
-	for i, message := range messages {
-		msg := tgbotapi.NewMessage(chatID, message)
-		if i < len(messages)-1 {
-			msg.Text += "\n\n[Message continued...]"
-		}
-
-		_, err := t.bot.Send(msg)
-		if err != nil {
-			return fmt.Errorf("failed to send message part %d/%d: %v", i+1, len(messages), err)
-		}
-
-		// Small delay between messages to avoid rate limiting
-		time.Sleep(100 * time.Millisecond)
+	// The parts are queued together so no other message to the chat lands between them
+	if err := t.outbox.send(ctx, chatID, messages...); err != nil {
+		return fmt.Errorf("failed to send long message: %v", err)
 	}
 
-	log.Printf("Successfully sent long message in %d parts", len(messages))
+	log.Printf("Successfully sent long message in %d parts", len(parts))
 	return nil
 }
 
 // SendMessageWithKeyboard sends a message with an inline keyboard
-func (t *Bot) SendMessageWithKeyboard(chatID int64, text string, keyboard tgbotapi.InlineKeyboardMarkup) error {
+func (t *Bot) SendMessageWithKeyboard(ctx context.Context, chatID int64, text string, keyboard tgbotapi.InlineKeyboardMarkup) error {
 	msg := tgbotapi.NewMessage(chatID, text)
 	msg.ReplyMarkup = keyboard
-	_, err := t.bot.Send(msg)
-	if err != nil {
+	if err := t.outbox.send(ctx, chatID, msg); err != nil {
 		return fmt.Errorf("failed to send message with keyboard: %v", err)
 	}
 	return nil
 }
 
+// SendDocument sends data as a file named fileName, with an optional caption
+func (t *Bot) SendDocument(ctx context.Context, chatID int64, fileName string, data []byte, caption string) error {
+	doc := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{Name: fileName, Bytes: data})
+	doc.Caption = caption
+	if err := t.outbox.send(ctx, chatID, doc); err != nil {
+		return fmt.Errorf("failed to send document: %v", err)
+	}
+	return nil
+}
+
 // GetUpdatesChan returns the updates channel for the bot
 func (t *Bot) GetUpdatesChan() tgbotapi.UpdatesChannel {
 	u := tgbotapi.NewUpdate(0)
@@ -107,6 +98,19 @@ func (t *Bot) GetUpdatesChan() tgbotapi.UpdatesChannel {
 	return t.bot.GetUpdatesChan(u)
 }
 
+// StopReceivingUpdates stops long polling and closes the updates channel
+func (t *Bot) StopReceivingUpdates() {
+	t.bot.StopReceivingUpdates()
+}
+
+// Ping checks that the Telegram API is reachable and the token is valid
+func (t *Bot) Ping() error {
+	if _, err := t.bot.GetMe(); err != nil {
+		return fmt.Errorf("telegram getMe failed: %v", err)
+	}
+	return nil
+}
+
 // GetBotInfo returns information about the bot
 func (t *Bot) GetBotInfo() tgbotapi.User {
 	return t.bot.Self
@@ -123,10 +127,9 @@ func (t *Bot) AnswerCallbackQuery(callbackQueryID string, text string) error {
 }
 
 // EditMessageText edits an existing message
-func (t *Bot) EditMessageText(chatID int64, messageID int, newText string) error {
+func (t *Bot) EditMessageText(ctx context.Context, chatID int64, messageID int, newText string) error {
 	edit := tgbotapi.NewEditMessageText(chatID, messageID, newText)
-	_, err := t.bot.Send(edit)
-	if err != nil {
+	if err := t.outbox.send(ctx, chatID, edit); err != nil {
 		return fmt.Errorf("failed to edit message: %v", err)
 	}
 	return nil
@@ -0,0 +1,203 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"calendar-assistant-bot/pkg/metrics"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Telegram send limits, see https://core.telegram.org/bots/faq#my-bot-is-hitting-limits-how-do-i-avoid-this
+const (
+	// globalSendInterval keeps the bot under 30 messages per second overall
+	globalSendInterval = time.Second / 30
+	// privateChatSendInterval keeps a private chat under one message per second
+	privateChatSendInterval = time.Second
+	// groupChatSendInterval keeps a group under 20 messages per minute
+	groupChatSendInterval = 3 * time.Second
+	// maxFloodRetries is how many times a message is retried after a flood-wait error
+	maxFloodRetries = 3
+)
+
+// sendJob is a group of messages that must be sent to one chat back to back
+type sendJob struct {
+	ctx      context.Context
+	messages []tgbotapi.Chattable
+	result   chan error
+}
+
+// chatOutbox holds the jobs waiting to be sent to one chat.
+// It is kept after the last job until the chat's send interval has passed, so that the
+// next message to the chat still respects the limit.
+type chatOutbox struct {
+	jobs     []*sendJob
+	running  bool
+	nextSend time.Time
+}
+
+// outbox queues outgoing messages so that Telegram's global and per-chat limits are respected,
+// flood-wait errors are retried after the delay Telegram asks for, and messages to the same
+// chat are sent in the order they were queued
+type outbox struct {
+	bot *tgbotapi.BotAPI
+
+	mutex sync.Mutex
+	chats map[int64]*chatOutbox
+
+	globalMutex    sync.Mutex
+	globalNextSend time.Time
+}
+
+// newOutbox creates an outbox sending through bot
+func newOutbox(bot *tgbotapi.BotAPI) *outbox {
+	return &outbox{
+		bot:   bot,
+		chats: make(map[int64]*chatOutbox),
+	}
+}
+
+// send queues messages for chatID and waits until they were sent, failed, or ctx is done.
+// Messages of one call are never interleaved with messages of another call to the same chat.
+func (o *outbox) send(ctx context.Context, chatID int64, messages ...tgbotapi.Chattable) error {
+	job := &sendJob{
+		ctx:      ctx,
+		messages: messages,
+		result:   make(chan error, 1),
+	}
+
+	o.mutex.Lock()
+	chat, exists := o.chats[chatID]
+	if !exists {
+		chat = &chatOutbox{}
+		o.chats[chatID] = chat
+	}
+	chat.jobs = append(chat.jobs, job)
+	start := !chat.running
+	chat.running = true
+	o.mutex.Unlock()
+
+	if start {
+		go o.run(chatID, chat)
+	}
+
+	select {
+	case err := <-job.result:
+		return err
+	case <-ctx.Done():
+		// The worker skips or stops the job once it sees the context is done
+		return ctx.Err()
+	}
+}
+
+// run sends the queued jobs of one chat until there are none left
+func (o *outbox) run(chatID int64, chat *chatOutbox) {
+	for {
+		o.mutex.Lock()
+		if len(chat.jobs) == 0 {
+			chat.running = false
+			time.AfterFunc(time.Until(chat.nextSend), func() {
+				o.mutex.Lock()
+				defer o.mutex.Unlock()
+				if !chat.running && o.chats[chatID] == chat {
+					delete(o.chats, chatID)
+				}
+			})
+			o.mutex.Unlock()
+			return
+		}
+		job := chat.jobs[0]
+		chat.jobs = chat.jobs[1:]
+		o.mutex.Unlock()
+
+		job.result <- o.sendJob(chatID, chat, job)
+	}
+}
+
+// sendJob sends the messages of a job in order, stopping at the first failure
+func (o *outbox) sendJob(chatID int64, chat *chatOutbox, job *sendJob) error {
+	for i, message := range job.messages {
+		if err := o.sendOne(job.ctx, chatID, chat, message); err != nil {
+			if len(job.messages) > 1 {
+				return fmt.Errorf("part %d/%d: %w", i+1, len(job.messages), err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// sendOne waits for the chat and global limits, then sends message, retrying on flood-wait errors
+func (o *outbox) sendOne(ctx context.Context, chatID int64, chat *chatOutbox, message tgbotapi.Chattable) error {
+	for attempt := 0; ; attempt++ {
+		if err := sleepUntil(ctx, chat.nextSend); err != nil {
+			return err
+		}
+		if err := sleepUntil(ctx, o.reserveGlobalSlot()); err != nil {
+			return err
+		}
+
+		_, err := o.bot.Send(message)
+		chat.nextSend = time.Now().Add(chatSendInterval(chatID))
+		metrics.MessagesSent.WithLabelValues(metrics.Status(err)).Inc()
+
+		var apiErr *tgbotapi.Error
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 && attempt < maxFloodRetries {
+			retryAfter := time.Duration(apiErr.RetryAfter) * time.Second
+			log.Printf("Flood wait for chat %d, retrying in %s", chatID, retryAfter)
+			chat.nextSend = time.Now().Add(retryAfter)
+			continue
+		}
+
+		return err
+	}
+}
+
+// reserveGlobalSlot reserves the next free slot under the global limit and returns when it starts
+func (o *outbox) reserveGlobalSlot() time.Time {
+	o.globalMutex.Lock()
+	defer o.globalMutex.Unlock()
+
+	slot := time.Now()
+	if o.globalNextSend.After(slot) {
+		slot = o.globalNextSend
+	}
+	o.globalNextSend = slot.Add(globalSendInterval)
+	return slot
+}
+
+// chatSendInterval returns the minimum time between two messages to a chat.
+// Group and channel IDs are negative.
+func chatSendInterval(chatID int64) time.Duration {
+	if chatID < 0 {
+		return groupChatSendInterval
+	}
+	return privateChatSendInterval
+}
+
+// sleepUntil waits until t or until ctx is done
+func sleepUntil(ctx context.Context, t time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	delay := time.Until(t)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,186 @@
+package telegram
+
+import (
+	"unicode"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// MaxMessageLength is the Telegram limit for a single text message, measured in UTF-16 code units
+const MaxMessageLength = 4096
+
+// MessagePart is one piece of a split message together with the entities that fall inside it
+type MessagePart struct {
+	Text     string
+	Entities []tgbotapi.MessageEntity
+}
+
+// UTF16Len returns the length of text in UTF-16 code units, which is how Telegram measures messages
+func UTF16Len(text string) int {
+	n := 0
+	for _, r := range text {
+		n += runeUTF16Len(r)
+	}
+	return n
+}
+
+// runeUTF16Len returns the number of UTF-16 code units needed to encode r
+func runeUTF16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// SplitMessage splits text into parts of at most limit UTF-16 code units.
+// Parts never break a rune or a formatting entity (unless the entity alone is longer than limit),
+// and breaks are placed at paragraph, then line, then word boundaries when possible.
+// Entity offsets in the returned parts are relative to the part they belong to.
+func SplitMessage(text string, entities []tgbotapi.MessageEntity, limit int) []MessagePart {
+	runes := []rune(text)
+
+	// offsets[i] is the UTF-16 offset of runes[i]; offsets[len(runes)] is the total length
+	offsets := make([]int, len(runes)+1)
+	for i, r := range runes {
+		offsets[i+1] = offsets[i] + runeUTF16Len(r)
+	}
+
+	if limit <= 0 || offsets[len(runes)] <= limit {
+		return []MessagePart{{Text: text, Entities: entities}}
+	}
+
+	var parts []MessagePart
+	start := 0
+	for start < len(runes) {
+		// Find the furthest rune boundary that keeps the part within the limit
+		end := start
+		for end < len(runes) && offsets[end+1]-offsets[start] <= limit {
+			end++
+		}
+		if end == start {
+			// A single rune wider than the limit; emit it on its own rather than loop forever
+			end = start + 1
+		}
+
+		if end == len(runes) {
+			parts = append(parts, newMessagePart(runes, offsets, entities, start, end))
+			break
+		}
+
+		cut, next := findBreak(runes, offsets, start, end, limit)
+		if safe := avoidEntities(offsets, entities, start, cut); safe != cut {
+			// The break was moved in front of an entity, so no separator is consumed
+			cut, next = safe, safe
+			for cut > start+1 && unicode.IsSpace(runes[cut-1]) {
+				cut--
+			}
+		}
+
+		if part := newMessagePart(runes, offsets, entities, start, cut); part.Text != "" {
+			parts = append(parts, part)
+		}
+		start = next
+	}
+
+	return parts
+}
+
+// findBreak picks where to end the part that starts at start and may extend up to end (exclusive).
+// It returns the rune index where the part ends and the rune index where the next part starts,
+// skipping the separator that was split on.
+func findBreak(runes []rune, offsets []int, start, end, limit int) (int, int) {
+	minUnits := limit / 2
+
+	// Paragraph break
+	for i := end - 1; i > start; i-- {
+		if offsets[i]-offsets[start] < minUnits {
+			break
+		}
+		if runes[i] == '\n' && runes[i-1] == '\n' {
+			return i - 1, i + 1
+		}
+	}
+
+	// Line break
+	for i := end - 1; i > start; i-- {
+		if offsets[i]-offsets[start] < minUnits {
+			break
+		}
+		if runes[i] == '\n' {
+			return i, i + 1
+		}
+	}
+
+	// Word break
+	for i := end - 1; i > start; i-- {
+		if offsets[i]-offsets[start] < minUnits {
+			break
+		}
+		if runes[i] == ' ' || runes[i] == '\t' {
+			return i, i + 1
+		}
+	}
+
+	// No suitable boundary, cut at the limit
+	return end, end
+}
+
+// avoidEntities moves cut back so that it does not fall inside an entity.
+// If that would leave the part empty, the entity is longer than the limit and cut is returned unchanged.
+func avoidEntities(offsets []int, entities []tgbotapi.MessageEntity, start, cut int) int {
+	for {
+		moved := false
+		cutUnits := offsets[cut]
+		for _, entity := range entities {
+			if entity.Offset < cutUnits && cutUnits < entity.Offset+entity.Length {
+				newCut := runeIndexAt(offsets, entity.Offset)
+				if newCut <= start {
+					return cut
+				}
+				cut = newCut
+				moved = true
+				break
+			}
+		}
+		if !moved {
+			return cut
+		}
+	}
+}
+
+// runeIndexAt returns the index of the rune that starts at or before the given UTF-16 offset
+func runeIndexAt(offsets []int, units int) int {
+	for i := len(offsets) - 1; i >= 0; i-- {
+		if offsets[i] <= units {
+			return i
+		}
+	}
+	return 0
+}
+
+// newMessagePart builds the part covering runes[start:end] with entities clipped and rebased to it
+func newMessagePart(runes []rune, offsets []int, entities []tgbotapi.MessageEntity, start, end int) MessagePart {
+	part := MessagePart{Text: string(runes[start:end])}
+
+	from, to := offsets[start], offsets[end]
+	for _, entity := range entities {
+		entityStart := entity.Offset
+		entityEnd := entity.Offset + entity.Length
+		if entityEnd <= from || entityStart >= to {
+			continue
+		}
+		if entityStart < from {
+			entityStart = from
+		}
+		if entityEnd > to {
+			entityEnd = to
+		}
+
+		clipped := entity
+		clipped.Offset = entityStart - from
+		clipped.Length = entityEnd - entityStart
+		part.Entities = append(part.Entities, clipped)
+	}
+
+	return part
+}
@@ -0,0 +1,176 @@
+package telegram
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestUTF16Len(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want int
+	}{
+		{"empty", "", 0},
+		{"ascii", "hello", 5},
+		{"cyrillic", "привет", 6},
+		{"emoji outside BMP", "😀", 2},
+		{"mixed", "a😀б", 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := UTF16Len(tt.text); got != tt.want {
+				t.Errorf("UTF16Len(%q) = %d, want %d", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		entities []tgbotapi.MessageEntity
+		limit    int
+		want     []MessagePart
+	}{
+		{
+			name:  "fits in one part",
+			text:  "short message",
+			limit: 20,
+			want:  []MessagePart{{Text: "short message"}},
+		},
+		{
+			name:  "empty text",
+			text:  "",
+			limit: 10,
+			want:  []MessagePart{{Text: ""}},
+		},
+		{
+			name:  "prefers paragraph break",
+			text:  "aaaa bbbb\ncccc\n\ndddd",
+			limit: 16,
+			want:  []MessagePart{{Text: "aaaa bbbb\ncccc"}, {Text: "dddd"}},
+		},
+		{
+			name:  "falls back to line break",
+			text:  "aaaa bbbb\ncccc dddd",
+			limit: 12,
+			want:  []MessagePart{{Text: "aaaa bbbb"}, {Text: "cccc dddd"}},
+		},
+		{
+			name:  "falls back to word break",
+			text:  "aaaa bbbb cccc",
+			limit: 10,
+			want:  []MessagePart{{Text: "aaaa bbbb"}, {Text: "cccc"}},
+		},
+		{
+			name:  "hard cut without whitespace",
+			text:  "abcdefghij",
+			limit: 4,
+			want:  []MessagePart{{Text: "abcd"}, {Text: "efgh"}, {Text: "ij"}},
+		},
+		{
+			name:  "cyrillic counts code units not bytes",
+			text:  "привет мир",
+			limit: 10,
+			want:  []MessagePart{{Text: "привет мир"}},
+		},
+		{
+			name:  "emoji is never split",
+			text:  "😀😀😀",
+			limit: 3,
+			want:  []MessagePart{{Text: "😀"}, {Text: "😀"}, {Text: "😀"}},
+		},
+		{
+			name:  "emoji wider than limit is emitted alone",
+			text:  "😀a",
+			limit: 1,
+			want:  []MessagePart{{Text: "😀"}, {Text: "a"}},
+		},
+		{
+			name: "entity is kept whole",
+			text: "one two three",
+			entities: []tgbotapi.MessageEntity{
+				{Type: "bold", Offset: 4, Length: 9},
+			},
+			limit: 10,
+			want: []MessagePart{
+				{Text: "one"},
+				{Text: "two three", Entities: []tgbotapi.MessageEntity{{Type: "bold", Offset: 0, Length: 9}}},
+			},
+		},
+		{
+			name: "entity offsets are rebased and counted in UTF-16",
+			text: "😀 hi\n\nbye you",
+			entities: []tgbotapi.MessageEntity{
+				{Type: "italic", Offset: 3, Length: 2},
+				{Type: "bold", Offset: 11, Length: 3},
+			},
+			limit: 8,
+			want: []MessagePart{
+				{Text: "😀 hi", Entities: []tgbotapi.MessageEntity{{Type: "italic", Offset: 3, Length: 2}}},
+				{Text: "bye you", Entities: []tgbotapi.MessageEntity{{Type: "bold", Offset: 4, Length: 3}}},
+			},
+		},
+		{
+			name: "entity longer than limit is clipped",
+			text: "abcdefgh",
+			entities: []tgbotapi.MessageEntity{
+				{Type: "code", Offset: 0, Length: 8},
+			},
+			limit: 5,
+			want: []MessagePart{
+				{Text: "abcde", Entities: []tgbotapi.MessageEntity{{Type: "code", Offset: 0, Length: 5}}},
+				{Text: "fgh", Entities: []tgbotapi.MessageEntity{{Type: "code", Offset: 0, Length: 3}}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SplitMessage(tt.text, tt.entities, tt.limit)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitMessage(%q, %d) = %#v, want %#v", tt.text, tt.limit, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitMessageRespectsLimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+	}{
+		{"ascii words", strings.Repeat("lorem ipsum dolor ", 500), MaxMessageLength},
+		{"cyrillic lines", strings.Repeat("съешь же ещё этих булок\n", 400), MaxMessageLength},
+		{"emoji run", strings.Repeat("🎉", 5000), MaxMessageLength},
+		{"mixed small limit", strings.Repeat("a😀б ", 50), 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := SplitMessage(tt.text, nil, tt.limit)
+			if len(parts) < 2 {
+				t.Fatalf("expected the text to be split, got %d part(s)", len(parts))
+			}
+			for i, part := range parts {
+				if n := UTF16Len(part.Text); n > tt.limit {
+					t.Errorf("part %d has %d UTF-16 units, limit is %d", i, n, tt.limit)
+				}
+				if !utf8.ValidString(part.Text) {
+					t.Errorf("part %d is not valid UTF-8", i)
+				}
+				if part.Text == "" {
+					t.Errorf("part %d is empty", i)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package telegram
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// secretTokenHeader is the header Telegram uses to send the webhook secret token
+const secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// Webhook receives updates pushed by Telegram over HTTP
+type Webhook struct {
+	bot       *tgbotapi.BotAPI
+	secret    string
+	updates   chan tgbotapi.Update
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// SetWebhook registers the webhook URL with Telegram.
+// Telegram will send the secret in the X-Telegram-Bot-Api-Secret-Token header of every request.
+func (t *Bot) SetWebhook(webhookURL, secret string) error {
+	params := make(tgbotapi.Params)
+	params["url"] = webhookURL
+	params.AddNonEmpty("secret_token", secret)
+
+	if _, err := t.bot.MakeRequest("setWebhook", params); err != nil {
+		return fmt.Errorf("failed to set webhook: %v", err)
+	}
+
+	log.Printf("Telegram webhook registered: %s", webhookURL)
+	return nil
+}
+
+// DeleteWebhook removes the webhook registration from Telegram
+func (t *Bot) DeleteWebhook() error {
+	if _, err := t.bot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+		return fmt.Errorf("failed to delete webhook: %v", err)
+	}
+
+	log.Printf("Telegram webhook deleted")
+	return nil
+}
+
+// NewWebhook creates a webhook handler that only accepts requests carrying the given secret token
+func (t *Bot) NewWebhook(secret string) *Webhook {
+	return &Webhook{
+		bot:     t.bot,
+		secret:  secret,
+		updates: make(chan tgbotapi.Update, t.bot.Buffer),
+		done:    make(chan struct{}),
+	}
+}
+
+// Updates returns the channel the received updates are delivered to.
+// The channel is never closed; consumers should stop reading once they stop the webhook.
+func (w *Webhook) Updates() tgbotapi.UpdatesChannel {
+	return w.updates
+}
+
+// ServeHTTP verifies and decodes a single update sent by Telegram
+func (w *Webhook) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get(secretTokenHeader)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(w.secret)) != 1 {
+		log.Printf("Rejected webhook request from %s: invalid secret token", r.RemoteAddr)
+		http.Error(rw, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	update, err := w.bot.HandleUpdate(r)
+	if err != nil {
+		log.Printf("Failed to decode webhook update: %v", err)
+		http.Error(rw, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	// Telegram redelivers any update we don't acknowledge with a 2xx status
+	select {
+	case w.updates <- *update:
+		rw.WriteHeader(http.StatusOK)
+	case <-w.done:
+		http.Error(rw, "shutting down", http.StatusServiceUnavailable)
+	case <-r.Context().Done():
+		http.Error(rw, "request canceled", http.StatusServiceUnavailable)
+	}
+}
+
+// Close stops accepting updates; requests arriving afterwards are rejected so Telegram retries them later
+func (w *Webhook) Close() {
+	w.closeOnce.Do(func() {
+		close(w.done)
+	})
+}
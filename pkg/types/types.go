@@ -7,11 +7,21 @@ type AIResponse struct {
 	Action     string `json:"action"`
 	Message    string `json:"message"`
 	EventID    string `json:"event_id,omitempty"`
+	EventRef   string `json:"event_ref,omitempty"` // an event shown earlier, by number or title
 	EventTitle string `json:"event_title,omitempty"`
 	EventDate  string `json:"event_date,omitempty"`
 	EventTime  string `json:"event_time,omitempty"`
 	EventDesc  string `json:"event_description,omitempty"`
 	EventLoc   string `json:"event_location,omitempty"`
+	// Calendar is the name of the calendar to add an event to; empty means the user's default
+	Calendar string `json:"calendar,omitempty"`
+	// Date range of getEventsRange and searchEvents (YYYY-MM-DD, inclusive)
+	StartDate string `json:"start_date,omitempty"`
+	EndDate   string `json:"end_date,omitempty"`
+	// Search fields; the location filter is EventLoc
+	Query    string `json:"query,omitempty"`
+	Attendee string `json:"attendee,omitempty"`
+	Page     int    `json:"page,omitempty"`
 	// For complex requests, AI can specify multiple actions
 	Actions []AIAction `json:"actions,omitempty"`
 }
@@ -24,6 +34,8 @@ type AIAction struct {
 	EventTime  string `json:"event_time,omitempty"`
 	EventDesc  string `json:"event_description,omitempty"`
 	EventLoc   string `json:"event_location,omitempty"`
+	StartDate  string `json:"start_date,omitempty"`
+	EndDate    string `json:"end_date,omitempty"`
 }
 
 // CalendarEvent represents a calendar event
@@ -34,6 +46,42 @@ type CalendarEvent struct {
 	Start       time.Time `json:"start"`
 	End         time.Time `json:"end"`
 	Location    string    `json:"location"`
+	Attendees   []string  `json:"attendees,omitempty"`
+	Organizer   string    `json:"organizer,omitempty"`
+	CalendarID  string    `json:"calendar_id,omitempty"`
+	// Calendar is the name of the calendar the event is in, set when reading from several calendars
+	Calendar string `json:"calendar,omitempty"`
+	// Cancelled is only set on events returned by a sync, which reports deleted events as cancelled
+	Cancelled bool `json:"cancelled,omitempty"`
+}
+
+// CalendarInfo describes a calendar available to the credentials
+type CalendarInfo struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Color   string `json:"color,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+	// Writable is false for calendars the credentials can only read, such as holiday calendars
+	Writable bool `json:"writable"`
+}
+
+// CalendarSelection is the calendars a user reads from and the one new events are added to
+type CalendarSelection struct {
+	Read  []CalendarInfo `json:"read"`
+	Write CalendarInfo   `json:"write"`
+}
+
+// CalendarWatch is the calendars a user gets change notifications for, and the chat they go to
+type CalendarWatch struct {
+	ChatID    int64          `json:"chat_id"`
+	Calendars []CalendarInfo `json:"calendars"`
+}
+
+// GoogleAccount is a Google account a user connected with /login.
+// It is stored in the user's state, which is encrypted: /login requires an encryption key.
+type GoogleAccount struct {
+	RefreshToken string    `json:"refresh_token"`
+	ConnectedAt  time.Time `json:"connected_at"`
 }
 
 // Interaction represents a single interaction with the AI
@@ -43,4 +91,35 @@ type Interaction struct {
 	UserMessage string    `json:"user_message"`
 	AIResponse  string    `json:"ai_response"`
 	Action      string    `json:"action,omitempty"`
+	// ToolResults is what the executed actions returned, including event IDs
+	ToolResults string `json:"tool_results,omitempty"`
+	// Reply is the message the user was actually shown
+	Reply string `json:"reply,omitempty"`
+}
+
+// ConversationSummary condenses the interactions of a user up to and including Through
+type ConversationSummary struct {
+	Text    string    `json:"text"`
+	Through time.Time `json:"through"`
+}
+
+// DisplayedEvent is an event in the last list shown in a chat, numbered as the user saw it
+type DisplayedEvent struct {
+	Index      int       `json:"index"`
+	ID         string    `json:"id"`
+	CalendarID string    `json:"calendar_id,omitempty"`
+	Title      string    `json:"title"`
+	Start      time.Time `json:"start"`
+	// End gives the duration a moved event keeps; zero in lists stored without it
+	End time.Time `json:"end,omitempty"`
+}
+
+// Dialogue is a request waiting for the user to fill in what it was missing, stored per chat
+type Dialogue struct {
+	// UserID is who made the request; in a group chat, each member has their own
+	UserID int64      `json:"user_id"`
+	Intent AIResponse `json:"intent"`
+	// Missing are the JSON names of the fields still to fill, in the order they are asked for
+	Missing   []string  `json:"missing"`
+	ExpiresAt time.Time `json:"expires_at"`
 }